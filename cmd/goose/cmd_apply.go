@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/CloudCom/goose/lib/goose"
+)
+
+var applyCmd = &Command{
+	Name:    "apply",
+	Usage:   "<filename> [up|down]",
+	Summary: "Run a single migration, named by its filename",
+	Help: `apply runs the one migration in the migrations directory with the
+given filename, up unless down is given, and records it in the version
+table.
+
+It refuses to apply a migration older than the current version, or to
+roll back one other than the current version, unless -out-of-order is
+given.`,
+	Run: applyRun,
+}
+
+var applyOutOfOrder bool
+
+func init() {
+	applyCmd.Flag.BoolVar(&applyOutOfOrder, "out-of-order", false, "allow running a migration other than the next or the current one")
+}
+
+func applyRun(cmd *Command, args ...string) {
+	if len(args) < 1 || len(args) > 2 {
+		cmd.Flag.Usage()
+		os.Exit(1)
+	}
+
+	direction := goose.DirectionUp
+	if len(args) == 2 {
+		switch args[1] {
+		case "up":
+		case "down":
+			direction = goose.DirectionDown
+		default:
+			log.Fatalf("invalid direction %q, must be up or down", args[1])
+		}
+	}
+
+	conf, err := dbConfFromFlags()
+	if err != nil {
+		log.Fatal(err)
+	}
+	conf.AllowOutOfOrder = applyOutOfOrder
+
+	db, err := goose.OpenDBFromDBConf(conf)
+	if err != nil {
+		log.Fatal("couldn't open DB:", err)
+	}
+	defer db.Close()
+
+	if err := goose.ApplyFile(conf, conf.MigrationsDir, args[0], direction, db); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/CloudCom/goose/lib/goose"
+)
+
+var baselineCmd = &Command{
+	Name:    "baseline",
+	Usage:   "<version>",
+	Summary: "Record the migrations up to a version as applied, without running them",
+	Help: `baseline records every migration at or below the given version as
+applied, without running it, for adopting goose on a database whose
+schema is already in place.
+
+It refuses to run if any migration is applied already.`,
+	Run: baselineRun,
+}
+
+func baselineRun(cmd *Command, args ...string) {
+	if len(args) != 1 {
+		cmd.Flag.Usage()
+		os.Exit(1)
+	}
+
+	version, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		log.Fatalf("invalid version %q: %s", args[0], err)
+	}
+
+	conf, err := dbConfFromFlags()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := goose.OpenDBFromDBConf(conf)
+	if err != nil {
+		log.Fatal("couldn't open DB:", err)
+	}
+	defer db.Close()
+
+	baselined, err := goose.Baseline(conf, conf.MigrationsDir, version, db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, v := range baselined {
+		fmt.Printf("goose: baselined %d\n", v)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"text/template"
 	"time"
 
 	"github.com/CloudCom/goose/lib/goose"
@@ -19,9 +20,13 @@ var createCmd = &Command{
 }
 
 var migrationType string
+var createSequential bool
+var createTemplate string
 
 func init() {
 	createCmd.Flag.StringVar(&migrationType, "type", "sql", "type of migration to create [sql,go]")
+	createCmd.Flag.BoolVar(&createSequential, "seq", false, "number the migration sequentially rather than by timestamp")
+	createCmd.Flag.StringVar(&createTemplate, "template", "", "text/template file to scaffold the migration with, given .Version and .Name")
 }
 
 func createRun(cmd *Command, args ...string) {
@@ -35,11 +40,25 @@ func createRun(cmd *Command, args ...string) {
 		log.Fatal(err)
 	}
 
-	if err = os.MkdirAll(conf.MigrationsDir, 0750); err != nil {
+	// new migrations go in the first of several migrations directories
+	dir := filepath.SplitList(conf.MigrationsDir)[0]
+	if err = os.MkdirAll(dir, 0750); err != nil {
 		log.Fatal(err)
 	}
 
-	n, err := goose.CreateMigration(args[0], migrationType, conf.MigrationsDir, time.Now())
+	numbering := goose.TimestampNumbering
+	if createSequential {
+		numbering = goose.SequentialNumbering
+	}
+
+	var tmpl *template.Template
+	if createTemplate != "" {
+		if tmpl, err = template.ParseFiles(createTemplate); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	n, err := goose.CreateMigrationWithTemplate(args[0], migrationType, conf.MigrationsDir, time.Now(), numbering, tmpl)
 	if err != nil {
 		log.Fatal(err)
 	}
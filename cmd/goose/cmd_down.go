@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"os"
 
 	"github.com/CloudCom/goose/lib/goose"
 )
@@ -14,6 +15,12 @@ var downCmd = &Command{
 	Run:     downRun,
 }
 
+var downDryRun bool
+
+func init() {
+	downCmd.Flag.BoolVar(&downDryRun, "dry-run", false, "print the SQL that would run, without running it")
+}
+
 func downRun(cmd *Command, args ...string) {
 
 	conf, err := dbConfFromFlags()
@@ -21,6 +28,11 @@ func downRun(cmd *Command, args ...string) {
 		log.Fatal(err)
 	}
 
+	if downDryRun {
+		downDryRunRun(conf)
+		return
+	}
+
 	current, err := goose.GetDBVersion(conf)
 	if err != nil {
 		log.Fatal(err)
@@ -35,3 +47,27 @@ func downRun(cmd *Command, args ...string) {
 		log.Fatal(err)
 	}
 }
+
+// like downRun, but print the rollback rather than running it,
+// and don't create the version table if it is missing.
+func downDryRunRun(conf *goose.DBConf) {
+	db, err := goose.OpenDBFromDBConf(conf)
+	if err != nil {
+		log.Fatal("couldn't open DB:", err)
+	}
+	defer db.Close()
+
+	current, err := goose.GetDBVersionOnDb(conf, db)
+	if err != nil && err != goose.ErrTableDoesNotExist {
+		log.Fatal(err)
+	}
+
+	previous, err := goose.GetPreviousDBVersion(conf.MigrationsDir, current)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := goose.DryRun(conf, conf.MigrationsDir, previous, db, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+
+	"github.com/CloudCom/goose/lib/goose"
+)
+
+var fixCmd = &Command{
+	Name:    "fix",
+	Usage:   "",
+	Summary: "Renumber timestamped migrations sequentially",
+	Help: `fix renames each migration versioned by timestamp to follow the
+highest sequentially numbered migration, in timestamp order.
+
+It refuses to run if any of them are applied to the database of the
+selected environment. Other environments are not checked.`,
+	Run: fixRun,
+}
+
+func fixRun(cmd *Command, args ...string) {
+	conf, err := dbConfFromFlags()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := goose.OpenDBFromDBConf(conf)
+	if err != nil {
+		log.Fatal("couldn't open DB:", err)
+	}
+	defer db.Close()
+
+	renamed, err := goose.FixOnDb(conf, conf.MigrationsDir, db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var olds []string
+	for old := range renamed {
+		olds = append(olds, old)
+	}
+	sort.Strings(olds)
+	for _, old := range olds {
+		fmt.Printf("goose: renamed %s to %s\n", filepath.Base(old), filepath.Base(renamed[old]))
+	}
+}
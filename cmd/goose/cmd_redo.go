@@ -20,21 +20,13 @@ func redoRun(cmd *Command, args ...string) {
 		log.Fatal("Error loading config file:", err)
 	}
 
-	current, err := goose.GetDBVersion(conf)
+	db, err := goose.OpenDBFromDBConf(conf)
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	previous, err := goose.GetPreviousDBVersion(conf.MigrationsDir, current)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	if err := goose.RunMigrations(conf, conf.MigrationsDir, previous); err != nil {
-		log.Fatal(err)
+		log.Fatal("couldn't open DB:", err)
 	}
+	defer db.Close()
 
-	if err := goose.RunMigrations(conf, conf.MigrationsDir, current); err != nil {
+	if _, err := goose.Redo(conf, conf.MigrationsDir, db); err != nil {
 		log.Fatal(err)
 	}
 }
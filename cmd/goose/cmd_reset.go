@@ -0,0 +1,32 @@
+package main
+
+import (
+	"log"
+
+	"github.com/CloudCom/goose/lib/goose"
+)
+
+var resetCmd = &Command{
+	Name:    "reset",
+	Usage:   "",
+	Summary: "Roll back all migrations",
+	Help:    `reset extended help here...`,
+	Run:     resetRun,
+}
+
+func resetRun(cmd *Command, args ...string) {
+	conf, err := dbConfFromFlags()
+	if err != nil {
+		log.Fatal("Error loading config file:", err)
+	}
+
+	db, err := goose.OpenDBFromDBConf(conf)
+	if err != nil {
+		log.Fatal("couldn't open DB:", err)
+	}
+	defer db.Close()
+
+	if _, err := goose.Reset(conf, conf.MigrationsDir, db); err != nil {
+		log.Fatal(err)
+	}
+}
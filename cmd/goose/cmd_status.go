@@ -1,9 +1,9 @@
 package main
 
 import (
-	"database/sql"
 	"fmt"
 	"log"
+	"os"
 	"path/filepath"
 	"time"
 
@@ -18,6 +18,37 @@ var statusCmd = &Command{
 	Run:     statusRun,
 }
 
+var statusJSON bool
+var statusSince string
+var statusLast int
+
+func init() {
+	statusCmd.Flag.BoolVar(&statusJSON, "json", false, "print the status as JSON")
+	statusCmd.Flag.StringVar(&statusSince, "since", "", "show only migrations applied after this RFC3339 time, or within this duration of now, e.g. 24h")
+	statusCmd.Flag.IntVar(&statusLast, "last", 0, "show only the last N applied migrations")
+}
+
+// the StatusFilter the -since and -last flags ask for
+func statusFilter() (goose.StatusFilter, error) {
+	filter := goose.StatusFilter{Last: statusLast}
+	if statusLast < 0 {
+		return filter, fmt.Errorf("invalid -last %d: must not be negative", statusLast)
+	}
+	if statusSince == "" {
+		return filter, nil
+	}
+	if d, err := time.ParseDuration(statusSince); err == nil {
+		filter.Since = time.Now().Add(-d)
+		return filter, nil
+	}
+	since, err := time.Parse(time.RFC3339, statusSince)
+	if err != nil {
+		return filter, fmt.Errorf("invalid -since %q: must be an RFC3339 time or a duration", statusSince)
+	}
+	filter.Since = since
+	return filter, nil
+}
+
 type StatusData struct {
 	Source string
 	Status string
@@ -29,11 +60,9 @@ func statusRun(cmd *Command, args ...string) {
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	// collect all migrations
-	migrations, e := goose.CollectMigrations(conf.MigrationsDir)
-	if e != nil {
-		log.Fatal(e)
+	filter, err := statusFilter()
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	db, e := goose.OpenDBFromDBConf(conf)
@@ -42,8 +71,16 @@ func statusRun(cmd *Command, args ...string) {
 	}
 	defer db.Close()
 
-	// must ensure that the version table exists if we're running on a pristine DB
-	if _, e := goose.EnsureDBVersion(conf, db); e != nil {
+	if statusJSON {
+		if e := goose.StatusJSONFiltered(conf, conf.MigrationsDir, db, os.Stdout, filter); e != nil {
+			log.Fatal(e)
+		}
+		return
+	}
+
+	// collect all migrations, creating the version table on a pristine DB
+	migrations, e := goose.Status(conf, conf.MigrationsDir, db, filter)
+	if e != nil {
 		log.Fatal(e)
 	}
 
@@ -51,26 +88,18 @@ func statusRun(cmd *Command, args ...string) {
 	fmt.Println("    Applied At                  Migration")
 	fmt.Println("    =======================================")
 	for _, m := range migrations {
-		printMigrationStatus(db, m.Version, filepath.Base(m.Source))
+		printMigrationStatus(m)
 	}
 }
 
-func printMigrationStatus(db *sql.DB, version int64, script string) {
-	var row goose.Migration
-	q := fmt.Sprintf("SELECT tstamp, is_applied FROM goose_db_version WHERE version_id=%d ORDER BY tstamp DESC LIMIT 1", version)
-	e := db.QueryRow(q).Scan(&row.TStamp, &row.IsApplied)
-
-	if e != nil && e != sql.ErrNoRows {
-		log.Fatal(e)
-	}
-
+func printMigrationStatus(m *goose.Migration) {
 	var appliedAt string
 
-	if row.IsApplied {
-		appliedAt = row.TStamp.Format(time.ANSIC)
+	if m.IsApplied {
+		appliedAt = m.TStamp.Format(time.ANSIC)
 	} else {
 		appliedAt = "Pending"
 	}
 
-	fmt.Printf("    %-24s -- %v\n", appliedAt, script)
+	fmt.Printf("    %-24s -- %v\n", appliedAt, filepath.Base(m.Source))
 }
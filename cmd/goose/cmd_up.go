@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"os"
 
 	"github.com/CloudCom/goose/lib/goose"
 )
@@ -14,18 +15,40 @@ var upCmd = &Command{
 	Run:     upRun,
 }
 
+var upDryRun bool
+var upSingleTransaction bool
+
+func init() {
+	upCmd.Flag.BoolVar(&upDryRun, "dry-run", false, "print the SQL that would run, without running it")
+	upCmd.Flag.BoolVar(&upSingleTransaction, "single-transaction", false, "apply the SQL migrations in one transaction, all or nothing")
+}
+
 func upRun(cmd *Command, args ...string) {
 
 	conf, err := dbConfFromFlags()
 	if err != nil {
 		log.Fatal("Error loading config file:", err)
 	}
+	conf.SingleTransaction = upSingleTransaction
 
 	target, err := goose.GetMostRecentDBVersion(conf.MigrationsDir)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if upDryRun {
+		db, err := goose.OpenDBFromDBConf(conf)
+		if err != nil {
+			log.Fatal("couldn't open DB:", err)
+		}
+		defer db.Close()
+
+		if err := goose.DryRun(conf, conf.MigrationsDir, target, db, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	if err := goose.RunMigrations(conf, conf.MigrationsDir, target); err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/CloudCom/goose/lib/goose"
+)
+
+var validateCmd = &Command{
+	Name:    "validate",
+	Usage:   "",
+	Summary: "Check the migrations for malformed names and annotations",
+	Help: `validate checks every migration in the migrations directory without
+connecting to the database, and prints each problem found: filenames
+not of the form <version>_<name>.sql or .go, versions with more than
+one file, SQL migrations with no Up annotation, and StatementBegin and
+StatementEnd annotations that don't pair up.
+
+It exits with a non-zero status if there are any.`,
+	Run: validateRun,
+}
+
+func validateRun(cmd *Command, args ...string) {
+	conf, err := dbConfFromFlags()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	errs := goose.Validate(conf.MigrationsDir)
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+}
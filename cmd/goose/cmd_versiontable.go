@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/CloudCom/goose/lib/goose"
+)
+
+var versionTableCmd = &Command{
+	Name:    "versiontable",
+	Usage:   "",
+	Summary: "Print the SQL that creates and fills the version table",
+	Help: `versiontable prints the statement goose creates its version table with,
+for the configured dialect, schema and table name, followed by the
+statement it records versions with. Run the first, then the second with
+0 and true as its parameters, to provision the table ahead of time.
+Nothing is run against the database.`,
+	Run: versionTableRun,
+}
+
+func versionTableRun(cmd *Command, args ...string) {
+	conf, err := dbConfFromFlags()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(goose.VersionTableSQL(conf))
+	fmt.Println()
+	fmt.Println("-- parameters: version_id, is_applied; goose inserts 0, true after creating the table")
+	fmt.Println(goose.VersionInsertSQL(conf))
+}
@@ -13,21 +13,67 @@ import (
 // global options. available to any subcommands.
 var flagPath = flag.String("path", "db", "folder containing db info")
 var flagEnv = flag.String("env", "development", "which DB environment to use")
+var flagPgSchema = flag.String("pgschema", "", "which schema to keep the version table in, and to run migrations in")
+var flagTable = flag.String("table", "goose_db_version", "which table to track applied migrations in")
+var flagStatementTimeout = flag.Duration("statement-timeout", 0, "how long each migration statement may run (postgres, cockroach); 0 for no limit")
+var flagLockWaitTimeout = flag.Duration("lock-wait-timeout", 0, "how long each migration statement may wait for a lock (postgres, cockroach, mysql); 0 for no limit")
+var flagNoCreateTable = flag.Bool("no-create-table", false, "fail rather than create the version table if it is missing")
+var flagUTCTimestamps = flag.Bool("utc-timestamps", false, "create the version table to record times in UTC")
+var flagOnly = flag.String("only", "", "run only the migrations of this type [sql,go], skipping the others")
+var flagExtensions = flag.String("extensions", ".sql,.go", "comma separated extensions of the files that are migrations")
+var flagArchive = flag.String("archive", "", "read migrations from this zip, tar or tar.gz archive, rather than the migrations directory of dbconf.yml")
 
 var drivers []string
 
 // helper to create a DBConf from the given flags
 func dbConfFromFlags() (dbconf *goose.DBConf, err error) {
-	return goose.NewDBConf(*flagPath, *flagEnv)
+	if err := goose.SetSchema(*flagPgSchema); err != nil {
+		return nil, err
+	}
+	if *flagPgSchema != "" && strings.Contains(*flagTable, ".") {
+		return nil, fmt.Errorf("-table %q is qualified with a schema, so -pgschema cannot be given too", *flagTable)
+	}
+	if err := goose.SetTableName(*flagTable); err != nil {
+		return nil, err
+	}
+	goose.SetUTCTimestamps(*flagUTCTimestamps)
+	if err := goose.SetMigrationExtensions(strings.Split(*flagExtensions, ",")...); err != nil {
+		return nil, err
+	}
+	if *flagOnly != "" && *flagOnly != "sql" && *flagOnly != "go" {
+		return nil, fmt.Errorf("invalid -only %q: must be sql or go", *flagOnly)
+	}
+	if dbconf, err = goose.NewDBConf(*flagPath, *flagEnv); err != nil {
+		return nil, err
+	}
+	dbconf.StatementTimeout = *flagStatementTimeout
+	dbconf.LockWaitTimeout = *flagLockWaitTimeout
+	dbconf.NoCreateVersionTable = *flagNoCreateTable
+	dbconf.MigrationType = *flagOnly
+	if *flagArchive != "" {
+		fsys, err := goose.OpenArchive(*flagArchive)
+		if err != nil {
+			return nil, err
+		}
+		goose.SetBaseFS(fsys)
+		dbconf.MigrationsDir = "."
+	}
+	return dbconf, nil
 }
 
 var commands = []*Command{
 	upCmd,
 	downCmd,
 	redoCmd,
+	resetCmd,
 	statusCmd,
 	createCmd,
+	fixCmd,
+	validateCmd,
+	baselineCmd,
+	applyCmd,
 	dbVersionCmd,
+	versionTableCmd,
 	driversCmd,
 }
 
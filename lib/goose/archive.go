@@ -0,0 +1,102 @@
+package goose
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// OpenArchive reads the zip, tar or gzipped tar archive at name, telling
+// them apart by their contents rather than the file extension, and returns
+// its files as an fs.FS for SetBaseFS. This lets migrations packaged at
+// build time as one artifact be run without unpacking it first:
+//
+//	fsys, err := goose.OpenArchive("migrations.tar.gz")
+//	if err != nil {
+//		return err
+//	}
+//	goose.SetBaseFS(fsys)
+//
+// Migration directories are then paths within the archive, "." being its
+// root. Entries that aren't migrations, as SetMigrationExtensions has them,
+// are ignored, as they are in a directory, and migrations run in version
+// order whatever order the archive has them in.
+//
+// The archive is read into memory in full, so nothing is left open.
+func OpenArchive(name string) (fs.FS, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	fsys, err := archiveFS(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return fsys, nil
+}
+
+// the files of the zip, tar or gzipped tar archive b
+func archiveFS(b []byte) (fs.FS, error) {
+	switch {
+	case bytes.HasPrefix(b, []byte("PK\x03\x04")), bytes.HasPrefix(b, []byte("PK\x05\x06")):
+		return zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	case bytes.HasPrefix(b, []byte("\x1f\x8b")):
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		if b, err = io.ReadAll(zr); err != nil {
+			return nil, err
+		}
+	}
+	return tarFS(tar.NewReader(bytes.NewReader(b)))
+}
+
+// the files of the tar archive tr. They are repacked, uncompressed, into
+// a zip archive in memory, as *zip.Reader already serves one as an fs.FS,
+// directories and all.
+func tarFS(tr *tar.Reader) (fs.FS, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	files := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading tar archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue // directories are implied by the files in them
+		}
+
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if !fs.ValidPath(name) {
+			return nil, fmt.Errorf("reading tar archive: invalid file name %q", hdr.Name)
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: hdr.ModTime})
+		if err != nil {
+			return nil, err
+		}
+		if _, err = io.Copy(w, tr); err != nil {
+			return nil, fmt.Errorf("reading tar archive: %w", err)
+		}
+		files++
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if files == 0 {
+		return nil, errors.New("not a zip or tar archive, or one with no files")
+	}
+	return zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+}
@@ -0,0 +1,108 @@
+package goose
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+)
+
+// ChecksumMismatch describes an applied migration whose script no longer
+// matches the checksum recorded when it was applied.
+type ChecksumMismatch struct {
+	Version  int64
+	Source   string
+	Recorded string // hex encoded SHA-256
+	Actual   string
+}
+
+// hex encoded SHA-256 of the migration script at path
+func migrationChecksum(path string) (string, error) {
+	b, err := fs.ReadFile(baseFS, path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Verify compares each applied migration in migrationsDir against the
+// checksum recorded when it was applied, and returns those that differ.
+//
+// Checksums are only recorded when DBConf.RecordChecksums is set, so
+// migrations applied without one are not checked. A version table created
+// by an older version of goose has no checksum column to record them in,
+// and Verify fails saying so.
+func Verify(conf *DBConf, migrationsDir string, db *sql.DB) ([]ChecksumMismatch, error) {
+	ctx := context.Background()
+	e := DBExecutor(db)
+	rows, err := dbChecksumQuery(ctx, conf.Driver.Dialect, e)
+	if err != nil {
+		if err == ErrTableDoesNotExist {
+			return nil, nil
+		}
+		// the table reads fine without the checksum, so the column is missing
+		if vrows, verr := dbVersionQuery(ctx, conf.Driver.Dialect, e); verr == nil {
+			vrows.Close()
+			return nil, fmt.Errorf("the version table has no checksum column, as those created by older versions of goose do not; add a nullable checksum column, as the README explains: %w", err)
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	// rows are newest first, so the first row seen for a version is its current state
+	seen := map[int64]bool{}
+	recorded := map[int64]string{}
+	for rows.Next() {
+		var (
+			version   int64
+			isApplied bool
+			checksum  sql.NullString
+		)
+		if err := rows.Scan(&version, &isApplied, &checksum); err != nil {
+			return nil, err
+		}
+
+		if seen[version] {
+			continue
+		}
+		seen[version] = true
+
+		if isApplied && checksum.Valid {
+			recorded[version] = checksum.String
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	migrations, err := collectMigrations(migrationsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var mismatches []ChecksumMismatch
+	for _, m := range migrations {
+		want, ok := recorded[m.Version]
+		if !ok {
+			continue
+		}
+
+		got, err := migrationChecksum(m.Source)
+		if err != nil {
+			return nil, err
+		}
+		if got != want {
+			mismatches = append(mismatches, ChecksumMismatch{
+				Version:  m.Version,
+				Source:   m.Source,
+				Recorded: want,
+				Actual:   got,
+			})
+		}
+	}
+
+	return mismatches, nil
+}
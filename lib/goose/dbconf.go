@@ -7,7 +7,10 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/kylelemons/go-gypsy/yaml"
 )
@@ -24,6 +27,122 @@ type DBDriver struct {
 type DBConf struct {
 	MigrationsDir string
 	Driver        DBDriver
+	LockMode      LockMode
+	LockTimeout   time.Duration // how long to wait for the migration lock; 0 waits forever
+
+	// StatementTimeout and LockWaitTimeout bound how long each statement of
+	// a migration may run, and wait for a table or row lock, on postgres
+	// and cockroach; mysql only supports LockWaitTimeout. A statement that
+	// runs out of time fails its migration, which is rolled back. 0 leaves
+	// the database's own limit in place.
+	StatementTimeout time.Duration
+	LockWaitTimeout  time.Duration
+
+	// SingleTransaction runs the SQL migrations of a run in one transaction,
+	// version table updates included, so that if one fails none of them
+	// stay applied. NO TRANSACTION and Go migrations run outside of it, and
+	// the transaction is committed before each of them and a new one begun
+	// after.
+	SingleTransaction bool
+
+	// NoCreateVersionTable makes a missing version table an error, rather
+	// than creating it, for accounts that may not run DDL. See
+	// VersionTableSQL.
+	NoCreateVersionTable bool
+
+	// RecordChecksums stores a checksum of each migration as it is applied,
+	// for Verify. The version table must have a nullable checksum column,
+	// as tables created by older versions of goose do not.
+	RecordChecksums bool
+
+	// AllowOutOfOrder lets UpByOne apply a pending migration whose version
+	// is below the current version of the database, such as one merged in
+	// from a branch after newer migrations were applied. ApplyFile also
+	// takes it to allow rolling back a migration other than the newest.
+	AllowOutOfOrder bool
+
+	// AllowMissing lists versions known to be applied whose migration
+	// files were deleted on purpose, such as when pruning old migrations.
+	// Diff leaves them out of its orphans, logging that it did, so that
+	// the suppression is visible.
+	AllowMissing []int64
+
+	// RecordAppliedBy stores who applied each migration, as set with
+	// SetAppliedBy, and the filename of its script. The version table must
+	// have nullable applied_by and source_file columns, as tables created
+	// by older versions of goose do not.
+	RecordAppliedBy bool
+
+	// SubstituteVars expands ${NAME} and $NAME references in SQL migrations
+	// before they run, taking values from Vars, or from the environment if
+	// Vars is nil. References to unset variables are left as they are.
+	// Without it, only ENVSUB ON/OFF regions of a migration are expanded.
+	SubstituteVars bool
+	Vars           map[string]string
+
+	// StripComments removes -- and /* */ comments from each statement of
+	// an SQL migration before it is sent, for drivers that choke on them.
+	// Comments in quotes are kept, as are optimizer hints and mysql's
+	// executable comments, /*+ */ and /*! */, which are not comments to
+	// the database.
+	StripComments bool
+
+	// StatementRewriter, if set, is given each statement of an SQL
+	// migration, along with the dialect, once variables are expanded and
+	// comments stripped, and the statement returned runs in its place,
+	// for per-dialect tweaks to one set of migrations. An error fails the
+	// migration before any of its statements run. DryRun prints the
+	// statements as rewritten.
+	StatementRewriter func(dialect SqlDialect, sql string) (string, error)
+
+	// MigrationType, if "sql" or "go", makes runs skip migrations of the
+	// other type, leaving them pending or applied as they are. Skipping a
+	// pending migration leaves a gap below the versions applied after it,
+	// which RunMigrationsOnDb and UpTo fill in on a later run, but UpByOne
+	// only does with AllowOutOfOrder set.
+	MigrationType string
+
+	// Clock, if set, gives the tstamp of each version table row recorded
+	// for a migration, in place of the database's default, for tests that
+	// need deterministic timestamps and for importing history with
+	// Baseline. It is called once for each row, and not by Go migrations,
+	// whose rows keep the default.
+	Clock func() time.Time
+
+	// Retry retries reading and creating the version table on errors
+	// that look transient, such as a connection reset by a failover.
+	Retry RetryPolicy
+
+	// DeadlockRetry retries SQL migrations that fail on a deadlock or a
+	// lock wait timeout, on dialects that can tell them apart, which for
+	// now are mysql and mariadb. A migration run in a transaction is
+	// re-run from the start, once the transaction is rolled back; one
+	// run statement by statement outside of one, as all SQL migrations
+	// are on mysql, retries just the statement that failed, which is all
+	// the database undid. Other errors are never retried, nor are Go
+	// migrations or SingleTransaction runs.
+	DeadlockRetry RetryPolicy
+
+	// ConfirmDown, if set, is asked before each migration is rolled back,
+	// with its version and script, and the rollback fails with
+	// ErrDownNotConfirmed unless it returns true. Rollbacks already done
+	// in the run stay done. It is not passed on to Go migrations.
+	ConfirmDown func(version int64, source string) (bool, error)
+
+	// OnMigrationStart and OnMigrationDone, if set, are called as each
+	// migration of a run begins and ends, with the error it failed with,
+	// if any. OnStatement, if set, is called before each statement of an
+	// SQL migration runs, with its index, counting from 0, and the number
+	// of statements. They are called in order on the goroutine running
+	// the migrations, and not by DryRun or Go migrations.
+	OnMigrationStart func(version int64, source string, direction Direction)
+	OnMigrationDone  func(version int64, source string, direction Direction, err error)
+	OnStatement      func(version int64, index, total int, sql string)
+
+	// Metrics, if set, is told how long each migration of a run took, and
+	// whether it failed, for monitoring slow or flaky migrations. It is
+	// called just before OnMigrationDone, and likewise not by DryRun.
+	Metrics MetricsObserver
 }
 
 var defaultDBConfYaml = `
@@ -103,11 +222,14 @@ func NewDBConf(dbDir, env string) (*DBConf, error) {
 
 	migrationsDir := filepath.Join(dbDir, "migrations")
 	if md, err := confGet(f, env, "migrationsDir"); err == nil {
-		if filepath.IsAbs(md) {
-			migrationsDir = md
-		} else {
-			migrationsDir = filepath.Join(dbDir, md)
+		// each of several directories is relative to dbDir in its own right
+		dirs := migrationDirs(md)
+		for i, dir := range dirs {
+			if !filepath.IsAbs(dir) {
+				dirs[i] = filepath.Join(dbDir, dir)
+			}
 		}
+		migrationsDir = strings.Join(dirs, string(filepath.ListSeparator))
 	}
 
 	drv, err := confGet(f, env, "driver")
@@ -135,17 +257,33 @@ func NewDBConf(dbDir, env string) (*DBConf, error) {
 
 	// allow the configuration to override the Dialect for this driver
 	if dialect, err := confGet(f, env, "dialect"); err == nil && dialect != "" {
-		d.Dialect = dialectByName(dialect)
+		if d.Dialect, err = dialectByName(dialect); err != nil {
+			return nil, err
+		}
 	}
 
 	if !d.IsValid() {
 		return nil, errors.New(fmt.Sprintf("Invalid DBConf: %v", d))
 	}
 
-	return &DBConf{
+	conf := &DBConf{
 		MigrationsDir: migrationsDir,
 		Driver:        d,
-	}, nil
+	}
+
+	if envsubst, err := confGet(f, env, "envsubst"); err == nil && envsubst != "" {
+		if conf.SubstituteVars, err = strconv.ParseBool(envsubst); err != nil {
+			return nil, fmt.Errorf("invalid envsubst %q: %s", envsubst, err)
+		}
+	}
+
+	if strip, err := confGet(f, env, "stripcomments"); err == nil && strip != "" {
+		if conf.StripComments, err = strconv.ParseBool(strip); err != nil {
+			return nil, fmt.Errorf("invalid stripcomments %q: %s", strip, err)
+		}
+	}
+
+	return conf, nil
 }
 
 // Create a new DBDriver and populate driver specific
@@ -176,10 +314,54 @@ func newDBDriver(name, open string) DBDriver {
 		d.Import = "github.com/go-sql-driver/mysql"
 		d.Dialect = &MySqlDialect{}
 
+	case "mariadb":
+		d.Name = "mysql"
+		d.Import = "github.com/go-sql-driver/mysql"
+		d.Dialect = &MariaDBDialect{}
+
 	case "sqlite3":
 		d.Name = "sqlite3"
 		d.Import = "github.com/mattn/go-sqlite3"
 		d.Dialect = &Sqlite3Dialect{}
+
+	case "libsql", "turso":
+		d.Name = "libsql"
+		d.Import = "github.com/tursodatabase/libsql-client-go/libsql"
+		d.Dialect = &Sqlite3Dialect{}
+
+	case "mssql", "sqlserver":
+		d.Import = "github.com/denisenkom/go-mssqldb"
+		d.Dialect = &SqlServerDialect{}
+
+	case "godror":
+		d.Import = "github.com/godror/godror"
+		d.Dialect = &OracleDialect{}
+
+	case "cockroach", "cockroachdb":
+		d.Name = "postgres"
+		d.Import = "github.com/lib/pq"
+		d.Dialect = &CockroachDialect{}
+
+	case "yugabyte", "ysql":
+		d.Name = "postgres"
+		d.Import = "github.com/lib/pq"
+		d.Dialect = &YugabyteDialect{}
+
+	case "clickhouse":
+		d.Import = "github.com/ClickHouse/clickhouse-go"
+		d.Dialect = &ClickHouseDialect{}
+
+	case "spanner":
+		d.Import = "github.com/googleapis/go-sql-spanner"
+		d.Dialect = &SpannerDialect{}
+
+	case "vertica":
+		d.Import = "github.com/vertica/vertica-sql-go"
+		d.Dialect = &VerticaDialect{}
+
+	case "duckdb":
+		d.Import = "github.com/marcboeker/go-duckdb"
+		d.Dialect = &DuckDBDialect{}
 	}
 
 	return d
@@ -190,6 +372,58 @@ func (drv *DBDriver) IsValid() bool {
 	return len(drv.Import) > 0 && drv.Dialect != nil
 }
 
+// SetDialect sets the dialect of drv to the one registered as name,
+// such as "postgres", so that it need not match the driver's name.
+func (drv *DBDriver) SetDialect(name string) error {
+	d, err := dialectByName(name)
+	if err != nil {
+		return err
+	}
+	drv.Dialect = d
+	return nil
+}
+
+// DialectName is the name of drv's dialect, as given by the package level
+// DialectName, or "" if it has none yet.
+func (drv *DBDriver) DialectName() string {
+	return DialectName(drv.Dialect)
+}
+
+// dialects to infer from the package of a database/sql driver, matched
+// as a prefix so that all major versions of a driver are covered
+var driverDialects = []struct {
+	pkg     string
+	dialect string
+}{
+	{"github.com/lib/pq", "postgres"},
+	{"github.com/jackc/pgx", "postgres"},
+	{"github.com/go-sql-driver/mysql", "mysql"},
+	{"github.com/ziutek/mymysql", "mysql"},
+	{"github.com/mattn/go-sqlite3", "sqlite3"},
+	{"modernc.org/sqlite", "sqlite3"},
+}
+
+// InferDialect makes a best guess at the dialect of db from the package of
+// its driver, for callers that already have a *sql.DB: the postgres
+// drivers give "postgres", the mysql drivers "mysql" and the sqlite drivers
+// "sqlite3". Other drivers are an error, as are databases that share a
+// driver with one of these, such as redshift and cockroach, which need
+// their dialect set explicitly with SetDialect.
+func InferDialect(db *sql.DB) (SqlDialect, error) {
+	t := reflect.TypeOf(db.Driver())
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	for _, dd := range driverDialects {
+		if strings.HasPrefix(t.PkgPath(), dd.pkg) {
+			return dialectByName(dd.dialect)
+		}
+	}
+
+	return nil, fmt.Errorf("cannot infer a dialect for driver %s, set one explicitly", t)
+}
+
 // OpenDBFromDBConf wraps database/sql.DB.Open() and configures
 // the newly opened DB based on the given DBConf.
 //
@@ -1,6 +1,9 @@
 package goose
 
 import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -142,6 +145,51 @@ myenv:
 	assert.Equal(t, "foo", dbconf.Driver.OpenStr)
 }
 
+func TestNewDBConf_unknownDialect(t *testing.T) {
+	confPath, _, clean := setupDBConf(t, "dbconf.yaml", "migrations")
+	defer clean()
+
+	err := ioutil.WriteFile(confPath,
+		[]byte(`
+myenv:
+	driver: custom
+	import: github.com/custom/driver
+	dialect: foo
+`),
+		0700)
+	require.NoError(t, err)
+
+	_, err = NewDBConf(filepath.Dir(confPath), "myenv")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown dialect "foo"`)
+	assert.Contains(t, err.Error(), "postgres, redshift, mysql, sqlite3")
+}
+
+type customDialect struct {
+	PostgresDialect
+}
+
+func TestNewDBConf_registeredDialect(t *testing.T) {
+	RegisterDialect("custompg", &customDialect{})
+
+	confPath, _, clean := setupDBConf(t, "dbconf.yaml", "migrations")
+	defer clean()
+
+	err := ioutil.WriteFile(confPath,
+		[]byte(`
+myenv:
+	driver: custom
+	import: github.com/custom/driver
+	dialect: custompg
+`),
+		0700)
+	require.NoError(t, err)
+
+	dbconf, err := NewDBConf(filepath.Dir(confPath), "myenv")
+	require.NoError(t, err)
+	assert.Equal(t, &customDialect{}, dbconf.Driver.Dialect)
+}
+
 func TestNewDBConf_driverDefaults(t *testing.T) {
 	tests := []struct {
 		names  []string
@@ -179,6 +227,14 @@ func TestNewDBConf_driverDefaults(t *testing.T) {
 				Dialect: &MySqlDialect{},
 			},
 		},
+		{
+			[]string{"mariadb"},
+			DBDriver{
+				Name:    "mysql",
+				Import:  "github.com/go-sql-driver/mysql",
+				Dialect: &MariaDBDialect{},
+			},
+		},
 		{
 			[]string{"sqlite3"},
 			DBDriver{
@@ -187,6 +243,86 @@ func TestNewDBConf_driverDefaults(t *testing.T) {
 				Dialect: &Sqlite3Dialect{},
 			},
 		},
+		{
+			[]string{"libsql", "turso"},
+			DBDriver{
+				Name:    "libsql",
+				Import:  "github.com/tursodatabase/libsql-client-go/libsql",
+				Dialect: &Sqlite3Dialect{},
+			},
+		},
+		{
+			[]string{"mssql"},
+			DBDriver{
+				Name:    "mssql",
+				Import:  "github.com/denisenkom/go-mssqldb",
+				Dialect: &SqlServerDialect{},
+			},
+		},
+		{
+			[]string{"sqlserver"},
+			DBDriver{
+				Name:    "sqlserver",
+				Import:  "github.com/denisenkom/go-mssqldb",
+				Dialect: &SqlServerDialect{},
+			},
+		},
+		{
+			[]string{"godror"},
+			DBDriver{
+				Name:    "godror",
+				Import:  "github.com/godror/godror",
+				Dialect: &OracleDialect{},
+			},
+		},
+		{
+			[]string{"cockroach", "cockroachdb"},
+			DBDriver{
+				Name:    "postgres",
+				Import:  "github.com/lib/pq",
+				Dialect: &CockroachDialect{},
+			},
+		},
+		{
+			[]string{"yugabyte", "ysql"},
+			DBDriver{
+				Name:    "postgres",
+				Import:  "github.com/lib/pq",
+				Dialect: &YugabyteDialect{},
+			},
+		},
+		{
+			[]string{"clickhouse"},
+			DBDriver{
+				Name:    "clickhouse",
+				Import:  "github.com/ClickHouse/clickhouse-go",
+				Dialect: &ClickHouseDialect{},
+			},
+		},
+		{
+			[]string{"spanner"},
+			DBDriver{
+				Name:    "spanner",
+				Import:  "github.com/googleapis/go-sql-spanner",
+				Dialect: &SpannerDialect{},
+			},
+		},
+		{
+			[]string{"vertica"},
+			DBDriver{
+				Name:    "vertica",
+				Import:  "github.com/vertica/vertica-sql-go",
+				Dialect: &VerticaDialect{},
+			},
+		},
+		{
+			[]string{"duckdb"},
+			DBDriver{
+				Name:    "duckdb",
+				Import:  "github.com/marcboeker/go-duckdb",
+				Dialect: &DuckDBDialect{},
+			},
+		},
 	}
 	for _, test := range tests {
 		for _, driverName := range test.names {
@@ -253,3 +389,50 @@ func TestDriverSetFromEnvironmentVariable(t *testing.T) {
 			"got %v want %v", gotOpenString, wantOpenString)
 	}
 }
+
+type unknownDriver struct{}
+
+func (unknownDriver) Open(string) (driver.Conn, error) { return nil, errors.New("not implemented") }
+
+func TestInferDialect(t *testing.T) {
+	db, err := OpenDBFromDBConf(&DBConf{Driver: getSqlite3Driver(t)})
+	require.NoError(t, err)
+	defer db.Close()
+
+	d, err := InferDialect(db)
+	require.NoError(t, err)
+	assert.Equal(t, &Sqlite3Dialect{}, d)
+
+	sql.Register("goose-unknown", unknownDriver{})
+	db, err = sql.Open("goose-unknown", "")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = InferDialect(db)
+	assert.EqualError(t, err, "cannot infer a dialect for driver goose.unknownDriver, set one explicitly")
+}
+
+func TestDBDriver_SetDialect(t *testing.T) {
+	drv := newDBDriver("custom", "")
+	require.NoError(t, drv.SetDialect("cockroach"))
+	assert.Equal(t, &CockroachDialect{}, drv.Dialect)
+
+	err := drv.SetDialect("nosuchdialect")
+	assert.Error(t, err)
+	assert.Equal(t, &CockroachDialect{}, drv.Dialect)
+}
+
+func TestDialectName(t *testing.T) {
+	drv := newDBDriver("custom", "")
+	assert.Equal(t, "", drv.DialectName())
+
+	require.NoError(t, drv.SetDialect("cockroachdb"))
+	assert.Equal(t, "cockroach", drv.DialectName())
+
+	// aliases give the name registered first, value or pointer alike
+	assert.Equal(t, "sqlite3", DialectName(Sqlite3Dialect{}))
+	assert.Equal(t, "sqlite3", DialectName(&Sqlite3Dialect{}))
+	assert.Equal(t, "mariadb", DialectName(&MariaDBDialect{}))
+	assert.Equal(t, "", DialectName(noTxDDLSqlite3Dialect{}))
+	assert.Equal(t, "", DialectName(nil))
+}
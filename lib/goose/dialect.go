@@ -1,65 +1,446 @@
 package goose
 
 import (
+	"context"
 	"database/sql"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	mymysql "github.com/ziutek/mymysql/mysql"
 )
 
 // SqlDialect abstracts the details of specific SQL dialects
 // for goose's few SQL specific statements
 type SqlDialect interface {
-	createVersionTableSql() string // sql string to create the goose_db_version table
+	createVersionTableSql() string // sql string to create the version table
 	insertVersionSql() string      // sql string to insert the initial version table row
-	dbVersionQuery(db *sql.DB) (*sql.Rows, error)
+
+	// VersionOrderColumn is the version table column that orders its rows
+	// oldest to newest, which decides the current state of each version.
+	// A dialect embedding one of these can override it, for instance to
+	// order by tstamp where ids are not allocated in order.
+	VersionOrderColumn() string
+
+	// report whether err is from querying a table that does not exist
+	isMissingTableError(err error) bool
+
+	// report whether err is from creating a table that already exists, as
+	// when another migrator creates the version table at the same time
+	isTableAlreadyExistsError(err error) bool
+
+	// TableName quoted for use in SQL, so that reserved words and mixed
+	// case names work
+	quotedTableName() string
+
+	// like insertVersionSql, with a parameter for each of cols after
+	// version_id and is_applied, for DBConf.RecordChecksums and
+	// DBConf.RecordAppliedBy
+	insertVersionColumnsSql(cols []string) string
+
+	// the expression a new version table row's tstamp defaults to,
+	// in UTC where SetUTCTimestamps asks for it
+	timestampDefault() string
+}
+
+// local, or utc if SetUTCTimestamps asks for UTC timestamps
+func utcOr(local, utc string) string {
+	if utcTimestamps {
+		return utc
+	}
+	return local
+}
+
+// query the version_id, is_applied and tstamp of each version table row,
+// newest first.
+func dbVersionQuery(ctx context.Context, d SqlDialect, db queryer) (Rows, error) {
+	return queryVersionTable(ctx, d, db, "tstamp")
+}
+
+// dbVersionQuery with the checksum in place of tstamp, for
+// DBConf.RecordChecksums
+func dbChecksumQuery(ctx context.Context, d SqlDialect, db queryer) (Rows, error) {
+	return queryVersionTable(ctx, d, db, "checksum")
+}
+
+func queryVersionTable(ctx context.Context, d SqlDialect, db queryer, col string) (Rows, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT version_id, is_applied, %s from %s ORDER BY %s DESC",
+		col, d.quotedTableName(), d.VersionOrderColumn()))
+
+	if d.isMissingTableError(err) {
+		err = ErrTableDoesNotExist
+	}
+	return rows, err
+}
+
+// the column and parameter lists of an insert into the version table of
+// version_id, is_applied and cols, where param gives the nth parameter.
+func versionColumns(cols []string, param func(n int) string) (names, params string) {
+	all := append([]string{"version_id", "is_applied"}, cols...)
+	ps := make([]string, len(all))
+	for i := range all {
+		ps[i] = param(i + 1)
+	}
+	return strings.Join(all, ", "), strings.Join(ps, ", ")
+}
+
+// TableName with the schema and table quoted by quote
+func qualifiedName(quote func(string) string, name string) string {
+	if schemaName != "" {
+		return quote(schemaName) + "." + quote(name)
+	}
+	return quote(name)
+}
+
+func doubleQuote(s string) string  { return `"` + strings.ReplaceAll(s, `"`, `""`) + `"` }
+func backQuote(s string) string    { return "`" + strings.ReplaceAll(s, "`", "``") + "`" }
+func bracketQuote(s string) string { return "[" + strings.ReplaceAll(s, "]", "]]") + "]" }
+
+func dollarParam(n int) string { return fmt.Sprintf("$%d", n) }
+func questionParam(int) string { return "?" }
+
+var (
+	dialectsMu sync.RWMutex
+	dialects   = map[string]SqlDialect{}
+	// names in the order they were first registered, for error reporting
+	dialectNames []string
+)
+
+func init() {
+	RegisterDialect("postgres", &PostgresDialect{})
+	RegisterDialect("redshift", &RedshiftDialect{})
+	RegisterDialect("mysql", &MySqlDialect{})
+	RegisterDialect("sqlite3", &Sqlite3Dialect{})
+	RegisterDialect("libsql", &Sqlite3Dialect{})
+	RegisterDialect("turso", &Sqlite3Dialect{})
+	RegisterDialect("mssql", &SqlServerDialect{})
+	RegisterDialect("sqlserver", &SqlServerDialect{})
+	RegisterDialect("oracle", &OracleDialect{})
+	RegisterDialect("godror", &OracleDialect{})
+	RegisterDialect("cockroach", &CockroachDialect{})
+	RegisterDialect("cockroachdb", &CockroachDialect{})
+	RegisterDialect("yugabyte", &YugabyteDialect{})
+	RegisterDialect("ysql", &YugabyteDialect{})
+	RegisterDialect("clickhouse", &ClickHouseDialect{})
+	RegisterDialect("spanner", &SpannerDialect{})
+	RegisterDialect("vertica", &VerticaDialect{})
+	RegisterDialect("duckdb", &DuckDBDialect{})
+	RegisterDialect("mariadb", &MariaDBDialect{})
+}
+
+// RegisterDialect makes a dialect available by name, e.g. for the
+// "dialect" field of dbconf.yml.
+//
+// Registering a name that is already in use replaces the previous dialect,
+// so a custom dialect may shadow a built-in one. The dialect is also
+// registered with encoding/gob so that it survives the trip into Go
+// migrations. Since SqlDialect has unexported methods, implementations
+// outside this package are built by embedding one of the dialects here.
+func RegisterDialect(name string, d SqlDialect) {
+	dialectsMu.Lock()
+	defer dialectsMu.Unlock()
+
+	if d == nil {
+		panic("goose: RegisterDialect dialect is nil")
+	}
+
+	if _, dup := dialects[name]; !dup {
+		dialectNames = append(dialectNames, name)
+	}
+	dialects[name] = d
+	gob.Register(d)
 }
 
 // drivers that we don't know about can ask for a dialect by name
-func dialectByName(d string) SqlDialect {
-	switch d {
-	case "postgres":
-		return &PostgresDialect{}
-	case "redshift":
-		return &RedshiftDialect{}
-	case "mysql":
-		return &MySqlDialect{}
-	case "sqlite3":
-		return &Sqlite3Dialect{}
+func dialectByName(d string) (SqlDialect, error) {
+	dialectsMu.RLock()
+	defer dialectsMu.RUnlock()
+
+	if dialect, ok := dialects[d]; ok {
+		return dialect, nil
 	}
 
-	return nil
+	return nil, fmt.Errorf("unknown dialect %q: supported dialects are %s",
+		d, strings.Join(dialectNames, ", "))
+}
+
+// DialectName returns the name d is registered under, as RegisterDialect
+// registered it first: "sqlite3" for Sqlite3Dialect, say, rather than one
+// of its aliases. It returns "" for a nil d, or one never registered.
+func DialectName(d SqlDialect) string {
+	if d == nil {
+		return ""
+	}
+
+	dialectsMu.RLock()
+	defer dialectsMu.RUnlock()
+
+	for _, name := range dialectNames {
+		if sameDialect(dialects[name], d) {
+			return name
+		}
+	}
+	return ""
 }
 
 ////////////////////////////
 // Postgres
 ////////////////////////////
 
+// postgres SQLSTATE for "relation does not exist"
+const pgUndefinedTable = "42P01"
+
+// isUndefinedTable reports whether err, or an error it wraps, is a
+// postgres undefined_table error.
+// Errors from drivers other than lib/pq are matched by their SQLState(),
+// which pgx provides.
+func isUndefinedTable(err error) bool {
+	var pe *pq.Error
+	if errors.As(err, &pe) {
+		return pe.Code == pgUndefinedTable
+	}
+	var se interface {
+		SQLState() string
+	}
+	if errors.As(err, &se) {
+		return se.SQLState() == pgUndefinedTable
+	}
+	return false
+}
+
+// postgres SQLSTATE for "relation already exists"
+const pgDuplicateTable = "42P07"
+
+// postgres SQLSTATE for "cannot execute ... in a read-only transaction",
+// which a hot standby fails every write with
+const pgReadOnlySQLTransaction = "25006"
+
+// whether writes would fail so, as they do on a hot standby, where every
+// transaction is read-only
+const pgReadOnlySql = "SELECT current_setting('transaction_read_only') = 'on'"
+
+// isReadOnlySQLTransaction reports whether err, or an error it wraps, is
+// a postgres read_only_sql_transaction error.
+func isReadOnlySQLTransaction(err error) bool {
+	var pe *pq.Error
+	if errors.As(err, &pe) {
+		return pe.Code == pgReadOnlySQLTransaction
+	}
+	var se interface {
+		SQLState() string
+	}
+	if errors.As(err, &se) {
+		return se.SQLState() == pgReadOnlySQLTransaction
+	}
+	return false
+}
+
+// isDuplicateTable reports whether err, or an error it wraps, is a
+// postgres duplicate_table error.
+// Two concurrent CREATE TABLEs can instead fail a unique index of the
+// catalog, which only lib/pq's errors name.
+func isDuplicateTable(err error) bool {
+	var pe *pq.Error
+	if errors.As(err, &pe) {
+		return pe.Code == pgDuplicateTable ||
+			pe.Code == "23505" && pe.Constraint == "pg_type_typname_nsp_index"
+	}
+	var se interface {
+		SQLState() string
+	}
+	if errors.As(err, &se) {
+		return se.SQLState() == pgDuplicateTable
+	}
+	return false
+}
+
+// schemaSearcher is implemented by dialects that can make unqualified
+// names in a session or transaction resolve within a given schema.
+type schemaSearcher interface {
+	// statement setting the search path to schema, for the transaction
+	// only if local is set. reset undoes it.
+	searchPathSql(schema string, local bool) (set, reset string)
+}
+
+// search_path is shared by postgres, redshift and cockroach. The schema
+// is quoted, as it is in quotedTableName, so that both name the same one.
+func postgresSearchPathSql(schema string, local bool) (string, string) {
+	if local {
+		return fmt.Sprintf("SET LOCAL search_path TO %s;", doubleQuote(schema)), ""
+	}
+	return fmt.Sprintf("SET search_path TO %s;", doubleQuote(schema)), "RESET search_path;"
+}
+
+// timeouter is implemented by dialects that can bound how long the
+// statements of a session or transaction run, or wait for a lock.
+type timeouter interface {
+	// statements applying whichever of the timeouts are non-zero, for the
+	// transaction only if local is set. reset undoes them.
+	timeoutSql(statement, lockWait time.Duration, local bool) (set, reset []string)
+}
+
+// nonTransactionalDDL is implemented by dialects that cannot run DDL in a
+// transaction, or, like mysql, commit any transaction open around it. The
+// version table is then created outside of one, and every SQL migration
+// runs as if annotated NO TRANSACTION, so that what a failed migration
+// leaves behind is the same whatever it holds.
+type nonTransactionalDDL interface {
+	ddlOutsideTransaction()
+}
+
+// savepointless is implemented by dialects that have no SAVEPOINT, RELEASE
+// SAVEPOINT and ROLLBACK TO SAVEPOINT, which RunMigrationsOnTx reads the
+// version table under: redshift has no savepoints, and T-SQL has SAVE
+// TRANSACTION in their place.
+type savepointless interface {
+	noSavepoints()
+}
+
+// deadlockDetector is implemented by dialects that can tell when a
+// statement failed on a deadlock or a lock wait timeout, which the
+// database rolled back, so that it can be retried as per
+// DBConf.DeadlockRetry.
+type deadlockDetector interface {
+	isDeadlockError(err error) bool
+}
+
+// backslashEscaper is implemented by dialects whose single quoted strings
+// take backslash escapes, as in 'it\'s', so that the quote after a
+// backslash doesn't end the string. Elsewhere it does, as in 'C:\'.
+type backslashEscaper interface {
+	backslashEscapes()
+}
+
+// readOnlyDetector is implemented by dialects that can tell when the
+// database is read-only, as a replica is, so that a run can fail with
+// ErrReadOnlyDatabase before any migration does, and a statement that
+// fails for it regardless can be reported as such.
+type readOnlyDetector interface {
+	isReadOnlyError(err error) bool
+	// query of a single boolean, true if the database refuses writes
+	readOnlySql() string
+}
+
+// fail with ErrReadOnlyDatabase if d can tell the database of q refuses
+// writes. A probe that fails is no error: the run goes on, and fails as
+// readOnlyError says if the database is read-only after all.
+func checkWritable(ctx context.Context, d SqlDialect, q queryer) error {
+	ro, ok := d.(readOnlyDetector)
+	if !ok {
+		return nil
+	}
+	var readOnly bool
+	if err := queryRow(ctx, q, ro.readOnlySql(), nil, &readOnly); err != nil || !readOnly {
+		return nil
+	}
+	return fmt.Errorf("%w; no migration was run", ErrReadOnlyDatabase)
+}
+
+// err marked as ErrReadOnlyDatabase, if d tells it is down to the
+// database being read-only, or else err as is
+func readOnlyError(d SqlDialect, err error) error {
+	ro, ok := d.(readOnlyDetector)
+	if !ok || err == nil || errors.Is(err, ErrReadOnlyDatabase) || !ro.isReadOnlyError(err) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrReadOnlyDatabase, err)
+}
+
+// reports whether d can run DDL in a transaction
+func ddlInTransaction(d SqlDialect) bool {
+	_, ok := d.(nonTransactionalDDL)
+	return !ok
+}
+
+// statement_timeout and lock_timeout are shared by postgres and cockroach
+func postgresTimeoutSql(statement, lockWait time.Duration, local bool) (set, reset []string) {
+	scope := ""
+	if local {
+		scope = "LOCAL "
+	}
+	for _, t := range []struct {
+		name string
+		d    time.Duration
+	}{{"statement_timeout", statement}, {"lock_timeout", lockWait}} {
+		if t.d <= 0 {
+			continue
+		}
+		set = append(set, fmt.Sprintf("SET %s%s = %d;", scope, t.name, t.d.Milliseconds()))
+		if !local {
+			reset = append(reset, fmt.Sprintf("RESET %s;", t.name))
+		}
+	}
+	return set, reset
+}
+
 type PostgresDialect struct{}
 
+func (pg PostgresDialect) searchPathSql(schema string, local bool) (string, string) {
+	return postgresSearchPathSql(schema, local)
+}
+
+func (pg PostgresDialect) timeoutSql(statement, lockWait time.Duration, local bool) ([]string, []string) {
+	return postgresTimeoutSql(statement, lockWait, local)
+}
+
+func (pg PostgresDialect) quotedTableName() string { return qualifiedName(doubleQuote, tableName) }
+
+func (pg PostgresDialect) timestampDefault() string {
+	return utcOr("now()", "timezone('utc', now())")
+}
+
 func (pg PostgresDialect) createVersionTableSql() string {
-	return `CREATE TABLE goose_db_version (
+	return fmt.Sprintf(`CREATE TABLE %s (
             	id serial NOT NULL,
                 version_id bigint NOT NULL,
                 is_applied boolean NOT NULL,
-                tstamp timestamp NULL default now(),
+                tstamp timestamp NULL default %s,
+                checksum varchar(64) NULL,
+                applied_by varchar(255) NULL,
+                source_file varchar(255) NULL,
                 PRIMARY KEY(id)
-            );`
+            );`, pg.quotedTableName(), pg.timestampDefault())
 }
 
 func (pg PostgresDialect) insertVersionSql() string {
-	return "INSERT INTO goose_db_version (version_id, is_applied) VALUES ($1, $2);"
+	return fmt.Sprintf("INSERT INTO %s (version_id, is_applied) VALUES ($1, $2);", pg.quotedTableName())
 }
 
-func (pg PostgresDialect) dbVersionQuery(db *sql.DB) (*sql.Rows, error) {
-	rows, err := db.Query("SELECT version_id, is_applied, tstamp from goose_db_version ORDER BY id DESC")
+func (pg PostgresDialect) VersionOrderColumn() string { return "id" }
 
-	// XXX: check for postgres specific error indicating the table doesn't exist.
-	// for now, assume any error is because the table doesn't exist,
-	// in which case we'll try to create it.
-	if err != nil {
-		return nil, ErrTableDoesNotExist
-	}
+func (pg PostgresDialect) isMissingTableError(err error) bool {
+	return isUndefinedTable(err)
+}
 
-	return rows, err
+func (pg PostgresDialect) isTableAlreadyExistsError(err error) bool {
+	return isDuplicateTable(err)
+}
+
+func (pg PostgresDialect) isReadOnlyError(err error) bool {
+	return isReadOnlySQLTransaction(err)
+}
+
+func (pg PostgresDialect) readOnlySql() string { return pgReadOnlySql }
+
+func (pg PostgresDialect) insertVersionColumnsSql(cols []string) string {
+	names, params := versionColumns(cols, dollarParam)
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);", pg.quotedTableName(), names, params)
+}
+
+func (pg PostgresDialect) lock(ctx context.Context, conn Querier, timeout time.Duration) error {
+	_, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockKey())
+	return err
+}
+
+func (pg PostgresDialect) unlock(ctx context.Context, conn Querier) error {
+	_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", lockKey())
+	return err
 }
 
 ////////////////////////////
@@ -68,88 +449,735 @@ func (pg PostgresDialect) dbVersionQuery(db *sql.DB) (*sql.Rows, error) {
 
 type RedshiftDialect struct{}
 
+func (pg RedshiftDialect) noSavepoints() {}
+
+func (pg RedshiftDialect) searchPathSql(schema string, local bool) (string, string) {
+	return postgresSearchPathSql(schema, local)
+}
+
+func (pg RedshiftDialect) quotedTableName() string { return qualifiedName(doubleQuote, tableName) }
+
+// Redshift rows are inserted with tstamp set to SYSDATE, which is UTC
+func (pg RedshiftDialect) timestampDefault() string { return "SYSDATE" }
+
 func (pg RedshiftDialect) createVersionTableSql() string {
-	return `CREATE TABLE goose_db_version (
+	return fmt.Sprintf(`CREATE TABLE %s (
                 version_id       BIGINT    NOT NULL,
                 is_applied       BOOLEAN   NOT NULL,
-                tstamp           timestamp NOT NULL
-            ) SORTKEY(tstamp);`
+                tstamp           timestamp NOT NULL,
+                checksum         VARCHAR(64) NULL,
+                applied_by       VARCHAR(255) NULL,
+                source_file      VARCHAR(255) NULL
+            ) SORTKEY(tstamp);`, pg.quotedTableName())
 }
 
 func (pg RedshiftDialect) insertVersionSql() string {
-	return "INSERT INTO goose_db_version (version_id, is_applied, tstamp) VALUES ($1, $2, SYSDATE);"
+	return fmt.Sprintf("INSERT INTO %s (version_id, is_applied, tstamp) VALUES ($1, $2, %s);", pg.quotedTableName(), pg.timestampDefault())
 }
 
-func (pg RedshiftDialect) dbVersionQuery(db *sql.DB) (*sql.Rows, error) {
-	rows, err := db.Query("SELECT version_id, is_applied, tstamp from goose_db_version ORDER BY tstamp DESC")
+func (pg RedshiftDialect) VersionOrderColumn() string { return "tstamp" }
 
-	// XXX: check for postgres specific error indicating the table doesn't exist.
-	// for now, assume any error is because the table doesn't exist,
-	// in which case we'll try to create it.
-	if err != nil {
-		return nil, ErrTableDoesNotExist
-	}
+func (pg RedshiftDialect) isMissingTableError(err error) bool {
+	return isUndefinedTable(err)
+}
 
-	return rows, err
+func (pg RedshiftDialect) isTableAlreadyExistsError(err error) bool {
+	return isDuplicateTable(err)
+}
+
+func (pg RedshiftDialect) isReadOnlyError(err error) bool {
+	return isReadOnlySQLTransaction(err)
+}
+
+func (pg RedshiftDialect) readOnlySql() string { return pgReadOnlySql }
+
+func (pg RedshiftDialect) insertVersionColumnsSql(cols []string) string {
+	names, params := versionColumns(cols, dollarParam)
+	for _, c := range cols {
+		if c == "tstamp" {
+			return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);", pg.quotedTableName(), names, params)
+		}
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s, tstamp) VALUES (%s, %s);", pg.quotedTableName(), names, params, pg.timestampDefault())
 }
 
 ////////////////////////////
 // MySQL
 ////////////////////////////
 
+// mysql error number ER_NO_SUCH_TABLE
+const mysqlNoSuchTable = 1146
+
+// mysql error number ER_TABLE_EXISTS_ERROR
+const mysqlTableExists = 1050
+
+// isTableExists reports whether err, or an error it wraps, is a mysql
+// ER_TABLE_EXISTS_ERROR error, from either driver.
+func isTableExists(err error) bool {
+	return isMySQLError(err, mysqlTableExists)
+}
+
+// mysql error numbers ER_LOCK_DEADLOCK and ER_LOCK_WAIT_TIMEOUT
+const (
+	mysqlDeadlock        = 1213
+	mysqlLockWaitTimeout = 1205
+)
+
+// mysql error numbers ER_OPTION_PREVENTS_STATEMENT, which a server
+// running with --read-only or --super-read-only, as replicas do, fails
+// writes with, and ER_CANT_EXECUTE_IN_READ_ONLY_TRANSACTION
+const (
+	mysqlOptionPreventsStatement = 1290
+	mysqlReadOnlyTransaction     = 1792
+)
+
+// isNoSuchTable reports whether err, or an error it wraps, is a mysql
+// ER_NO_SUCH_TABLE error, from either the go-sql-driver or the mymysql
+// driver.
+func isNoSuchTable(err error) bool {
+	return isMySQLError(err, mysqlNoSuchTable)
+}
+
+// isMySQLError reports whether err, or an error it wraps, is a mysql
+// error with the given number, from either driver.
+func isMySQLError(err error, number uint16) bool {
+	var me *mysql.MySQLError
+	if errors.As(err, &me) {
+		return me.Number == number
+	}
+	var mme *mymysql.Error
+	if errors.As(err, &mme) {
+		return mme.Code == number
+	}
+	return false
+}
+
 type MySqlDialect struct{}
 
+func (m MySqlDialect) backslashEscapes() {}
+
+// Only the wait for row locks can be bounded: max_execution_time applies
+// to SELECTs alone, and innodb_lock_wait_timeout has no transaction scope
+// and counts whole seconds, so it is rounded up and always set on the
+// session.
+func (m MySqlDialect) timeoutSql(statement, lockWait time.Duration, local bool) (set, reset []string) {
+	if lockWait <= 0 {
+		return nil, nil
+	}
+	secs := int64((lockWait + time.Second - 1) / time.Second)
+	set = []string{fmt.Sprintf("SET SESSION innodb_lock_wait_timeout = %d;", secs)}
+	reset = []string{"SET SESSION innodb_lock_wait_timeout = DEFAULT;"}
+	return set, reset
+}
+
+func (m MySqlDialect) ddlOutsideTransaction() {}
+
+func (m MySqlDialect) quotedTableName() string { return qualifiedName(backQuote, tableName) }
+
+func (m MySqlDialect) timestampDefault() string {
+	return utcOr("now()", "(UTC_TIMESTAMP())")
+}
+
 func (m MySqlDialect) createVersionTableSql() string {
-	return `CREATE TABLE goose_db_version (
+	return fmt.Sprintf(`CREATE TABLE %s (
                 id serial NOT NULL,
                 version_id bigint NOT NULL,
                 is_applied boolean NOT NULL,
-                tstamp timestamp NULL default now(),
+                tstamp timestamp NULL default %s,
+                checksum varchar(64) NULL,
+                applied_by varchar(255) NULL,
+                source_file varchar(255) NULL,
                 PRIMARY KEY(id)
-            );`
+            );`, m.quotedTableName(), m.timestampDefault())
 }
 
 func (m MySqlDialect) insertVersionSql() string {
-	return "INSERT INTO goose_db_version (version_id, is_applied) VALUES (?, ?);"
+	return fmt.Sprintf("INSERT INTO %s (version_id, is_applied) VALUES (?, ?);", m.quotedTableName())
+}
+
+func (m MySqlDialect) VersionOrderColumn() string { return "id" }
+
+func (m MySqlDialect) isMissingTableError(err error) bool {
+	return isNoSuchTable(err)
 }
 
-func (m MySqlDialect) dbVersionQuery(db *sql.DB) (*sql.Rows, error) {
-	rows, err := db.Query("SELECT version_id, is_applied, tstamp from goose_db_version ORDER BY id DESC")
+func (m MySqlDialect) isTableAlreadyExistsError(err error) bool {
+	return isTableExists(err)
+}
 
-	// XXX: check for mysql specific error indicating the table doesn't exist.
-	// for now, assume any error is because the table doesn't exist,
-	// in which case we'll try to create it.
-	if err != nil {
-		return nil, ErrTableDoesNotExist
+// A deadlock rolls back the whole transaction, and a lock wait timeout the
+// statement, but either way goose rolls back the rest.
+func (m MySqlDialect) isDeadlockError(err error) bool {
+	var me *mysql.MySQLError
+	if errors.As(err, &me) {
+		return me.Number == mysqlDeadlock || me.Number == mysqlLockWaitTimeout
+	}
+	var mme *mymysql.Error
+	if errors.As(err, &mme) {
+		return mme.Code == mysqlDeadlock || mme.Code == mysqlLockWaitTimeout
 	}
+	return false
+}
 
-	return rows, err
+func (m MySqlDialect) isReadOnlyError(err error) bool {
+	var me *mysql.MySQLError
+	if errors.As(err, &me) {
+		return me.Number == mysqlOptionPreventsStatement || me.Number == mysqlReadOnlyTransaction
+	}
+	var mme *mymysql.Error
+	if errors.As(err, &mme) {
+		return mme.Code == mysqlOptionPreventsStatement || mme.Code == mysqlReadOnlyTransaction
+	}
+	return false
+}
+
+// super_read_only, where there is one, sets read_only along with it
+func (m MySqlDialect) readOnlySql() string { return "SELECT @@global.read_only" }
+
+func (m MySqlDialect) insertVersionColumnsSql(cols []string) string {
+	names, params := versionColumns(cols, questionParam)
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);", m.quotedTableName(), names, params)
+}
+
+func (m MySqlDialect) lock(ctx context.Context, conn Querier, timeout time.Duration) error {
+	// a negative timeout makes GET_LOCK wait forever
+	seconds := -1
+	if timeout > 0 {
+		seconds = int((timeout + time.Second - 1) / time.Second)
+	}
+
+	var ok sql.NullInt64
+	if err := queryRow(ctx, conn, "SELECT GET_LOCK(?, ?)", []interface{}{TableName(), seconds}, &ok); err != nil {
+		return err
+	}
+	if !ok.Valid || ok.Int64 != 1 {
+		return fmt.Errorf("timed out waiting for lock %q", TableName())
+	}
+	return nil
+}
+
+func (m MySqlDialect) unlock(ctx context.Context, conn Querier) error {
+	_, err := conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", TableName())
+	return err
+}
+
+////////////////////////////
+// MariaDB
+////////////////////////////
+
+// mariadb error number ER_NO_SUCH_TABLE_IN_ENGINE, for a table the server
+// knows of but its storage engine does not
+const mariadbNoSuchTableInEngine = 1932
+
+// MariaDB is mostly wire and SQL compatible with MySQL, so it shares the
+// MySQL dialect but for the version table's DDL, which spells out the
+// column types rather than relying on serial and boolean aliases, and
+// the errors taken to mean the table is missing.
+type MariaDBDialect struct {
+	MySqlDialect
+}
+
+func (m MariaDBDialect) createVersionTableSql() string {
+	return fmt.Sprintf(`CREATE TABLE %s (
+                id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+                version_id BIGINT NOT NULL,
+                is_applied TINYINT(1) NOT NULL,
+                tstamp TIMESTAMP NULL DEFAULT %s,
+                checksum VARCHAR(64) NULL,
+                applied_by VARCHAR(255) NULL,
+                source_file VARCHAR(255) NULL,
+                PRIMARY KEY(id)
+            );`, m.quotedTableName(), m.timestampDefault())
+}
+
+func (m MariaDBDialect) isMissingTableError(err error) bool {
+	return isNoSuchTable(err) || isMySQLError(err, mariadbNoSuchTableInEngine)
 }
 
 ////////////////////////////
 // sqlite3
 ////////////////////////////
 
+// Sqlite3Dialect also serves libSQL and Turso, which speak the same SQL.
 type Sqlite3Dialect struct{}
 
+func (m Sqlite3Dialect) quotedTableName() string { return qualifiedName(doubleQuote, tableName) }
+
+func (m Sqlite3Dialect) timestampDefault() string { return "(datetime('now'))" }
+
 func (m Sqlite3Dialect) createVersionTableSql() string {
-	return `CREATE TABLE goose_db_version (
+	return fmt.Sprintf(`CREATE TABLE %s (
                 id INTEGER PRIMARY KEY AUTOINCREMENT,
                 version_id INTEGER NOT NULL,
                 is_applied INTEGER NOT NULL,
-                tstamp TIMESTAMP DEFAULT (datetime('now'))
-            );`
+                tstamp TIMESTAMP DEFAULT %s,
+                checksum TEXT NULL,
+                applied_by TEXT NULL,
+                source_file TEXT NULL
+            );`, m.quotedTableName(), m.timestampDefault())
 }
 
 func (m Sqlite3Dialect) insertVersionSql() string {
-	return "INSERT INTO goose_db_version (version_id, is_applied) VALUES (?, ?);"
+	return fmt.Sprintf("INSERT INTO %s (version_id, is_applied) VALUES (?, ?);", m.quotedTableName())
 }
 
-func (m Sqlite3Dialect) dbVersionQuery(db *sql.DB) (*sql.Rows, error) {
-	rows, err := db.Query("SELECT version_id, is_applied, tstamp from goose_db_version ORDER BY id DESC")
+func (m Sqlite3Dialect) VersionOrderColumn() string { return "id" }
 
-	if err != nil && strings.Contains(err.Error(), "no such table") {
-		err = ErrTableDoesNotExist
+func (m Sqlite3Dialect) isMissingTableError(err error) bool {
+	// "no such table: goose_db_version", which libSQL wraps as e.g.
+	// "SQLITE_UNKNOWN: SQLite error: no such table: goose_db_version"
+	return err != nil && strings.Contains(strings.ToLower(err.Error()), "no such table")
+}
+
+func (m Sqlite3Dialect) isTableAlreadyExistsError(err error) bool {
+	// "table goose_db_version already exists"
+	return err != nil && strings.Contains(strings.ToLower(err.Error()), "already exists")
+}
+
+func (m Sqlite3Dialect) insertVersionColumnsSql(cols []string) string {
+	names, params := versionColumns(cols, questionParam)
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);", m.quotedTableName(), names, params)
+}
+
+////////////////////////////
+// SQL Server
+////////////////////////////
+
+type SqlServerDialect struct{}
+
+func (m SqlServerDialect) noSavepoints() {}
+
+func (m SqlServerDialect) quotedTableName() string { return qualifiedName(bracketQuote, tableName) }
+
+func (m SqlServerDialect) timestampDefault() string {
+	return utcOr("GETDATE()", "GETUTCDATE()")
+}
+
+func (m SqlServerDialect) createVersionTableSql() string {
+	return fmt.Sprintf(`CREATE TABLE %s (
+                id BIGINT IDENTITY(1,1) NOT NULL,
+                version_id BIGINT NOT NULL,
+                is_applied BIT NOT NULL,
+                tstamp DATETIME2 NULL DEFAULT %s,
+                checksum VARCHAR(64) NULL,
+                applied_by VARCHAR(255) NULL,
+                source_file VARCHAR(255) NULL,
+                PRIMARY KEY(id)
+            );`, m.quotedTableName(), m.timestampDefault())
+}
+
+func (m SqlServerDialect) insertVersionSql() string {
+	return fmt.Sprintf("INSERT INTO %s (version_id, is_applied) VALUES (@p1, @p2);", m.quotedTableName())
+}
+
+func (m SqlServerDialect) VersionOrderColumn() string { return "id" }
+
+func (m SqlServerDialect) isMissingTableError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "Invalid object name")
+}
+
+func (m SqlServerDialect) isTableAlreadyExistsError(err error) bool {
+	// There is already an object named 'goose_db_version' in the database.
+	return err != nil && strings.Contains(err.Error(), "There is already an object named")
+}
+
+func (m SqlServerDialect) insertVersionColumnsSql(cols []string) string {
+	names, params := versionColumns(cols, func(n int) string { return fmt.Sprintf("@p%d", n) })
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);", m.quotedTableName(), names, params)
+}
+
+////////////////////////////
+// Oracle
+////////////////////////////
+
+// Oracle rejects a trailing semicolon on statements sent through the driver,
+// so unlike the other dialects these statements are left unterminated.
+type OracleDialect struct{}
+
+func (m OracleDialect) ddlOutsideTransaction() {}
+
+// quoted names are case sensitive in Oracle, and unquoted ones stored in
+// upper case, so the names are upper cased to find the same table
+func (m OracleDialect) quotedTableName() string {
+	return qualifiedName(func(s string) string { return doubleQuote(strings.ToUpper(s)) }, tableName)
+}
+
+func (m OracleDialect) timestampDefault() string {
+	return utcOr("SYSTIMESTAMP", "SYS_EXTRACT_UTC(SYSTIMESTAMP)")
+}
+
+func (m OracleDialect) createVersionTableSql() string {
+	return fmt.Sprintf(`CREATE TABLE %s (
+                id NUMBER(19) GENERATED BY DEFAULT AS IDENTITY,
+                version_id NUMBER(19) NOT NULL,
+                is_applied NUMBER(1) NOT NULL,
+                tstamp TIMESTAMP DEFAULT %s,
+                checksum VARCHAR2(64) NULL,
+                applied_by VARCHAR2(255) NULL,
+                source_file VARCHAR2(255) NULL,
+                PRIMARY KEY(id)
+            )`, m.quotedTableName(), m.timestampDefault())
+}
+
+func (m OracleDialect) insertVersionSql() string {
+	return fmt.Sprintf("INSERT INTO %s (version_id, is_applied) VALUES (:1, :2)", m.quotedTableName())
+}
+
+func (m OracleDialect) VersionOrderColumn() string { return "id" }
+
+func (m OracleDialect) isMissingTableError(err error) bool {
+	// ORA-00942: table or view does not exist
+	return err != nil && strings.Contains(err.Error(), "ORA-00942")
+}
+
+func (m OracleDialect) isTableAlreadyExistsError(err error) bool {
+	// ORA-00955: name is already used by an existing object
+	return err != nil && strings.Contains(err.Error(), "ORA-00955")
+}
+
+func (m OracleDialect) insertVersionColumnsSql(cols []string) string {
+	names, params := versionColumns(cols, func(n int) string { return fmt.Sprintf(":%d", n) })
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", m.quotedTableName(), names, params)
+}
+
+////////////////////////////
+// CockroachDB
+////////////////////////////
+
+// CockroachDB speaks the postgres wire protocol, but its SERIAL values
+// come from unique_rowid() and are not monotonic, so the version
+// history is ordered by tstamp instead of id.
+type CockroachDialect struct{}
+
+func (m CockroachDialect) searchPathSql(schema string, local bool) (string, string) {
+	return postgresSearchPathSql(schema, local)
+}
+
+func (m CockroachDialect) timeoutSql(statement, lockWait time.Duration, local bool) ([]string, []string) {
+	return postgresTimeoutSql(statement, lockWait, local)
+}
+
+func (m CockroachDialect) quotedTableName() string { return qualifiedName(doubleQuote, tableName) }
+
+func (m CockroachDialect) timestampDefault() string {
+	return utcOr("now()", "timezone('utc', now())")
+}
+
+func (m CockroachDialect) createVersionTableSql() string {
+	return fmt.Sprintf(`CREATE TABLE %s (
+                id SERIAL NOT NULL,
+                version_id INT8 NOT NULL,
+                is_applied BOOL NOT NULL,
+                tstamp TIMESTAMP NULL DEFAULT %s,
+                checksum STRING NULL,
+                applied_by STRING NULL,
+                source_file STRING NULL,
+                PRIMARY KEY(id)
+            );`, m.quotedTableName(), m.timestampDefault())
+}
+
+func (m CockroachDialect) insertVersionSql() string {
+	return fmt.Sprintf("INSERT INTO %s (version_id, is_applied) VALUES ($1, $2);", m.quotedTableName())
+}
+
+func (m CockroachDialect) VersionOrderColumn() string { return "tstamp" }
+
+func (m CockroachDialect) isMissingTableError(err error) bool {
+	return isUndefinedTable(err)
+}
+
+func (m CockroachDialect) isTableAlreadyExistsError(err error) bool {
+	return isDuplicateTable(err)
+}
+
+func (m CockroachDialect) isReadOnlyError(err error) bool {
+	return isReadOnlySQLTransaction(err)
+}
+
+func (m CockroachDialect) readOnlySql() string { return pgReadOnlySql }
+
+func (m CockroachDialect) insertVersionColumnsSql(cols []string) string {
+	names, params := versionColumns(cols, dollarParam)
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);", m.quotedTableName(), names, params)
+}
+
+////////////////////////////
+// YugabyteDB
+////////////////////////////
+
+// YugabyteDB's YSQL speaks the postgres wire protocol, but like
+// CockroachDB it hands out SERIAL values from per-node caches, which
+// are not monotonic, so the version history is ordered by tstamp.
+type YugabyteDialect struct{}
+
+func (m YugabyteDialect) searchPathSql(schema string, local bool) (string, string) {
+	return postgresSearchPathSql(schema, local)
+}
+
+func (m YugabyteDialect) timeoutSql(statement, lockWait time.Duration, local bool) ([]string, []string) {
+	return postgresTimeoutSql(statement, lockWait, local)
+}
+
+func (m YugabyteDialect) quotedTableName() string { return qualifiedName(doubleQuote, tableName) }
+
+func (m YugabyteDialect) timestampDefault() string {
+	return utcOr("now()", "timezone('utc', now())")
+}
+
+func (m YugabyteDialect) createVersionTableSql() string {
+	return fmt.Sprintf(`CREATE TABLE %s (
+                id BIGSERIAL NOT NULL,
+                version_id BIGINT NOT NULL,
+                is_applied BOOLEAN NOT NULL,
+                tstamp TIMESTAMP NULL DEFAULT %s,
+                checksum VARCHAR(64) NULL,
+                applied_by VARCHAR(255) NULL,
+                source_file VARCHAR(255) NULL,
+                PRIMARY KEY(id)
+            );`, m.quotedTableName(), m.timestampDefault())
+}
+
+func (m YugabyteDialect) insertVersionSql() string {
+	return fmt.Sprintf("INSERT INTO %s (version_id, is_applied) VALUES ($1, $2);", m.quotedTableName())
+}
+
+func (m YugabyteDialect) VersionOrderColumn() string { return "tstamp" }
+
+func (m YugabyteDialect) isMissingTableError(err error) bool {
+	return isUndefinedTable(err)
+}
+
+func (m YugabyteDialect) isTableAlreadyExistsError(err error) bool {
+	return isDuplicateTable(err)
+}
+
+func (m YugabyteDialect) isReadOnlyError(err error) bool {
+	return isReadOnlySQLTransaction(err)
+}
+
+func (m YugabyteDialect) readOnlySql() string { return pgReadOnlySql }
+
+func (m YugabyteDialect) insertVersionColumnsSql(cols []string) string {
+	names, params := versionColumns(cols, dollarParam)
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);", m.quotedTableName(), names, params)
+}
+
+////////////////////////////
+// ClickHouse
+////////////////////////////
+
+// ClickHouse has no autoincrement columns, so the version history
+// is kept in a MergeTree ordered by tstamp.
+type ClickHouseDialect struct{}
+
+func (m ClickHouseDialect) ddlOutsideTransaction() {}
+
+func (m ClickHouseDialect) backslashEscapes() {}
+
+func (m ClickHouseDialect) quotedTableName() string { return qualifiedName(backQuote, tableName) }
+
+func (m ClickHouseDialect) timestampDefault() string { return "now()" }
+
+func (m ClickHouseDialect) createVersionTableSql() string {
+	return fmt.Sprintf(`CREATE TABLE %s (
+                version_id Int64,
+                is_applied UInt8,
+                tstamp DateTime DEFAULT %s,
+                checksum Nullable(String),
+                applied_by Nullable(String),
+                source_file Nullable(String)
+            ) ENGINE = MergeTree() ORDER BY tstamp`, m.quotedTableName(), m.timestampDefault())
+}
+
+func (m ClickHouseDialect) insertVersionSql() string {
+	return fmt.Sprintf("INSERT INTO %s (version_id, is_applied) VALUES (?, ?)", m.quotedTableName())
+}
+
+func (m ClickHouseDialect) VersionOrderColumn() string { return "tstamp" }
+
+// the error code of a clickhouse exception, as the drivers spell it
+var clickhouseCode = regexp.MustCompile(`\bcode: (\d+)\b`)
+
+// reports whether err is a clickhouse exception with the given code,
+// and not merely one whose code begins with the same digits
+func isClickHouseCode(err error, code string) bool {
+	if err == nil {
+		return false
 	}
-	return rows, err
+	m := clickhouseCode.FindStringSubmatch(err.Error())
+	return m != nil && m[1] == code
+}
+
+func (m ClickHouseDialect) isMissingTableError(err error) bool {
+	// code: 60, message: Table default.goose_db_version doesn't exist
+	return isClickHouseCode(err, "60")
+}
+
+func (m ClickHouseDialect) isTableAlreadyExistsError(err error) bool {
+	// code: 57, message: Table default.goose_db_version already exists
+	return isClickHouseCode(err, "57")
+}
+
+func (m ClickHouseDialect) insertVersionColumnsSql(cols []string) string {
+	names, params := versionColumns(cols, questionParam)
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", m.quotedTableName(), names, params)
+}
+
+////////////////////////////
+// Spanner
+////////////////////////////
+
+// Spanner has no autoincrement columns, so the version history is keyed,
+// and ordered, by version and tstamp. Its DDL cannot run in a read-write
+// transaction, and like Oracle it rejects a trailing semicolon.
+type SpannerDialect struct{}
+
+func (m SpannerDialect) ddlOutsideTransaction() {}
+
+// GoogleSQL strings take backslash escapes, as in 'it\'s'.
+func (m SpannerDialect) backslashEscapes() {}
+
+func (m SpannerDialect) quotedTableName() string { return qualifiedName(backQuote, tableName) }
+
+func (m SpannerDialect) timestampDefault() string { return "(CURRENT_TIMESTAMP())" }
+
+func (m SpannerDialect) createVersionTableSql() string {
+	return fmt.Sprintf(`CREATE TABLE %s (
+                version_id INT64 NOT NULL,
+                is_applied BOOL NOT NULL,
+                tstamp TIMESTAMP NOT NULL DEFAULT %s,
+                checksum STRING(64),
+                applied_by STRING(255),
+                source_file STRING(255)
+            ) PRIMARY KEY (version_id, tstamp)`, m.quotedTableName(), m.timestampDefault())
+}
+
+func (m SpannerDialect) insertVersionSql() string {
+	return fmt.Sprintf("INSERT INTO %s (version_id, is_applied) VALUES (@p1, @p2)", m.quotedTableName())
+}
+
+func (m SpannerDialect) VersionOrderColumn() string { return "tstamp" }
+
+func (m SpannerDialect) isMissingTableError(err error) bool {
+	// spanner: code = "InvalidArgument", desc = "Table not found: goose_db_version"
+	return err != nil && strings.Contains(err.Error(), "Table not found")
+}
+
+func (m SpannerDialect) isTableAlreadyExistsError(err error) bool {
+	// spanner: code = "FailedPrecondition", desc = "Duplicate name in schema: goose_db_version."
+	return err != nil && strings.Contains(err.Error(), "Duplicate name in schema")
+}
+
+func (m SpannerDialect) insertVersionColumnsSql(cols []string) string {
+	names, params := versionColumns(cols, func(n int) string { return fmt.Sprintf("@p%d", n) })
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", m.quotedTableName(), names, params)
+}
+
+////////////////////////////
+// Vertica
+////////////////////////////
+
+// Vertica allocates IDENTITY values in per-node caches, so they are not
+// in insertion order and the version history is ordered by tstamp.
+type VerticaDialect struct{}
+
+func (m VerticaDialect) ddlOutsideTransaction() {}
+
+func (m VerticaDialect) quotedTableName() string { return qualifiedName(doubleQuote, tableName) }
+
+func (m VerticaDialect) timestampDefault() string {
+	return utcOr("NOW()", "(NOW() AT TIME ZONE 'UTC')")
+}
+
+func (m VerticaDialect) createVersionTableSql() string {
+	return fmt.Sprintf(`CREATE TABLE %s (
+                id IDENTITY NOT NULL,
+                version_id INT NOT NULL,
+                is_applied BOOLEAN NOT NULL,
+                tstamp TIMESTAMP NULL DEFAULT %s,
+                checksum VARCHAR(64) NULL,
+                applied_by VARCHAR(255) NULL,
+                source_file VARCHAR(255) NULL,
+                PRIMARY KEY(id)
+            );`, m.quotedTableName(), m.timestampDefault())
+}
+
+func (m VerticaDialect) insertVersionSql() string {
+	return fmt.Sprintf("INSERT INTO %s (version_id, is_applied) VALUES (?, ?);", m.quotedTableName())
+}
+
+func (m VerticaDialect) VersionOrderColumn() string { return "tstamp" }
+
+func (m VerticaDialect) isMissingTableError(err error) bool {
+	// Error: [42V01] Relation "goose_db_version" does not exist
+	return err != nil && strings.Contains(err.Error(), "42V01")
+}
+
+func (m VerticaDialect) isTableAlreadyExistsError(err error) bool {
+	// Error: [42P07] Object "goose_db_version" already exists
+	return err != nil && strings.Contains(err.Error(), "42P07")
+}
+
+func (m VerticaDialect) insertVersionColumnsSql(cols []string) string {
+	names, params := versionColumns(cols, questionParam)
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);", m.quotedTableName(), names, params)
+}
+
+////////////////////////////
+// DuckDB
+////////////////////////////
+
+// DuckDB has no autoincrement columns, so ids are drawn from a sequence
+// created along with the version table.
+type DuckDBDialect struct{}
+
+func (m DuckDBDialect) quotedTableName() string { return qualifiedName(doubleQuote, tableName) }
+
+func (m DuckDBDialect) timestampDefault() string {
+	return utcOr("now()", "timezone('UTC', now())")
+}
+
+func (m DuckDBDialect) createVersionTableSql() string {
+	seq := qualifiedName(doubleQuote, tableName+"_id_seq")
+	return fmt.Sprintf(`CREATE SEQUENCE %s;
+            CREATE TABLE %s (
+                id INTEGER PRIMARY KEY DEFAULT nextval('%s'),
+                version_id BIGINT NOT NULL,
+                is_applied BOOLEAN NOT NULL,
+                tstamp TIMESTAMP DEFAULT %s,
+                checksum VARCHAR NULL,
+                applied_by VARCHAR NULL,
+                source_file VARCHAR NULL
+            );`, seq, m.quotedTableName(), seq, m.timestampDefault())
+}
+
+func (m DuckDBDialect) insertVersionSql() string {
+	return fmt.Sprintf("INSERT INTO %s (version_id, is_applied) VALUES (?, ?);", m.quotedTableName())
+}
+
+func (m DuckDBDialect) VersionOrderColumn() string { return "id" }
+
+func (m DuckDBDialect) isMissingTableError(err error) bool {
+	// Catalog Error: Table with name goose_db_version does not exist!
+	// which begins as the error for a table that already exists does
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "Catalog Error: Table with name") && strings.Contains(msg, "does not exist")
+}
+
+func (m DuckDBDialect) isTableAlreadyExistsError(err error) bool {
+	// Catalog Error: Table with name "goose_db_version" already exists!
+	// and not the same for a sequence, index or other object
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "Table with name") && strings.Contains(msg, "already exists")
+}
+
+func (m DuckDBDialect) insertVersionColumnsSql(cols []string) string {
+	names, params := versionColumns(cols, questionParam)
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);", m.quotedTableName(), names, params)
 }
@@ -0,0 +1,218 @@
+package goose
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	mymysql "github.com/ziutek/mymysql/mysql"
+)
+
+// an error with a SQLSTATE, as pgx's are
+type sqlStateError string
+
+func (e sqlStateError) Error() string    { return "SQLSTATE " + string(e) }
+func (e sqlStateError) SQLState() string { return string(e) }
+
+func TestIsUndefinedTable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&pq.Error{Code: "42P01"}, true},
+		{&pq.Error{Code: "42501"}, false}, // insufficient_privilege
+		{fmt.Errorf("reading version table: %w", &pq.Error{Code: "42P01"}), true},
+		{sqlStateError("42P01"), true},
+		{fmt.Errorf("reading version table: %w", sqlStateError("42P01")), true},
+		{errors.New(`relation "goose_db_version" does not exist`), false},
+		{nil, false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, isUndefinedTable(test.err), "%v", test.err)
+	}
+}
+
+func TestIsNoSuchTable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&mysql.MySQLError{Number: 1146}, true},
+		{&mysql.MySQLError{Number: 1045}, false}, // access denied
+		{&mymysql.Error{Code: 1146}, true},
+		{&mymysql.Error{Code: 2006}, false}, // server has gone away
+		{fmt.Errorf("reading version table: %w", &mysql.MySQLError{Number: 1146}), true},
+		{fmt.Errorf("reading version table: %w", &mymysql.Error{Code: 1146}), true},
+		{errors.New("Table 'goose.goose_db_version' doesn't exist"), false},
+		{nil, false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, isNoSuchTable(test.err), "%v", test.err)
+	}
+}
+
+func TestIsTableAlreadyExistsError(t *testing.T) {
+	tests := []struct {
+		d    SqlDialect
+		err  error
+		want bool
+	}{
+		{&PostgresDialect{}, &pq.Error{Code: "42P07"}, true},
+		{&PostgresDialect{}, &pq.Error{Code: "23505", Constraint: "pg_type_typname_nsp_index"}, true},
+		{&PostgresDialect{}, &pq.Error{Code: "23505", Constraint: "goose_db_version_pkey"}, false},
+		{&PostgresDialect{}, &pq.Error{Code: "42P01"}, false},
+		{&PostgresDialect{}, fmt.Errorf("creating version table: %w", &pq.Error{Code: "42P07"}), true},
+		{&PostgresDialect{}, fmt.Errorf("creating version table: %w", sqlStateError("42P07")), true},
+		{&CockroachDialect{}, &pq.Error{Code: "42P07"}, true},
+		{&MySqlDialect{}, &mysql.MySQLError{Number: 1050}, true},
+		{&MariaDBDialect{}, &mymysql.Error{Code: 1050}, true},
+		{&MySqlDialect{}, &mysql.MySQLError{Number: 1146}, false},
+		{&MySqlDialect{}, fmt.Errorf("creating version table: %w", &mysql.MySQLError{Number: 1050}), true},
+		{&Sqlite3Dialect{}, errors.New("table goose_db_version already exists"), true},
+		{&Sqlite3Dialect{}, errors.New("no such table: goose_db_version"), false},
+		{&SqlServerDialect{}, errors.New("mssql: There is already an object named 'goose_db_version' in the database."), true},
+		{&OracleDialect{}, errors.New("ORA-00955: name is already used by an existing object"), true},
+		{&DuckDBDialect{}, errors.New(`Catalog Error: Table with name "goose_db_version" already exists!`), true},
+		{&DuckDBDialect{}, errors.New(`Catalog Error: Sequence with name "goose_db_version_id_seq" already exists!`), false},
+		{&Sqlite3Dialect{}, nil, false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, test.d.isTableAlreadyExistsError(test.err), "%T %v", test.d, test.err)
+	}
+}
+
+func TestMySqlDialectDeadlock(t *testing.T) {
+	d := &MySqlDialect{}
+	assert.True(t, d.isDeadlockError(&mysql.MySQLError{Number: 1213}))
+	assert.True(t, d.isDeadlockError(&mymysql.Error{Code: 1205}))
+	assert.True(t, d.isDeadlockError(&MigrationError{Err: &mysql.MySQLError{Number: 1213}}))
+	assert.False(t, d.isDeadlockError(&mysql.MySQLError{Number: 1146}))
+	assert.False(t, d.isDeadlockError(errors.New("Deadlock found when trying to get lock")))
+	assert.Implements(t, (*deadlockDetector)(nil), &MariaDBDialect{})
+}
+
+func TestSearchPathSql(t *testing.T) {
+	require.NoError(t, SetSchema("Tenant_A"))
+	defer SetSchema("")
+
+	for _, d := range []schemaSearcher{&PostgresDialect{}, &RedshiftDialect{}, &CockroachDialect{}, &YugabyteDialect{}} {
+		set, reset := d.searchPathSql("Tenant_A", true)
+		assert.Equal(t, `SET LOCAL search_path TO "Tenant_A";`, set, "%T", d)
+		assert.Empty(t, reset, "%T", d)
+
+		set, reset = d.searchPathSql("Tenant_A", false)
+		assert.Equal(t, `SET search_path TO "Tenant_A";`, set, "%T", d)
+		assert.Equal(t, "RESET search_path;", reset, "%T", d)
+	}
+	assert.Contains(t, (&PostgresDialect{}).quotedTableName(), `"Tenant_A".`)
+}
+
+func TestReadOnlyError(t *testing.T) {
+	pgErr := &pq.Error{Code: "25006", Message: "cannot execute CREATE TABLE in a read-only transaction"}
+	assert.True(t, (&PostgresDialect{}).isReadOnlyError(pgErr))
+	assert.True(t, (&CockroachDialect{}).isReadOnlyError(&MigrationError{Err: pgErr}))
+	assert.False(t, (&PostgresDialect{}).isReadOnlyError(&pq.Error{Code: "42P01"}))
+
+	d := &MySqlDialect{}
+	assert.True(t, d.isReadOnlyError(&mysql.MySQLError{Number: 1290}))
+	assert.True(t, d.isReadOnlyError(&mymysql.Error{Code: 1792}))
+	assert.False(t, d.isReadOnlyError(&mysql.MySQLError{Number: 1146}))
+	assert.Implements(t, (*readOnlyDetector)(nil), &MariaDBDialect{})
+
+	// marked once, keeping the MigrationError
+	err := readOnlyError(&PostgresDialect{}, &MigrationError{Version: 1, Source: "001_setup.sql", Err: pgErr})
+	assert.ErrorIs(t, err, ErrReadOnlyDatabase)
+	var me *MigrationError
+	assert.ErrorAs(t, err, &me)
+	assert.Same(t, err, readOnlyError(&PostgresDialect{}, err))
+
+	assert.Same(t, pgErr, readOnlyError(&Sqlite3Dialect{}, pgErr))
+	assert.NoError(t, readOnlyError(&PostgresDialect{}, nil))
+}
+
+func TestMariaDBDialectMissingTable(t *testing.T) {
+	d := &MariaDBDialect{}
+	assert.True(t, d.isMissingTableError(&mysql.MySQLError{Number: 1146}))
+	assert.True(t, d.isMissingTableError(&mysql.MySQLError{Number: 1932}))
+	assert.True(t, d.isMissingTableError(&mymysql.Error{Code: 1932}))
+	assert.True(t, d.isMissingTableError(fmt.Errorf("reading version table: %w", &mysql.MySQLError{Number: 1932})))
+	assert.False(t, d.isMissingTableError(&mysql.MySQLError{Number: 1045}))
+	assert.False(t, (&MySqlDialect{}).isMissingTableError(&mysql.MySQLError{Number: 1932}))
+}
+
+func TestClickHouseDialectMissingTable(t *testing.T) {
+	d := &ClickHouseDialect{}
+	assert.True(t, d.isMissingTableError(errors.New("code: 60, message: Table default.goose_db_version doesn't exist")))
+	assert.True(t, d.isMissingTableError(errors.New("clickhouse [execute]:: 500 code: 60, message: Table default.goose_db_version doesn't exist")))
+	assert.False(t, d.isMissingTableError(errors.New("code: 600, message: something else")))
+	assert.False(t, d.isMissingTableError(errors.New("code: 57, message: Table default.goose_db_version already exists")))
+	assert.True(t, d.isTableAlreadyExistsError(errors.New("code: 57, message: Table default.goose_db_version already exists")))
+	assert.False(t, d.isTableAlreadyExistsError(errors.New("code: 570, message: something else")))
+	assert.False(t, d.isMissingTableError(nil))
+}
+
+func TestDuckDBDialectMissingTable(t *testing.T) {
+	d := &DuckDBDialect{}
+	assert.True(t, d.isMissingTableError(errors.New("Catalog Error: Table with name goose_db_version does not exist!")))
+	assert.True(t, d.isMissingTableError(errors.New("Catalog Error: Table with name goose_db_version does not exist!\nDid you mean \"goose_db_versions\"?")))
+	assert.False(t, d.isMissingTableError(errors.New(`Catalog Error: Table with name "goose_db_version" already exists!`)))
+	assert.False(t, d.isMissingTableError(nil))
+}
+
+func TestQuotedTableName(t *testing.T) {
+	require.NoError(t, SetTableName("order"))
+	defer SetTableName(defaultTableName)
+
+	tests := []struct {
+		d          SqlDialect
+		want       string
+		wantSchema string
+	}{
+		{&PostgresDialect{}, `"order"`, `"select"."order"`},
+		{&RedshiftDialect{}, `"order"`, `"select"."order"`},
+		{&CockroachDialect{}, `"order"`, `"select"."order"`},
+		{&YugabyteDialect{}, `"order"`, `"select"."order"`},
+		{&MySqlDialect{}, "`order`", "`select`.`order`"},
+		{&MariaDBDialect{}, "`order`", "`select`.`order`"},
+		{&Sqlite3Dialect{}, `"order"`, `"select"."order"`},
+		{&SqlServerDialect{}, "[order]", "[select].[order]"},
+		{&OracleDialect{}, `"ORDER"`, `"SELECT"."ORDER"`},
+		{&ClickHouseDialect{}, "`order`", "`select`.`order`"},
+		{&SpannerDialect{}, "`order`", "`select`.`order`"},
+		{&VerticaDialect{}, `"order"`, `"select"."order"`},
+		{&DuckDBDialect{}, `"order"`, `"select"."order"`},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, test.d.quotedTableName(), "%T", test.d)
+		assert.Contains(t, test.d.createVersionTableSql(), "CREATE TABLE "+test.want+" (", "%T", test.d)
+		assert.Contains(t, test.d.insertVersionSql(), "INSERT INTO "+test.want+" (", "%T", test.d)
+		assert.Contains(t, test.d.insertVersionColumnsSql([]string{"checksum"}), "INSERT INTO "+test.want+" (", "%T", test.d)
+	}
+
+	require.NoError(t, SetSchema("select"))
+	defer SetSchema("")
+	for _, test := range tests {
+		assert.Equal(t, test.wantSchema, test.d.quotedTableName(), "%T", test.d)
+	}
+}
+
+func TestTimestampDefault(t *testing.T) {
+	pg := &PostgresDialect{}
+	assert.Contains(t, pg.createVersionTableSql(), "default now(),")
+
+	SetUTCTimestamps(true)
+	defer SetUTCTimestamps(false)
+	assert.Contains(t, pg.createVersionTableSql(), "default timezone('utc', now()),")
+	assert.Contains(t, (&MySqlDialect{}).createVersionTableSql(), "default (UTC_TIMESTAMP()),")
+	assert.Contains(t, (&SqlServerDialect{}).createVersionTableSql(), "DEFAULT GETUTCDATE(),")
+	assert.Contains(t, (&Sqlite3Dialect{}).createVersionTableSql(), "DEFAULT (datetime('now')),")
+	assert.Contains(t, (&RedshiftDialect{}).insertVersionSql(), "VALUES ($1, $2, SYSDATE);")
+
+	// a tstamp from DBConf.Clock takes the place of the default
+	assert.Equal(t, `INSERT INTO "goose_db_version" (version_id, is_applied, tstamp) VALUES ($1, $2, $3);`,
+		(&RedshiftDialect{}).insertVersionColumnsSql([]string{"tstamp"}))
+}
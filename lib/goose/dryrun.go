@@ -0,0 +1,96 @@
+package goose
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DryRun writes to w the statements that RunMigrationsOnDb would execute
+// to take db to target, including the updates to the version table,
+// grouped by migration and in the order they would run.
+//
+// db is only read from, to find which migrations are applied: no
+// transaction is opened and nothing is written, not even the version
+// table if it is missing. The statements of Go migrations cannot be
+// known ahead of time, so only their version table update is written.
+func DryRun(conf *DBConf, migrationsDir string, target int64, db *sql.DB, w io.Writer) error {
+	return DryRunContext(context.Background(), conf, migrationsDir, target, db, w)
+}
+
+// DryRunContext is DryRun with a context.
+func DryRunContext(ctx context.Context, conf *DBConf, migrationsDir string, target int64, db *sql.DB, w io.Writer) error {
+	d := conf.Driver.Dialect
+
+	current, migrations, err := readMigrationsStatus(ctx, conf, migrationsDir, DBExecutor(db))
+	switch err {
+	case nil:
+	case ErrTableDoesNotExist:
+		fmt.Fprintf(w, "-- create %s\n%s\n%s -- 0, true\n\n", TableName(), createVersionTableSQL(d), d.insertVersionSql())
+	default:
+		return err
+	}
+
+	ms, direction := migrationsToTarget(conf, migrations, current, target)
+
+	for _, m := range ms {
+		script := m.script(direction)
+		fmt.Fprintf(w, "-- %s %s\n", direction, filepath.Base(script))
+
+		switch m.Type() {
+		case "go":
+			fmt.Fprintf(w, "-- Go migration, statements not shown\n")
+		case "sql":
+			stmts, useTx, err := readSQLStatements(conf, script, direction)
+			if err != nil {
+				return err
+			}
+
+			if set, _ := searchPath(conf, useTx); set != "" {
+				fmt.Fprintln(w, set)
+			}
+			set, _ := timeouts(conf, useTx)
+			for _, query := range set {
+				fmt.Fprintln(w, query)
+			}
+
+			for _, query := range stmts {
+				fmt.Fprint(w, query)
+			}
+		}
+
+		rec := MigrationRecord{Source: filepath.Base(script)}
+		if conf.RecordChecksums && direction == DirectionUp {
+			if rec.Checksum, err = migrationChecksum(m.Source); err != nil {
+				return err
+			}
+		}
+
+		cols, args := versionRecordColumns(conf, direction, rec)
+		if len(cols) == 0 {
+			fmt.Fprintf(w, "%s -- %d, %t\n\n", d.insertVersionSql(), m.Version, bool(direction))
+			continue
+		}
+
+		vals := []string{strconv.FormatInt(m.Version, 10), strconv.FormatBool(bool(direction))}
+		for _, a := range args {
+			switch a := a.(type) {
+			case sql.NullString:
+				if a.Valid {
+					vals = append(vals, strconv.Quote(a.String))
+				} else {
+					vals = append(vals, "NULL")
+				}
+			case clockValue:
+				vals = append(vals, "<DBConf.Clock>")
+			}
+		}
+		fmt.Fprintf(w, "%s -- %s\n\n", d.insertVersionColumnsSql(cols), strings.Join(vals, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package goose
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// MigrationError is the error a migration fails with, identifying the
+// migration and, if it was one of its statements that failed, which.
+// Use errors.As to get at it from the error a run returns.
+type MigrationError struct {
+	Version   int64
+	Source    string // path to the .go or .sql script
+	Statement string // exactly as sent to the database; "" if no statement failed
+	Err       error
+}
+
+func (e *MigrationError) Error() string {
+	if e.Statement == "" {
+		return fmt.Sprintf("%s: %v", filepath.Base(e.Source), e.Err)
+	}
+	return fmt.Sprintf("%s: %v, in statement:\n%s", filepath.Base(e.Source), e.Err, strings.TrimSpace(e.Statement))
+}
+
+func (e *MigrationError) Unwrap() error { return e.Err }
+
+// ErrIrreversible is the error rolling back a Go migration fails with
+// when the migration declares its Down func as a nil variable, marking
+// it as deliberately impossible to roll back.
+type ErrIrreversible struct {
+	Version int64
+}
+
+func (e ErrIrreversible) Error() string {
+	return fmt.Sprintf("migration %d is irreversible", e.Version)
+}
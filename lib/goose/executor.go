@@ -0,0 +1,119 @@
+package goose
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Executor is what goose migrates a database through, so that drivers
+// with no database/sql adapter, or programs that would rather not open
+// one, can run migrations on connections of their own. DBExecutor makes
+// one of a *sql.DB; lib/goosepgx makes one of a pgx pool.
+type Executor interface {
+	Querier
+	// Begin starts a transaction on a connection of the executor's
+	// choosing, which the transaction keeps until it ends.
+	Begin(ctx context.Context) (Tx, error)
+	// Conn reserves a connection, for the session locks and settings that
+	// must outlive a single statement, until it is closed.
+	Conn(ctx context.Context) (Conn, error)
+}
+
+// Querier runs statements and queries, with the arguments bound as the
+// dialect's placeholders say.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (Rows, error)
+}
+
+// Rows is the result of a query, as *sql.Rows is.
+type Rows interface {
+	Next() bool
+	Scan(dest ...interface{}) error
+	Err() error
+	Close() error
+}
+
+// Tx is a transaction begun by an Executor or a Conn.
+type Tx interface {
+	Querier
+	Commit() error
+	Rollback() error
+}
+
+// Conn is a connection reserved by an Executor.
+type Conn interface {
+	Querier
+	Begin(ctx context.Context) (Tx, error)
+	// Close gives the connection back to the executor.
+	Close() error
+}
+
+// DBExecutor returns an Executor running on db, as the functions of goose
+// that take a *sql.DB do.
+func DBExecutor(db *sql.DB) Executor {
+	return sqlDB{db}
+}
+
+type sqlDB struct{ *sql.DB }
+
+func (db sqlDB) QueryContext(ctx context.Context, query string, args ...interface{}) (Rows, error) {
+	return db.DB.QueryContext(ctx, query, args...)
+}
+
+func (db sqlDB) Begin(ctx context.Context) (Tx, error) {
+	txn, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return sqlTx{txn}, nil
+}
+
+func (db sqlDB) Conn(ctx context.Context) (Conn, error) {
+	conn, err := db.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return sqlConn{conn}, nil
+}
+
+type sqlConn struct{ *sql.Conn }
+
+func (conn sqlConn) QueryContext(ctx context.Context, query string, args ...interface{}) (Rows, error) {
+	return conn.Conn.QueryContext(ctx, query, args...)
+}
+
+func (conn sqlConn) Begin(ctx context.Context) (Tx, error) {
+	txn, err := conn.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return sqlTx{txn}, nil
+}
+
+type sqlTx struct{ *sql.Tx }
+
+func (txn sqlTx) QueryContext(ctx context.Context, query string, args ...interface{}) (Rows, error) {
+	return txn.Tx.QueryContext(ctx, query, args...)
+}
+
+// the single row of query on q, scanned into dest, as QueryRowContext
+// would
+func queryRow(ctx context.Context, q queryer, query string, args []interface{}, dest ...interface{}) error {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	if err = rows.Scan(dest...); err != nil {
+		return err
+	}
+	return rows.Close()
+}
@@ -0,0 +1,133 @@
+package goose
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Fix renumbers the TimestampNumbering migrations in dir sequentially, in
+// timestamp order, following the highest sequential version in dir. The
+// descriptive part of each filename is kept, and the Up and Down funcs of
+// Go migrations are renamed to match.
+//
+// It returns the path of each migration renamed, keyed by its old path,
+// so running it again once done renames nothing. Fix knows nothing of
+// the databases the migrations have been applied to; renumbering an
+// applied migration makes it look pending. See FixOnDb.
+//
+// Fix renames files on the OS filesystem, so fails if SetBaseFS has made
+// goose read migrations from elsewhere.
+func Fix(dir string) (map[string]string, error) {
+	if _, ok := baseFS.(osFS); !ok {
+		return nil, errors.New("cannot renumber migrations read through SetBaseFS, only those on the OS filesystem")
+	}
+
+	migrations, err := collectMigrations(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var version int64
+	var timestamped []*Migration
+	for _, m := range migrations {
+		if isTimestampVersion(m.Source) {
+			timestamped = append(timestamped, m)
+		} else if m.Version > version {
+			version = m.Version
+		}
+	}
+	sort.Sort(migrationSorter(timestamped))
+
+	renamed := map[string]string{}
+	for _, m := range timestamped {
+		version++
+
+		base := filepath.Base(m.Source)
+		newBase := fmt.Sprintf("%05d%s", version, base[strings.Index(base, "_"):])
+		newPath := filepath.Join(filepath.Dir(m.Source), newBase)
+
+		if _, err := os.Stat(newPath); err == nil {
+			return renamed, fmt.Errorf("cannot rename %s: %s already exists", base, newBase)
+		}
+
+		if m.Type() == "go" {
+			if err := renameGoMigrationFuncs(m.Source, m.Version, version); err != nil {
+				return renamed, err
+			}
+		}
+
+		if err := os.Rename(m.Source, newPath); err != nil {
+			return renamed, err
+		}
+		renamed[m.Source] = newPath
+
+		if m.DownSource != "" {
+			downBase := filepath.Base(m.DownSource)
+			newDownPath := filepath.Join(filepath.Dir(m.DownSource), fmt.Sprintf("%05d%s", version, downBase[strings.Index(downBase, "_"):]))
+			if err := os.Rename(m.DownSource, newDownPath); err != nil {
+				return renamed, err
+			}
+			renamed[m.DownSource] = newDownPath
+		}
+	}
+
+	return renamed, nil
+}
+
+// FixOnDb is Fix, but first checks that none of the migrations it would
+// renumber have been applied to db, and renames nothing if any have.
+func FixOnDb(conf *DBConf, dir string, db *sql.DB) (map[string]string, error) {
+	_, migrations, err := readMigrationsStatus(context.Background(), conf, dir, DBExecutor(db))
+	if err != nil && err != ErrTableDoesNotExist {
+		return nil, err
+	}
+
+	var applied []string
+	for _, m := range migrations {
+		if m.IsApplied && isTimestampVersion(m.Source) {
+			applied = append(applied, filepath.Base(m.Source))
+		}
+	}
+	if len(applied) > 0 {
+		sort.Strings(applied)
+		return nil, fmt.Errorf("not renumbering migrations, some are already applied: %s", strings.Join(applied, ", "))
+	}
+
+	return Fix(dir)
+}
+
+// report whether the migration at path has a TimestampNumbering version
+func isTimestampVersion(path string) bool {
+	base := filepath.Base(path)
+	return strings.Index(base, "_") == len(timestampFormat)
+}
+
+// rename the Up and Down funcs of the Go migration at path from version
+// from to version to, in place, whether declared as funcs or as variables
+// as goMigrationFuncDecl finds them
+func renameGoMigrationFuncs(path string, from, to int64) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range []Direction{DirectionUp, DirectionDown} {
+		decl := regexp.MustCompile(`(?m)^(func|var)(\s+)` + regexp.QuoteMeta(goMigrationFunc(d, from)) + `\b`)
+		b = decl.ReplaceAll(b, []byte("${1}${2}"+goMigrationFunc(d, to)))
+	}
+
+	return ioutil.WriteFile(path, b, info.Mode())
+}
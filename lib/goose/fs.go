@@ -0,0 +1,36 @@
+package goose
+
+import (
+	"io/fs"
+	"os"
+)
+
+// baseFS is where migration scripts are read from.
+var baseFS fs.FS = osFS{}
+
+// SetBaseFS makes goose read migration scripts from fsys, such as an
+// embed.FS, rather than from the OS filesystem. Migration directories
+// are then paths within fsys, e.g. "migrations". Passing nil restores
+// the default of reading from the OS filesystem.
+//
+// goose only ever calls Open on fsys, using fs.ReadDirFS and fs.ReadFileFS
+// where fsys implements them, so any fs.FS will do: a *zip.Reader, or an
+// adapter over object storage for migrations shipped out of band.
+//
+// Go migrations are still run with `go run`, so they are copied out of
+// fsys into a temporary directory first.
+func SetBaseFS(fsys fs.FS) {
+	if fsys == nil {
+		fsys = osFS{}
+	}
+	baseFS = fsys
+}
+
+// osFS reads from the OS filesystem. Unlike os.DirFS it accepts any path
+// the os package does, absolute and relative alike, so it behaves just as
+// goose did before migrations were read through fs.FS.
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
@@ -0,0 +1,95 @@
+package goose
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"time"
+)
+
+// LockMode controls whether goose serializes concurrent migration runs
+// against the same database.
+type LockMode int
+
+const (
+	// LockModeAdvisory holds a database advisory lock for the duration
+	// of a run, on dialects that support one. Dialects without advisory
+	// locks run unlocked.
+	LockModeAdvisory LockMode = iota
+	// LockModeNone never takes a lock.
+	LockModeNone
+)
+
+// migrationLocker is implemented by dialects that can take a session
+// level lock keyed on the version table.
+//
+// Session locks belong to the connection that took them, so lock and
+// unlock are always handed the same Conn. A timeout of zero means
+// wait indefinitely.
+type migrationLocker interface {
+	lock(ctx context.Context, conn Querier, timeout time.Duration) error
+	unlock(ctx context.Context, conn Querier) error
+}
+
+// lockKey derives a stable advisory lock key from the version table name,
+// so that every migrator sharing a version table contends for the same lock.
+func lockKey() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(TableName()))
+	return int64(h.Sum64())
+}
+
+// acquire the migration lock for conf's dialect, if it has one.
+// The returned func releases the lock and must always be called.
+//
+// The lock is held on a dedicated connection, so db must allow at least
+// two open connections.
+func lockDB(ctx context.Context, conf *DBConf, db Executor) (func() error, error) {
+	noop := func() error { return nil }
+
+	l, ok := conf.Driver.Dialect.(migrationLocker)
+	if !ok || conf.LockMode == LockModeNone {
+		return noop, nil
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return noop, err
+	}
+
+	lockCtx := ctx
+	if conf.LockTimeout > 0 {
+		var cancel context.CancelFunc
+		lockCtx, cancel = context.WithTimeout(ctx, conf.LockTimeout)
+		defer cancel()
+	}
+
+	if err := l.lock(lockCtx, conn, conf.LockTimeout); err != nil {
+		conn.Close()
+		return noop, fmt.Errorf("acquiring migration lock: %s", err)
+	}
+
+	return func() error {
+		defer conn.Close()
+		// use a fresh context so the lock is released even if ctx was cancelled
+		if err := l.unlock(context.Background(), conn); err != nil {
+			return fmt.Errorf("releasing migration lock: %s", err)
+		}
+		return nil
+	}, nil
+}
+
+// withLock runs f while holding the migration lock, as per lockDB.
+func withLock(ctx context.Context, conf *DBConf, db Executor, f func() error) (err error) {
+	unlock, err := lockDB(ctx, conf, db)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := unlock(); e != nil && err == nil {
+			err = e
+		}
+	}()
+
+	return f()
+}
@@ -0,0 +1,43 @@
+package goose
+
+import (
+	"log"
+)
+
+// Logger is what goose reports its progress and warnings to. *log.Logger
+// satisfies it, as do the sugared loggers of most logging libraries.
+// goose returns its errors rather than calling Fatalf, which is kept for
+// the loggers already written against it.
+type Logger interface {
+	Fatalf(format string, v ...interface{})
+	Printf(format string, v ...interface{})
+	Println(v ...interface{})
+}
+
+// logger is where goose logs to.
+var logger Logger = stdLogger{}
+
+// SetLogger makes goose log to l. Passing nil restores the default of
+// logging with the log package, to stderr.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = stdLogger{}
+	}
+	logger = l
+}
+
+// stdLogger logs with the standard logger of the log package.
+type stdLogger struct{}
+
+func (stdLogger) Fatalf(format string, v ...interface{}) { log.Fatalf(format, v...) }
+func (stdLogger) Printf(format string, v ...interface{}) { log.Printf(format, v...) }
+func (stdLogger) Println(v ...interface{})               { log.Println(v...) }
+
+// NopLogger discards the progress and warnings logged to it. A fatal
+// error is not discarded: it is logged as log.Fatalf does, before the
+// program exits.
+type NopLogger struct{}
+
+func (NopLogger) Fatalf(format string, v ...interface{}) { log.Fatalf(format, v...) }
+func (NopLogger) Printf(format string, v ...interface{}) {}
+func (NopLogger) Println(v ...interface{})               {}
@@ -1,12 +1,18 @@
 package goose
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"io/fs"
+	"math"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -17,6 +23,23 @@ import (
 var (
 	ErrTableDoesNotExist = errors.New("table does not exist")
 	ErrNoPreviousVersion = errors.New("no previous version found")
+	ErrNoNextVersion     = errors.New("no next version found")
+	ErrDownNotConfirmed  = errors.New("rollback not confirmed")
+	ErrNotApplied        = errors.New("migration not applied")
+	ErrNoCurrentVersion  = errors.New("no current version found")
+
+	// ErrReadOnlyDatabase marks the error a run fails with when the
+	// database is read-only, as a read replica is, on the dialects that
+	// can tell: postgres, redshift, cockroach, yugabyte, mysql and
+	// mariadb. They check before the first migration runs; a write that
+	// is refused regardless fails wrapping the database's own error.
+	ErrReadOnlyDatabase = errors.New("database is read-only, so goose is likely connected to a read replica rather than the primary")
+
+	// ErrSkip, returned by a Go migration, possibly wrapped, skips it:
+	// whatever it did in its transaction is rolled back, but its version
+	// is recorded as usual, so it is marked applied (or rolled back) and
+	// will not run again.
+	ErrSkip = errors.New("migration skipped")
 )
 
 type Direction bool
@@ -34,16 +57,254 @@ const (
 	DirectionUp   = Direction(true)
 )
 
+// Targets with a meaning of their own, for RunMigrationsOnDb, MigrateTo,
+// UpTo and DownTo, so that callers needn't spell out versions.
+const (
+	// Zero is the version before any migration, so that migrating down
+	// to it rolls back every migration.
+	Zero int64 = 0
+
+	// Latest is the newest migration in the migrations directory, so that
+	// migrating up to it applies every pending migration.
+	Latest int64 = math.MaxInt64
+)
+
+// the version target stands for: Latest is the newest of migrations,
+// which are sorted, or Zero if there are none
+func resolveTarget(migrations []*Migration, target int64) int64 {
+	if target != Latest {
+		return target
+	}
+	if len(migrations) == 0 {
+		return Zero
+	}
+	return migrations[len(migrations)-1].Version
+}
+
 //go:generate sh -c "go get github.com/jteeuwen/go-bindata/go-bindata && go-bindata -pkg goose -o templates.go -nometadata -nocompress ./templates && gofmt -w templates.go"
 var goMigrationDriverTemplate = template.Must(template.New("").Parse(string(_templatesMigrationMainGoTmpl)))
 var goMigrationTemplate = template.Must(template.New("").Parse(string(_templatesMigrationGoTmpl)))
 var sqlMigrationTemplate = template.Must(template.New("").Parse(string(_templatesMigrationSqlTmpl)))
 
+// the schema set with SetSchema, if any
+var schemaName string
+
+// defaultTableName is the version table used unless SetTableName says otherwise
+const defaultTableName = "goose_db_version"
+
+// the version table, as set with SetTableName
+var tableName = defaultTableName
+
+// identifierPattern is what goose accepts as a schema or table name, as
+// they are interpolated into its SQL rather than passed as parameters.
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// TableName returns the name of the table goose uses to track
+// applied migrations, qualified with the schema set with SetSchema.
+func TableName() string {
+	if schemaName != "" {
+		return schemaName + "." + tableName
+	}
+	return tableName
+}
+
+// SetTableName makes goose track applied migrations in the table name
+// rather than goose_db_version, so that several applications can keep
+// their migrations apart in one database. The dialect quotes the name in
+// its SQL, so it may be a reserved word, and its case is kept where
+// quoting keeps it.
+//
+// name may be qualified, as in "migrations_db.goose_db_version", which
+// is SetSchema("migrations_db") followed by SetTableName("goose_db_version"),
+// for instance to keep the table in one mysql database whichever the
+// connection defaults to.
+func SetTableName(name string) error {
+	schema, table := "", name
+	if i := strings.Index(name, "."); i >= 0 {
+		schema, table = name[:i], name[i+1:]
+		if !identifierPattern.MatchString(schema) {
+			return fmt.Errorf("invalid table name %q: must be letters, digits and underscores, not starting with a digit, and qualified at most once", name)
+		}
+	}
+	if !identifierPattern.MatchString(table) {
+		return fmt.Errorf("invalid table name %q: must be letters, digits and underscores, not starting with a digit, and qualified at most once", name)
+	}
+	if schema != "" {
+		schemaName = schema
+	}
+	tableName = table
+	return nil
+}
+
+// appliedBy is recorded against each migration when
+// DBConf.RecordAppliedBy is set, as set with SetAppliedBy
+var appliedBy = defaultAppliedBy()
+
+// the host goose is running on, or "" if it cannot be told
+func defaultAppliedBy() string {
+	host, _ := os.Hostname()
+	return host
+}
+
+// SetAppliedBy sets who or what is recorded as applying migrations when
+// DBConf.RecordAppliedBy is set, such as the name of a CI job. Passing ""
+// restores the default of the hostname.
+func SetAppliedBy(by string) {
+	if by == "" {
+		by = defaultAppliedBy()
+	}
+	appliedBy = by
+}
+
+// utcTimestamps makes the version table default tstamp to the time in
+// UTC, as set with SetUTCTimestamps
+var utcTimestamps bool
+
+// SetUTCTimestamps makes the version table goose creates record when
+// each migration ran in UTC, rather than in the time zone of the database
+// session, on the databases where these differ: postgres, cockroach,
+// yugabyte, mysql (8.0.13 or later), mssql, oracle, vertica and duckdb.
+// The other databases record UTC already. It only affects version tables
+// created after it is set, as the time comes from the column's default.
+func SetUTCTimestamps(utc bool) {
+	utcTimestamps = utc
+}
+
+// versionTableSQL creates the version table in place of the dialect's
+// statement, as set with SetVersionTableSQL
+var versionTableSQL string
+
+// SetVersionTableSQL makes goose create its version table with query
+// rather than the dialect's own statement, such as to place it in a given
+// tablespace or with given storage parameters. query must create the
+// table with the columns of the dialect's statement, which
+// VersionTableSQL gives beforehand, as the dialect's statements are still
+// used to read and record versions. It must name the table as TableName()
+// does, quoted or not, so it fails if the table name isn't in it, as does
+// creating the table if SetTableName later renames it. Passing "" restores
+// the dialect's statement.
+func SetVersionTableSQL(query string) error {
+	if err := checkVersionTableSQL(query); err != nil {
+		return err
+	}
+	versionTableSQL = query
+	return nil
+}
+
+// report whether query, if not "", names the version table, as its name
+// may have been set after SetVersionTableSQL
+func checkVersionTableSQL(query string) error {
+	if query != "" && !strings.Contains(strings.ToLower(query), strings.ToLower(tableName)) {
+		return fmt.Errorf("version table SQL does not create %s: %q", TableName(), query)
+	}
+	return nil
+}
+
+// the statement that creates the version table for d
+func createVersionTableSQL(d SqlDialect) string {
+	if versionTableSQL != "" {
+		return versionTableSQL
+	}
+	return d.createVersionTableSql()
+}
+
+// SetSchema makes goose keep its version table in schema, rather than
+// wherever the connection defaults to, so that one database can hold a
+// separate set of migrations per schema. The schema must exist. On
+// postgres, redshift and cockroach, SQL migrations also run with their
+// search_path set to schema. Passing "" restores the default.
+func SetSchema(schema string) error {
+	if schema != "" && !identifierPattern.MatchString(schema) {
+		return fmt.Errorf("invalid schema name %q: must be letters, digits and underscores, not starting with a digit", schema)
+	}
+	schemaName = schema
+	return nil
+}
+
+// the file extensions of migrations, as set with SetMigrationExtensions
+var migrationExtensions = []string{".sql", ".go"}
+
+// SetMigrationExtensions sets which files in the migrations directory are
+// migrations, by extension, in place of the default of .sql and .go, for
+// directories that hold other files too. Files ending in .go are Go
+// migrations and files ending in any other extension are read as SQL,
+// so a preprocessing step can leave migrations as, say, .sql.tmpl files.
+// Each extension must start with a "."; other files are ignored.
+func SetMigrationExtensions(exts ...string) error {
+	if len(exts) == 0 {
+		return errors.New("no migration extensions given")
+	}
+	for _, ext := range exts {
+		if len(ext) < 2 || ext[0] != '.' {
+			return fmt.Errorf("invalid migration extension %q: must start with a \".\"", ext)
+		}
+	}
+	migrationExtensions = append([]string(nil), exts...)
+	return nil
+}
+
+// the extension set with SetMigrationExtensions that name ends with, the
+// longest if several do, or "" if name is not a migration
+func migrationExt(name string) string {
+	ext := ""
+	for _, e := range migrationExtensions {
+		if strings.HasSuffix(name, e) && len(e) > len(ext) {
+			ext = e
+		}
+	}
+	return ext
+}
+
 type Migration struct {
 	Version   int64
 	IsApplied bool
 	TStamp    time.Time
 	Source    string // path to .go or .sql script
+
+	// DownSource is the .down.sql script of a migration split across an
+	// .up.sql and a .down.sql file, if it has one; Source is then the
+	// .up.sql script.
+	DownSource string
+
+	// Previous and Next are the versions either side of this one among
+	// the migrations returned by GetMigrations, or -1 at either end.
+	Previous int64
+	Next     int64
+
+	// Metadata holds the "-- goose: key=value" lines at the top of an SQL
+	// migration, and Description its "description" key, for change logs.
+	// Metadata is nil if there are none.
+	Description string
+	Metadata    map[string]string
+}
+
+// the suffixes of the scripts of a migration split across two files
+const (
+	upFileSuffix   = ".up.sql"
+	downFileSuffix = ".down.sql"
+)
+
+// report whether path is one half of a migration split across two files
+func isSplitSQL(path string) bool {
+	return strings.HasSuffix(path, upFileSuffix) || strings.HasSuffix(path, downFileSuffix)
+}
+
+// the script to run to take m in direction. A split migration with no
+// .down.sql file rolls back with the .up.sql one, which has no Down section.
+func (m *Migration) script(direction Direction) string {
+	if direction == DirectionDown && m.DownSource != "" {
+		return m.DownSource
+	}
+	return m.Source
+}
+
+// Type returns the kind of script the migration is, "sql" or "go",
+// as passed to CreateMigration.
+func (m *Migration) Type() string {
+	if migrationExt(m.Source) == ".go" {
+		return "go"
+	}
+	return "sql"
 }
 
 type migrationSorter []*Migration
@@ -64,22 +325,118 @@ func RunMigrations(conf *DBConf, migrationsDir string, target int64) (err error)
 }
 
 // Runs migration on a specific database instance.
-func RunMigrationsOnDb(conf *DBConf, migrationsDir string, target int64, db *sql.DB) (err error) {
+//
+// Going up, every pending migration at or below target is applied, including
+// any older than the current version that were added after newer ones
+// were applied.
+//
+// Unless conf.LockMode is LockModeNone, the run holds the dialect's
+// advisory lock (if any) so that concurrent migrators take turns.
+//
+// If a migration fails, the error wraps a *MigrationError identifying it.
+func RunMigrationsOnDb(conf *DBConf, migrationsDir string, target int64, db *sql.DB) error {
+	return RunMigrationsOnDbContext(context.Background(), conf, migrationsDir, target, db)
+}
+
+// RunMigrationsOnDbContext is RunMigrationsOnDb with a context. ctx is
+// used for every query and statement of the run, so cancelling it
+// rolls back the migration in flight and stops before the next one.
+func RunMigrationsOnDbContext(ctx context.Context, conf *DBConf, migrationsDir string, target int64, db *sql.DB) error {
+	return RunMigrationsOnExecutorContext(ctx, conf, migrationsDir, target, DBExecutor(db))
+}
+
+// RunMigrationsOnExecutor is RunMigrationsOnDb on an Executor, for
+// databases reached other than through database/sql. Go migrations still
+// run in a program of their own, connecting with conf.Driver.
+func RunMigrationsOnExecutor(conf *DBConf, migrationsDir string, target int64, db Executor) error {
+	return RunMigrationsOnExecutorContext(context.Background(), conf, migrationsDir, target, db)
+}
+
+// RunMigrationsOnExecutorContext is RunMigrationsOnExecutor with a context;
+// see RunMigrationsOnDbContext.
+func RunMigrationsOnExecutorContext(ctx context.Context, conf *DBConf, migrationsDir string, target int64, db Executor) error {
+	return withLock(ctx, conf, db, func() error {
+		_, err := runMigrationsOnDb(ctx, conf, migrationsDir, target, db)
+		return err
+	})
+}
+
+// Result is what a run of migrations did.
+type Result struct {
+	Applied      []int64 // versions applied, in the order they were
+	RolledBack   []int64 // versions rolled back, in the order they were
+	FinalVersion int64   // the version of the database after the run
+}
+
+// MigrateTo is RunMigrationsOnDb, but returns a Result saying which
+// migrations it ran. If a migration fails, the Result still lists those
+// that went through before it, and the version the database was left at.
+func MigrateTo(conf *DBConf, migrationsDir string, target int64, db *sql.DB) (Result, error) {
+	return MigrateToContext(context.Background(), conf, migrationsDir, target, db)
+}
+
+// MigrateToContext is MigrateTo with a context; see RunMigrationsOnDbContext.
+func MigrateToContext(ctx context.Context, conf *DBConf, migrationsDir string, target int64, db *sql.DB) (Result, error) {
+	return MigrateToExecutorContext(ctx, conf, migrationsDir, target, DBExecutor(db))
+}
+
+// MigrateToExecutor is MigrateTo on an Executor; see RunMigrationsOnExecutor.
+func MigrateToExecutor(conf *DBConf, migrationsDir string, target int64, db Executor) (Result, error) {
+	return MigrateToExecutorContext(context.Background(), conf, migrationsDir, target, db)
+}
+
+// MigrateToExecutorContext is MigrateToExecutor with a context.
+func MigrateToExecutorContext(ctx context.Context, conf *DBConf, migrationsDir string, target int64, db Executor) (res Result, err error) {
+	err = withLock(ctx, conf, db, func() error {
+		res, err = runMigrationsOnDb(ctx, conf, migrationsDir, target, db)
+		if len(res.Applied) == 0 && len(res.RolledBack) == 0 {
+			return err
+		}
+
+		version, verr := getDBVersionOnDb(ctx, conf, db)
+		if verr == nil {
+			res.FinalVersion = version
+		} else if err == nil {
+			err = verr
+		}
+		return err
+	})
+
+	return res, err
+}
+
+// run the migrations to target, returning what was run, with
+// FinalVersion the version before the run
+func runMigrationsOnDb(ctx context.Context, conf *DBConf, migrationsDir string, target int64, db Executor) (res Result, err error) {
 	//TODO get rid of migrationsDir, it's already in conf.MigrationsDir
-	current, err := EnsureDBVersion(conf, db)
+	current, migrations, err := migrationsWithStatus(ctx, conf, migrationsDir, db)
 	if err != nil {
-		return err
+		return res, err
 	}
+	res.FinalVersion = current
+	target = resolveTarget(migrations, target)
 
-	migrations, err := CollectMigrations(migrationsDir)
-	if err != nil {
-		return err
+	ms, direction := migrationsToTarget(conf, migrations, current, target)
+	if len(ms) == 0 {
+		logger.Printf("goose: no migrations to run. current version: %d, target: %d\n", current, target)
+		return res, nil
 	}
 
-	if err := getMigrationsStatus(conf, db, migrations); err != nil {
-		return err
+	logger.Printf("goose: migrating db, current version: %d, target: %d\n", current, target)
+
+	done, err := runMigrations(ctx, conf, db, ms, direction)
+	if direction == DirectionUp {
+		res.Applied = done
+	} else {
+		res.RolledBack = done
 	}
+	return res, err
+}
 
+// pick out the migrations that must run to take a db at version current
+// to version target, in the order they must run, and which way they go.
+// Migrations of a type conf.MigrationType excludes are left out.
+func migrationsToTarget(conf *DBConf, migrations []*Migration, current, target int64) ([]*Migration, Direction) {
 	direction := DirectionUp
 	if target < current {
 		direction = DirectionDown
@@ -87,6 +444,9 @@ func RunMigrationsOnDb(conf *DBConf, migrationsDir string, target int64, db *sql
 
 	var neededMigrations []*Migration
 	for _, m := range migrations {
+		if !typeIncluded(conf, m) {
+			continue
+		}
 		if direction == DirectionUp {
 			if m.Version > target {
 				continue
@@ -105,72 +465,1090 @@ func RunMigrationsOnDb(conf *DBConf, migrationsDir string, target int64, db *sql
 		neededMigrations = append(neededMigrations, m)
 	}
 
+	ms := migrationSorter(neededMigrations)
+	if direction == DirectionDown {
+		sort.Sort(sort.Reverse(ms))
+	}
+
+	return ms, direction
+}
+
+// UpByOne applies the lowest numbered pending migration newer than the
+// current version of db, and returns the version applied.
+//
+// If conf.AllowOutOfOrder is set, it applies the lowest numbered pending
+// migration instead, even if that is older than the current version.
+//
+// If there is no such migration, it returns the current version
+// along with ErrNoNextVersion.
+func UpByOne(conf *DBConf, migrationsDir string, db *sql.DB) (int64, error) {
+	return UpByOneContext(context.Background(), conf, migrationsDir, db)
+}
+
+// UpByOneContext is UpByOne with a context; see RunMigrationsOnDbContext.
+func UpByOneContext(ctx context.Context, conf *DBConf, migrationsDir string, db *sql.DB) (version int64, err error) {
+	e := DBExecutor(db)
+	err = withLock(ctx, conf, e, func() error {
+		current, migrations, err := migrationsWithStatus(ctx, conf, migrationsDir, e)
+		if err != nil {
+			return err
+		}
+		version = current
+
+		for _, m := range migrations {
+			if (m.Version > current || conf.AllowOutOfOrder) && !m.IsApplied && typeIncluded(conf, m) {
+				logger.Printf("goose: migrating db, current version: %d, target: %d\n", current, m.Version)
+				version = m.Version
+				_, err := runMigrations(ctx, conf, e, []*Migration{m}, DirectionUp)
+				return err
+			}
+		}
+
+		return ErrNoNextVersion
+	})
+
+	return version, err
+}
+
+// DownByOne rolls back the current version of db, the highest applied,
+// and returns the version rolled back, as UpByOne does for applying. It
+// fails, without running anything, if that migration has no Down section.
+//
+// If no migration is applied, it returns 0 along with ErrNoCurrentVersion.
+func DownByOne(conf *DBConf, migrationsDir string, db *sql.DB) (int64, error) {
+	return DownByOneContext(context.Background(), conf, migrationsDir, db)
+}
+
+// DownByOneContext is DownByOne with a context; see RunMigrationsOnDbContext.
+func DownByOneContext(ctx context.Context, conf *DBConf, migrationsDir string, db *sql.DB) (version int64, err error) {
+	e := DBExecutor(db)
+	err = withLock(ctx, conf, e, func() error {
+		current, migrations, err := migrationsWithStatus(ctx, conf, migrationsDir, e)
+		if err != nil {
+			return err
+		}
+		if current == 0 {
+			return ErrNoCurrentVersion
+		}
+
+		var m *Migration
+		for _, mm := range migrations {
+			if mm.Version == current {
+				m = mm
+			}
+		}
+		if m == nil {
+			return fmt.Errorf("no migration found for current version %d", current)
+		}
+		if !typeIncluded(conf, m) {
+			return fmt.Errorf("current version %d is a %s migration, which DBConf.MigrationType excludes", current, m.Type())
+		}
+		ok, err := hasDownSection(m)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%s has no Down section, cannot roll back", filepath.Base(m.Source))
+		}
+
+		logger.Printf("goose: rolling back db version %d\n", current)
+		version = current
+		_, err = runMigrations(ctx, conf, e, []*Migration{m}, DirectionDown)
+		return err
+	})
+
+	return version, err
+}
+
+// UpTo applies, in ascending order, every pending migration whose version
+// is at or below target. target need not match a migration file, and
+// Latest applies every pending migration.
+//
+// Unlike RunMigrationsOnDb, a target below the current version is a no-op
+// rather than a rollback. It returns the version of db once done.
+func UpTo(conf *DBConf, migrationsDir string, target int64, db *sql.DB) (int64, error) {
+	return UpToContext(context.Background(), conf, migrationsDir, target, db)
+}
+
+// UpToContext is UpTo with a context; see RunMigrationsOnDbContext.
+func UpToContext(ctx context.Context, conf *DBConf, migrationsDir string, target int64, db *sql.DB) (version int64, err error) {
+	e := DBExecutor(db)
+	err = withLock(ctx, conf, e, func() error {
+		current, migrations, err := migrationsWithStatus(ctx, conf, migrationsDir, e)
+		if err != nil {
+			return err
+		}
+		version = current
+		target = resolveTarget(migrations, target)
+
+		var neededMigrations []*Migration
+		if target >= current {
+			for _, m := range migrations {
+				if m.Version <= target && !m.IsApplied && typeIncluded(conf, m) {
+					neededMigrations = append(neededMigrations, m)
+				}
+			}
+		}
+
+		if len(neededMigrations) == 0 {
+			logger.Printf("goose: no migrations to run. current version: %d, target: %d\n", current, target)
+			return nil
+		}
+
+		logger.Printf("goose: migrating db, current version: %d, target: %d\n", current, target)
+
+		for _, m := range neededMigrations {
+			if _, err := runMigrations(ctx, conf, e, []*Migration{m}, DirectionUp); err != nil {
+				return err
+			}
+			if m.Version > version {
+				version = m.Version
+			}
+		}
+
+		return nil
+	})
+
+	return version, err
+}
+
+// DownTo rolls back, in descending order, every applied migration whose
+// version is above target, so a target of Zero rolls back everything.
+//
+// A target at or above the current version is a no-op. Before anything is
+// rolled back, each migration involved is checked for a Down section.
+// It returns the version of db once done.
+func DownTo(conf *DBConf, migrationsDir string, target int64, db *sql.DB) (int64, error) {
+	return DownToContext(context.Background(), conf, migrationsDir, target, db)
+}
+
+// DownToContext is DownTo with a context; see RunMigrationsOnDbContext.
+func DownToContext(ctx context.Context, conf *DBConf, migrationsDir string, target int64, db *sql.DB) (version int64, err error) {
+	err = withLock(ctx, conf, DBExecutor(db), func() error {
+		version, _, err = downTo(ctx, conf, migrationsDir, target, db)
+		return err
+	})
+
+	return version, err
+}
+
+// Reset rolls back every applied migration, as DownTo with a target of 0,
+// and returns the versions rolled back in the order they were.
+//
+// Rolled back migrations are recorded as such in the version table, so
+// no version shows as applied afterwards.
+func Reset(conf *DBConf, migrationsDir string, db *sql.DB) ([]int64, error) {
+	return ResetContext(context.Background(), conf, migrationsDir, db)
+}
+
+// ResetContext is Reset with a context; see RunMigrationsOnDbContext.
+func ResetContext(ctx context.Context, conf *DBConf, migrationsDir string, db *sql.DB) (rolledBack []int64, err error) {
+	err = withLock(ctx, conf, DBExecutor(db), func() error {
+		_, rolledBack, err = downTo(ctx, conf, migrationsDir, Zero, db)
+		return err
+	})
+
+	return rolledBack, err
+}
+
+// roll back to target as per DownTo, returning the resulting version
+// and the versions rolled back.
+func downTo(ctx context.Context, conf *DBConf, migrationsDir string, target int64, db *sql.DB) (int64, []int64, error) {
+	e := DBExecutor(db)
+	current, migrations, err := migrationsWithStatus(ctx, conf, migrationsDir, e)
+	if err != nil {
+		return 0, nil, err
+	}
+	target = resolveTarget(migrations, target)
+
+	var neededMigrations []*Migration
+	if target < current {
+		for i := len(migrations) - 1; i >= 0; i-- {
+			m := migrations[i]
+			if m.Version > target && m.IsApplied && typeIncluded(conf, m) {
+				neededMigrations = append(neededMigrations, m)
+			}
+		}
+	}
+
 	if len(neededMigrations) == 0 {
-		fmt.Printf("goose: no migrations to run. current version: %d, target: %d\n", current, target)
+		logger.Printf("goose: no migrations to run. current version: %d, target: %d\n", current, target)
+		return current, nil, nil
+	}
+
+	for _, m := range neededMigrations {
+		ok, err := hasDownSection(m)
+		if err != nil {
+			return current, nil, err
+		}
+		if !ok {
+			return current, nil, fmt.Errorf("%s has no Down section, cannot roll back", filepath.Base(m.Source))
+		}
+	}
+
+	logger.Printf("goose: migrating db, current version: %d, target: %d\n", current, target)
+
+	var rolledBack []int64
+	for _, m := range neededMigrations {
+		if _, err := runMigrations(ctx, conf, e, []*Migration{m}, DirectionDown); err != nil {
+			return current, rolledBack, err
+		}
+		rolledBack = append(rolledBack, m.Version)
+	}
+
+	version, err := ensureDBVersion(ctx, conf, e)
+	return version, rolledBack, err
+}
+
+// Baseline records every migration in migrationsDir at or below version
+// as applied, without running any of them, for adopting goose on a
+// database whose schema already has them in place. It returns the
+// versions recorded, in order.
+//
+// It refuses to baseline a database with any migration applied already,
+// and creates the version table if need be, as migrating does.
+func Baseline(conf *DBConf, migrationsDir string, version int64, db *sql.DB) ([]int64, error) {
+	return BaselineContext(context.Background(), conf, migrationsDir, version, db)
+}
+
+// BaselineContext is Baseline with a context.
+func BaselineContext(ctx context.Context, conf *DBConf, migrationsDir string, version int64, db *sql.DB) (baselined []int64, err error) {
+	e := DBExecutor(db)
+	err = withLock(ctx, conf, e, func() error {
+		current, migrations, err := migrationsWithStatus(ctx, conf, migrationsDir, e)
+		if err != nil {
+			return err
+		}
+		if current > 0 {
+			return fmt.Errorf("cannot baseline, migrations are applied already up to version %d", current)
+		}
+
+		var ms []*Migration
+		for _, m := range migrations {
+			if m.Version <= version {
+				ms = append(ms, m)
+			}
+		}
+		if len(ms) == 0 {
+			return fmt.Errorf("no migrations at or below version %d to baseline", version)
+		}
+
+		txn, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		for _, m := range ms {
+			rec := MigrationRecord{Source: filepath.Base(m.Source)}
+			if conf.RecordChecksums {
+				if rec.Checksum, err = migrationChecksum(m.Source); err != nil {
+					txn.Rollback()
+					return err
+				}
+			}
+			if err = insertVersion(ctx, conf, txn, DirectionUp, m.Version, rec); err != nil {
+				txn.Rollback()
+				return fmt.Errorf("recording %s: %w", filepath.Base(m.Source), err)
+			}
+		}
+		if err = txn.Commit(); err != nil {
+			return err
+		}
+
+		for _, m := range ms {
+			baselined = append(baselined, m.Version)
+		}
 		return nil
+	})
+
+	return baselined, err
+}
+
+// Redo rolls back the current version of db and then applies it again,
+// returning the version redone.
+//
+// If applying it again fails, db is left with the migration rolled back.
+func Redo(conf *DBConf, migrationsDir string, db *sql.DB) (int64, error) {
+	return RedoContext(context.Background(), conf, migrationsDir, db)
+}
+
+// RedoContext is Redo with a context; see RunMigrationsOnDbContext.
+func RedoContext(ctx context.Context, conf *DBConf, migrationsDir string, db *sql.DB) (version int64, err error) {
+	e := DBExecutor(db)
+	err = withLock(ctx, conf, e, func() error {
+		current, migrations, err := migrationsWithStatus(ctx, conf, migrationsDir, e)
+		if err != nil {
+			return err
+		}
+		version = current
+
+		var m *Migration
+		for _, mm := range migrations {
+			if mm.Version == current {
+				m = mm
+			}
+		}
+		if m == nil {
+			return fmt.Errorf("no migration found for current version %d", current)
+		}
+		if !typeIncluded(conf, m) {
+			return fmt.Errorf("current version %d is a %s migration, which DBConf.MigrationType excludes", current, m.Type())
+		}
+		if err := checkRedoable(conf, m); err != nil {
+			return err
+		}
+
+		logger.Printf("goose: redoing db version %d\n", current)
+
+		if _, err := runMigrations(ctx, conf, e, []*Migration{m}, DirectionDown); err != nil {
+			return err
+		}
+		if _, err := runMigrations(ctx, conf, e, []*Migration{m}, DirectionUp); err != nil {
+			return fmt.Errorf("redo %d: rolled back but could not reapply: %w", current, err)
+		}
+
+		return nil
+	})
+
+	return version, err
+}
+
+// ApplyFile runs the single migration in migrationsDir named filename,
+// such as "00042_add_index.sql", in direction, and records it in the
+// version table, for targeted fixes where migrating to a version would
+// run more than wanted.
+//
+// Applying a migration fails if it is applied already, and rolling one
+// back if it isn't. Unless conf.AllowOutOfOrder is set, it also fails
+// rather than apply a migration older than the current version of db, or
+// roll back one other than the current version, as either would leave a
+// gap in the versions applied.
+func ApplyFile(conf *DBConf, migrationsDir, filename string, direction Direction, db *sql.DB) error {
+	return ApplyFileContext(context.Background(), conf, migrationsDir, filename, direction, db)
+}
+
+// ApplyFileContext is ApplyFile with a context; see RunMigrationsOnDbContext.
+func ApplyFileContext(ctx context.Context, conf *DBConf, migrationsDir, filename string, direction Direction, db *sql.DB) error {
+	e := DBExecutor(db)
+	return withLock(ctx, conf, e, func() error {
+		current, migrations, err := migrationsWithStatus(ctx, conf, migrationsDir, e)
+		if err != nil {
+			return err
+		}
+
+		var m *Migration
+		for _, mm := range migrations {
+			if filepath.Base(mm.Source) == filepath.Base(filename) {
+				m = mm
+			}
+		}
+		if m == nil {
+			return fmt.Errorf("no migration %s in %s", filepath.Base(filename), migrationsDir)
+		}
+
+		if direction == DirectionUp {
+			if m.IsApplied {
+				return fmt.Errorf("%s is applied already", filepath.Base(m.Source))
+			}
+			if m.Version < current && !conf.AllowOutOfOrder {
+				return fmt.Errorf("%s is older than current version %d, and out of order migrations are not allowed", filepath.Base(m.Source), current)
+			}
+		} else {
+			if !m.IsApplied {
+				return fmt.Errorf("%s: %w", filepath.Base(m.Source), ErrNotApplied)
+			}
+			if m.Version != current && !conf.AllowOutOfOrder {
+				return fmt.Errorf("%s is not the current version %d, and out of order migrations are not allowed", filepath.Base(m.Source), current)
+			}
+			ok, err := hasDownSection(m)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("%s has no Down section, cannot roll back", filepath.Base(m.Source))
+			}
+		}
+
+		logger.Printf("goose: running %s %s, current version: %d\n", filepath.Base(m.Source), direction, current)
+
+		_, err = runMigrations(ctx, conf, e, []*Migration{m}, direction)
+		return err
+	})
+}
+
+type migrationStatus struct {
+	Version     int64             `json:"version"`
+	Source      string            `json:"source"`
+	Applied     bool              `json:"applied"`
+	AppliedAt   *string           `json:"applied_at"` // RFC3339, or null if not applied
+	Description string            `json:"description,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// StatusJSON writes the status of each migration in migrationsDir to w,
+// as a JSON array of objects with the fields "version", "source",
+// "applied" and "applied_at", in version order, along with "description"
+// and "metadata" for migrations with any.
+//
+// Like the status command, it creates the version table if need be.
+func StatusJSON(conf *DBConf, migrationsDir string, db *sql.DB, w io.Writer) error {
+	return StatusJSONContext(context.Background(), conf, migrationsDir, db, w)
+}
+
+// StatusJSONContext is StatusJSON with a context.
+func StatusJSONContext(ctx context.Context, conf *DBConf, migrationsDir string, db *sql.DB, w io.Writer) error {
+	return StatusJSONFilteredContext(ctx, conf, migrationsDir, db, w, StatusFilter{})
+}
+
+// StatusJSONFiltered is StatusJSON for only the migrations filter keeps.
+func StatusJSONFiltered(conf *DBConf, migrationsDir string, db *sql.DB, w io.Writer, filter StatusFilter) error {
+	return StatusJSONFilteredContext(context.Background(), conf, migrationsDir, db, w, filter)
+}
+
+// StatusJSONFilteredContext is StatusJSONFiltered with a context.
+func StatusJSONFilteredContext(ctx context.Context, conf *DBConf, migrationsDir string, db *sql.DB, w io.Writer, filter StatusFilter) error {
+	migrations, err := StatusContext(ctx, conf, migrationsDir, db, filter)
+	if err != nil {
+		return err
+	}
+	if err = readMigrationsMetadata(migrations); err != nil {
+		return err
 	}
 
-	fmt.Printf("goose: migrating db, current version: %d, target: %d\n", current, target)
+	statuses := make([]migrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := migrationStatus{
+			Version:     m.Version,
+			Source:      filepath.Base(m.Source),
+			Applied:     m.IsApplied,
+			Description: m.Description,
+			Metadata:    m.Metadata,
+		}
+		if m.IsApplied {
+			appliedAt := m.TStamp.Format(time.RFC3339)
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
 
-	ms := migrationSorter(neededMigrations)
-	if direction == DirectionUp {
-		sort.Sort(ms)
-	} else {
-		sort.Sort(sort.Reverse(ms))
+	return json.NewEncoder(w).Encode(statuses)
+}
+
+// StatusFilter narrows the status of a database with a long history down
+// to the migrations applied recently, by the time the version table
+// records for them. Its zero value keeps every migration, pending ones
+// included; otherwise only applied migrations are kept, and those must
+// pass both of Since and Last that are set.
+type StatusFilter struct {
+	Since time.Time // keep migrations applied after Since, unless zero
+	Last  int       // keep the Last applied migrations, by version, unless 0
+}
+
+// keep the migrations, in version order, that f lets through
+func (f StatusFilter) filter(migrations []*Migration) []*Migration {
+	if f.Since.IsZero() && f.Last == 0 {
+		return migrations
+	}
+
+	var applied []*Migration
+	for _, m := range migrations {
+		if m.IsApplied {
+			applied = append(applied, m)
+		}
+	}
+	if f.Last > 0 && len(applied) > f.Last {
+		applied = applied[len(applied)-f.Last:]
+	}
+
+	var kept []*Migration
+	for _, m := range applied {
+		if f.Since.IsZero() || m.TStamp.After(f.Since) {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
+
+// Status returns the migrations in migrationsDir that filter keeps, in
+// version order, with IsApplied and TStamp set from the version table of
+// db, as the status command lists them. Like StatusJSON, it creates the
+// version table if need be.
+func Status(conf *DBConf, migrationsDir string, db *sql.DB, filter StatusFilter) ([]*Migration, error) {
+	return StatusContext(context.Background(), conf, migrationsDir, db, filter)
+}
+
+// StatusContext is Status with a context.
+func StatusContext(ctx context.Context, conf *DBConf, migrationsDir string, db *sql.DB, filter StatusFilter) ([]*Migration, error) {
+	_, migrations, err := migrationsWithStatus(ctx, conf, migrationsDir, DBExecutor(db))
+	if err != nil {
+		return nil, err
+	}
+	return filter.filter(migrations), nil
+}
+
+// fail unless rolling m back undoes something, as otherwise redoing it
+// would reapply it on top of itself: its Down section must be there, and
+// for SQL migrations have statements.
+func checkRedoable(conf *DBConf, m *Migration) error {
+	ok, err := hasDownSection(m)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s has no Down section, cannot redo", filepath.Base(m.Source))
+	}
+	if m.Type() != "sql" {
+		return nil
+	}
+
+	stmts, _, err := readSQLStatements(conf, m.script(DirectionDown), DirectionDown)
+	if err != nil {
+		return err
+	}
+	if len(stmts) == 0 {
+		return fmt.Errorf("%s has an empty Down section, cannot redo", filepath.Base(m.script(DirectionDown)))
+	}
+	return nil
+}
+
+// report whether m defines how to roll it back, failing with
+// ErrIrreversible if it declares that it cannot be
+func hasDownSection(m *Migration) (bool, error) {
+	if isSplitSQL(m.Source) {
+		return m.DownSource != "", nil
+	}
+
+	b, err := fs.ReadFile(baseFS, m.Source)
+	if err != nil {
+		return false, err
+	}
+
+	switch m.Type() {
+	case "go":
+		declared, isNil := goMigrationFuncDecl(b, goMigrationFunc(DirectionDown, m.Version))
+		if isNil {
+			return false, ErrIrreversible{Version: m.Version}
+		}
+		return declared, nil
+	case "sql":
+		for _, line := range strings.Split(string(b), "\n") {
+			if strings.HasPrefix(line, sqlCmdPrefix) && strings.TrimSpace(line[len(sqlCmdPrefix):]) == "Down" {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// ensure the version table exists, then collect the migrations in
+// migrationsDir, sorted by version and marked with their applied state.
+func migrationsWithStatus(ctx context.Context, conf *DBConf, migrationsDir string, db Executor) (int64, []*Migration, error) {
+	records, err := migrationRecords(ctx, conf, db)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	migrations, err := collectMigrations(migrationsDir)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	setMigrationsStatus(migrations, records)
+	sort.Sort(migrationSorter(migrations))
+
+	return currentVersion(records), migrations, nil
+}
+
+// like migrationsWithStatus, but only reads from db: if the version table
+// is missing, every migration is pending and ErrTableDoesNotExist is
+// returned along with them. As there, only the names of the migrations
+// are read, not their metadata.
+func readMigrationsStatus(ctx context.Context, conf *DBConf, migrationsDir string, db Executor) (int64, []*Migration, error) {
+	migrations, err := collectMigrations(migrationsDir)
+	if err != nil {
+		return 0, nil, err
+	}
+	sort.Sort(migrationSorter(migrations))
+
+	records, err := readMigrationRecords(ctx, conf, db)
+	if err != nil {
+		if err == ErrTableDoesNotExist {
+			return 0, migrations, err
+		}
+		return 0, nil, fmt.Errorf("getting db version: %s", err)
+	}
+
+	setMigrationsStatus(migrations, records)
+
+	return currentVersion(records), migrations, nil
+}
+
+// Pending returns the migrations in migrationsDir that have not been
+// applied to db, in the order they would be applied. A missing version
+// table is not an error: every migration is then pending. The Description
+// and Metadata of the pending migrations are read from their files, as
+// CollectMigrations does, but no other migration is opened.
+func Pending(conf *DBConf, migrationsDir string, db *sql.DB) ([]*Migration, error) {
+	return PendingContext(context.Background(), conf, migrationsDir, db)
+}
+
+// PendingContext is Pending with a context.
+func PendingContext(ctx context.Context, conf *DBConf, migrationsDir string, db *sql.DB) ([]*Migration, error) {
+	_, migrations, err := readMigrationsStatus(ctx, conf, migrationsDir, DBExecutor(db))
+	if err != nil && err != ErrTableDoesNotExist {
+		return nil, err
+	}
+
+	var pending []*Migration
+	for _, m := range migrations {
+		if !m.IsApplied {
+			pending = append(pending, m)
+		}
+	}
+	if err = readMigrationsMetadata(pending); err != nil {
+		return nil, err
+	}
+
+	return pending, nil
+}
+
+// Diff compares the migrations in migrationsDir with the version table of
+// db, as a check that the two haven't drifted apart. orphans are the
+// versions db has applied that no migration in migrationsDir matches, as
+// after a migration file was deleted or with the wrong directory; pending
+// are those of the migrations not applied yet. Both are in version order.
+//
+// Like Pending, it only reads from db: a missing version table leaves
+// every migration pending and none orphaned. Versions in
+// conf.AllowMissing are never orphans.
+func Diff(conf *DBConf, migrationsDir string, db *sql.DB) (orphans, pending []int64, err error) {
+	return DiffContext(context.Background(), conf, migrationsDir, db)
+}
+
+// DiffContext is Diff with a context.
+func DiffContext(ctx context.Context, conf *DBConf, migrationsDir string, db *sql.DB) (orphans, pending []int64, err error) {
+	migrations, err := collectMigrations(migrationsDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Sort(migrationSorter(migrations))
+
+	records, err := readMigrationRecords(ctx, conf, DBExecutor(db))
+	if err != nil && err != ErrTableDoesNotExist {
+		return nil, nil, fmt.Errorf("getting db version: %s", err)
+	}
+
+	onDisk := make(map[int64]bool, len(migrations))
+	for _, m := range migrations {
+		onDisk[m.Version] = true
+		if !records[m.Version].IsApplied {
+			pending = append(pending, m.Version)
+		}
+	}
+
+	allowed := make(map[int64]bool, len(conf.AllowMissing))
+	for _, v := range conf.AllowMissing {
+		allowed[v] = true
+	}
+
+	// version 0 is the row the version table starts with
+	var ignored []int64
+	for v, r := range records {
+		if v != 0 && r.IsApplied && !onDisk[v] {
+			if allowed[v] {
+				ignored = append(ignored, v)
+			} else {
+				orphans = append(orphans, v)
+			}
+		}
+	}
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i] < orphans[j] })
+
+	if len(ignored) > 0 {
+		sort.Slice(ignored, func(i, j int) bool { return ignored[i] < ignored[j] })
+		logger.Printf("goose: ignoring applied versions with no migration, as DBConf.AllowMissing accepts them: %v\n", ignored)
+	}
+
+	return orphans, pending, nil
+}
+
+// MigrationStatus looks up the migration filename in migrationsDir,
+// matched by base name as for ApplyFile, and returns its version, whether
+// db has it applied, and if so when, as the version table recorded it,
+// such as for release notes. Like Diff, it only reads from db: a missing
+// version table leaves it unapplied.
+func MigrationStatus(conf *DBConf, migrationsDir, filename string, db *sql.DB) (version int64, applied bool, appliedAt *time.Time, err error) {
+	return MigrationStatusContext(context.Background(), conf, migrationsDir, filename, db)
+}
+
+// MigrationStatusContext is MigrationStatus with a context.
+func MigrationStatusContext(ctx context.Context, conf *DBConf, migrationsDir, filename string, db *sql.DB) (version int64, applied bool, appliedAt *time.Time, err error) {
+	migrations, err := collectMigrations(migrationsDir)
+	if err != nil {
+		return 0, false, nil, err
+	}
+
+	var m *Migration
+	for _, mm := range migrations {
+		if filepath.Base(mm.Source) == filepath.Base(filename) {
+			m = mm
+		}
+	}
+	if m == nil {
+		return 0, false, nil, fmt.Errorf("no migration %s in %s", filepath.Base(filename), migrationsDir)
+	}
+
+	records, err := readMigrationRecords(ctx, conf, DBExecutor(db))
+	if err != nil && err != ErrTableDoesNotExist {
+		return 0, false, nil, fmt.Errorf("getting db version: %w", err)
+	}
+
+	r := records[m.Version]
+	if !r.IsApplied {
+		return m.Version, false, nil, nil
+	}
+	return m.Version, true, &r.TStamp, nil
+}
+
+// run each of the given migrations in order, stopping at the first failure,
+// and return the versions that went through, in the order they ran. A
+// version counts once it is committed, so those in a SingleTransaction
+// batch that rolled back don't.
+func runMigrations(ctx context.Context, conf *DBConf, db Executor, ms []*Migration, direction Direction) (done []int64, err error) {
+	// with conf.SingleTransaction, the SQL migrations run in as few
+	// transactions as they can, which Go migrations break up
+	var batch *sqlBatch
+	var batched []int64
+	defer func() {
+		if batch != nil {
+			batch.rollback()
+		}
+	}()
+
+	// rollbacks are checked against the version table as it is now, in
+	// case it changed after the migrations to roll back were chosen
+	var records map[int64]VersionRecord
+	if direction == DirectionDown {
+		if records, err = readMigrationRecords(ctx, conf, db); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = checkWritable(ctx, conf.Driver.Dialect, db); err != nil {
+		return nil, err
 	}
 
-	for _, m := range ms {
-		switch filepath.Ext(m.Source) {
-		case ".go":
-			err = runGoMigration(conf, m.Source, m.Version, direction)
-		case ".sql":
-			err = runSQLMigration(conf, db, m.Source, m.Version, direction)
+	ins := prepareVersionInsert(ctx, conf, db)
+	defer ins.close()
+
+	for _, m := range ms {
+		if direction == DirectionDown {
+			if err = checkApplied(records, m); err == nil {
+				err = confirmDown(conf, m)
+			}
+		}
+
+		if err == nil {
+			start := migrationStarting(conf, m, direction)
+			switch m.Type() {
+			case "go":
+				if err = commitSQLBatch(&batch); err == nil {
+					err = runGoMigration(ctx, conf, m.Source, m.Version, direction)
+				}
+			case "sql":
+				if conf.SingleTransaction {
+					err = runSQLMigrationBatched(ctx, conf, db, ins, &batch, m.script(direction), m.Version, direction)
+				} else {
+					err = runSQLMigration(ctx, conf, db, ins, m.script(direction), m.Version, direction)
+				}
+			}
+			migrationDone(conf, m, direction, start, err)
+		}
+
+		if err != nil {
+			var me *MigrationError
+			if !errors.As(err, &me) {
+				err = &MigrationError{Version: m.Version, Source: m.script(direction), Err: err}
+			}
+			return done, fmt.Errorf("FAIL %w, quitting migration", readOnlyError(conf.Driver.Dialect, err))
+		}
+
+		// m waits on the batch it ran in, if it is still open
+		batched = append(batched, m.Version)
+		if batch == nil {
+			done = append(done, batched...)
+			batched = nil
+		}
+
+		logger.Println("OK   ", filepath.Base(m.script(direction)))
+	}
+
+	if err = commitSQLBatch(&batch); err != nil {
+		return done, fmt.Errorf("FAIL %w, quitting migration", readOnlyError(conf.Driver.Dialect, err))
+	}
+
+	return append(done, batched...), nil
+}
+
+// report whether conf.MigrationType lets m run
+func typeIncluded(conf *DBConf, m *Migration) bool {
+	return conf.MigrationType == "" || m.Type() == conf.MigrationType
+}
+
+// fail with ErrNotApplied unless records have m applied, so that a
+// migration is never rolled back that isn't in place to roll back
+func checkApplied(records map[int64]VersionRecord, m *Migration) error {
+	if !records[m.Version].IsApplied {
+		return ErrNotApplied
+	}
+	return nil
+}
+
+// ask conf.ConfirmDown, if set, whether m may be rolled back
+func confirmDown(conf *DBConf, m *Migration) error {
+	if conf.ConfirmDown == nil {
+		return nil
+	}
+
+	ok, err := conf.ConfirmDown(m.Version, m.script(DirectionDown))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrDownNotConfirmed
+	}
+	return nil
+}
+
+// MetricsObserver is told how each migration of a run went, for exporting
+// metrics such as counts, durations and failures to a monitoring system,
+// without goose depending on any metrics library. See DBConf.Metrics.
+type MetricsObserver interface {
+	// ObserveMigration is called once the migration at version has run in
+	// direction, "up" or "down", taking d, with the error it failed with,
+	// if any.
+	ObserveMigration(version int64, direction string, d time.Duration, err error)
+}
+
+// call conf.OnMigrationStart, if set, as m begins to go in direction,
+// returning when it did for migrationDone
+func migrationStarting(conf *DBConf, m *Migration, direction Direction) time.Time {
+	if conf.OnMigrationStart != nil {
+		conf.OnMigrationStart(m.Version, m.script(direction), direction)
+	}
+	return time.Now()
+}
+
+// call conf.OnMigrationDone and conf.Metrics, if set, as m has gone in
+// direction since start, or failed to with err
+func migrationDone(conf *DBConf, m *Migration, direction Direction, start time.Time, err error) {
+	if conf.Metrics != nil {
+		conf.Metrics.ObserveMigration(m.Version, direction.String(), time.Since(start), err)
+	}
+	if conf.OnMigrationDone != nil {
+		conf.OnMigrationDone(m.Version, m.script(direction), direction, err)
+	}
+}
+
+// call conf.OnStatement, if set, as the ith of the statements of the
+// migration at version v is about to run
+func statementStarting(conf *DBConf, v int64, i int, stmts []string) {
+	if conf.OnStatement != nil {
+		conf.OnStatement(v, i, len(stmts), stmts[i])
+	}
+}
+
+// collect all the valid looking migration scripts in the
+// migrations folder, and key them by version
+//
+// A migration may be split across an .up.sql and a .down.sql file with
+// the same version and name, such as 00005_foo.up.sql and
+// 00005_foo.down.sql. The .down.sql file is optional.
+//
+// dirpath may list several directories separated by os.PathListSeparator,
+// as PATH does, e.g. "shared/migrations:service/migrations"; their
+// migrations are merged into one sequence, and a version found in more
+// than one of them is an error. This holds for every function taking a
+// migrations directory.
+func CollectMigrations(dirpath string) ([]*Migration, error) {
+	m, err := collectMigrations(dirpath)
+	if err != nil {
+		return nil, err
+	}
+	if err = readMigrationsMetadata(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// fill in the Description and Metadata of each SQL migration of m
+func readMigrationsMetadata(m []*Migration) (err error) {
+	for _, g := range m {
+		if g.Type() != "sql" {
+			continue
 		}
-
-		if err != nil {
-			return errors.New(fmt.Sprintf("FAIL %v, quitting migration", err))
+		if g.Metadata, err = readSQLMetadata(g.Source); err != nil {
+			return err
 		}
+		g.Description = g.Metadata["description"]
+	}
+	return nil
+}
 
-		fmt.Println("OK   ", filepath.Base(m.Source))
+// WalkMigrations calls fn with the version and path of each migration in
+// dir, in version order. Every migration is collected and sorted before
+// the first call, as that order needs, but only the names of the files
+// are read, and none of their contents, so fn can open just those it
+// needs. If fn returns an error, the walk stops there and WalkMigrations
+// returns it.
+func WalkMigrations(dir string, fn func(version int64, source string) error) error {
+	m, err := collectMigrations(dir)
+	if err != nil {
+		return err
 	}
+	sort.Sort(migrationSorter(m))
 
+	for _, g := range m {
+		if err := fn(g.Version, g.Source); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// collect all the valid looking migration scripts in the
-// migrations folder, and key them by version
-func CollectMigrations(dirpath string) (m []*Migration, err error) {
+// migrationDirs splits dirpath into the directories it lists
+func migrationDirs(dirpath string) []string {
+	if dirs := filepath.SplitList(dirpath); len(dirs) > 0 {
+		return dirs
+	}
+	return []string{dirpath}
+}
+
+// CollectMigrations, but only from the names of the files, leaving
+// Description and Metadata unset, for when they aren't needed
+func collectMigrations(dirpath string) (m []*Migration, err error) {
+	// .down.sql files are paired up with their .up.sql files once all are found
+	downs := map[int64]string{}
+	byVersion := map[int64]*Migration{}
+
 	// extract the numeric component of each migration,
 	// filter out any uninteresting files,
 	// and ensure we only have one file per migration version.
-	filepath.Walk(dirpath, func(name string, info os.FileInfo, err error) error {
+	for _, dir := range migrationDirs(dirpath) {
+		fs.WalkDir(baseFS, dir, func(name string, d fs.DirEntry, walkerr error) error {
+
+			if v, e := NumericComponent(name); e == nil {
 
-		if v, e := NumericComponent(name); e == nil {
+				if strings.HasSuffix(name, downFileSuffix) {
+					if other, ok := downs[v]; ok {
+						err = fmt.Errorf("more than one file specifies the migration for version %d (%s and %s)",
+							v, other, name)
+						return err
+					}
+					downs[v] = name
+					return nil
+				}
 
-			for _, g := range m {
-				if v == g.Version {
-					log.Fatalf("more than one file specifies the migration for version %d (%s and %s)",
-						v, g.Source, filepath.Join(dirpath, name))
+				if g, ok := byVersion[v]; ok {
+					err = fmt.Errorf("more than one file specifies the migration for version %d (%s and %s)",
+						v, g.Source, name)
+					return err
 				}
+
+				g := &Migration{Version: v, Source: name}
+				byVersion[v] = g
+				m = append(m, g)
 			}
 
-			m = append(m, &Migration{Version: v, Source: name})
+			return nil
+		})
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		return nil
-	})
+	for _, g := range m {
+		down, ok := downs[g.Version]
+		if !ok {
+			continue
+		}
+		if strings.TrimSuffix(g.Source, upFileSuffix) != strings.TrimSuffix(down, downFileSuffix) {
+			return nil, fmt.Errorf("more than one file specifies the migration for version %d (%s and %s)",
+				g.Version, g.Source, down)
+		}
+		g.DownSource = down
+		delete(downs, g.Version)
+	}
+	for _, down := range downs {
+		return nil, fmt.Errorf("%s has no matching %s file", down, upFileSuffix)
+	}
 
 	return m, nil
 }
 
+// GetMigrations returns the migrations in dir with versions above
+// current and up to target, sorted by version and linked to each other
+// through Previous and Next. Unlike CollectMigrations, which skips them,
+// it fails on any migration file whose version cannot be parsed, naming
+// every one.
+func GetMigrations(dir string, current, target int64) ([]*Migration, error) {
+	var bad []string
+	for _, d := range migrationDirs(dir) {
+		err := fs.WalkDir(baseFS, d, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || migrationExt(name) == "" {
+				return nil
+			}
+			if _, err := NumericComponent(name); err != nil {
+				bad = append(bad, fmt.Sprintf("%s (%v)", filepath.Base(name), err))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(bad) > 0 {
+		return nil, fmt.Errorf("invalid migration filenames: %s", strings.Join(bad, ", "))
+	}
+
+	all, err := CollectMigrations(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ms []*Migration
+	for _, m := range all {
+		if m.Version > current && m.Version <= target {
+			ms = append(ms, m)
+		}
+	}
+	sort.Sort(migrationSorter(ms))
+
+	for i, m := range ms {
+		m.Previous, m.Next = -1, -1
+		if i > 0 {
+			m.Previous = ms[i-1].Version
+		}
+		if i < len(ms)-1 {
+			m.Next = ms[i+1].Version
+		}
+	}
+
+	return ms, nil
+}
+
 // look for migration scripts with names in the form:
 //  XXX_descriptivename.ext
 // where XXX specifies the version number
-// and ext specifies the type of migration
+// and ext specifies the type of migration, one of those set with
+// SetMigrationExtensions
 func NumericComponent(name string) (int64, error) {
 	base := filepath.Base(name)
 
-	if ext := filepath.Ext(base); ext != ".go" && ext != ".sql" {
+	if migrationExt(base) == "" {
 		return 0, errors.New("not a recognized migration file type")
 	}
 
@@ -187,120 +1565,208 @@ func NumericComponent(name string) (int64, error) {
 	return n, e
 }
 
-func getMigrationsStatus(conf *DBConf, db *sql.DB, migrations []*Migration) error {
-	rows, err := conf.Driver.Dialect.dbVersionQuery(db)
-	if err != nil {
-		if err == ErrTableDoesNotExist {
-			for _, m := range migrations {
-				m.IsApplied = false
-			}
-			return nil
-		}
-		return fmt.Errorf("getting db version: %s", err)
+// mark each migration with its state in records, as read by
+// readMigrationRecords: migrations the version table doesn't know about
+// are not applied.
+func setMigrationsStatus(migrations []*Migration, records map[int64]VersionRecord) {
+	for _, m := range migrations {
+		r := records[m.Version]
+		m.IsApplied = r.IsApplied
+		m.TStamp = r.TStamp
 	}
-	defer rows.Close()
+}
 
-	mm := map[int64]*Migration{}
-	for _, m := range migrations {
-		mm[m.Version] = m
-		// default to false so if the DB doesn't know about the migration...
-		m.IsApplied = false
+// retrieve the current version for this DB, the highest version applied.
+// Create and initialize the DB version table if it doesn't exist.
+func EnsureDBVersion(conf *DBConf, db *sql.DB) (int64, error) {
+	return ensureDBVersion(context.Background(), conf, DBExecutor(db))
+}
+
+func ensureDBVersion(ctx context.Context, conf *DBConf, db Executor) (int64, error) {
+	records, err := migrationRecords(ctx, conf, db)
+	if err != nil {
+		return 0, err
 	}
 
-	for rows.Next() {
-		var row Migration
-		if err = rows.Scan(&row.Version, &row.IsApplied, &row.TStamp); err != nil {
-			log.Fatal("error scanning rows:", err)
-		}
+	return currentVersion(records), nil
+}
 
-		m, ok := mm[row.Version]
-		if !ok {
-			continue
+// like readMigrationRecords, but if the version table doesn't exist it is
+// created, unless conf.NoCreateVersionTable is set, and read once more.
+func migrationRecords(ctx context.Context, conf *DBConf, db Executor) (map[int64]VersionRecord, error) {
+	records, err := readMigrationRecords(ctx, conf, db)
+	if err != ErrTableDoesNotExist {
+		if err != nil {
+			return nil, fmt.Errorf("getting db version: %w", err)
 		}
-		if !row.TStamp.After(m.TStamp) {
-			// If the migration went up, then down, it'll have multiple rows.
-			// But we only want the newest, so skip this row if it's older.
-			continue
+		return records, nil
+	}
+
+	if conf.NoCreateVersionTable {
+		return nil, errVersionTableRequired(conf)
+	}
+	err = withRetry(ctx, conf.Retry, func() error {
+		return createVersionTable(ctx, conf, db)
+	})
+	if err != nil {
+		// another migrator may have created it in the meantime, or a
+		// failed attempt may have created it after all
+		if records, rerr := readMigrationRecords(ctx, conf, db); rerr == nil {
+			return records, nil
 		}
-		m.IsApplied = row.IsApplied
-		m.TStamp = row.TStamp
+		return nil, err
 	}
 
-	return nil
+	records, err = readMigrationRecords(ctx, conf, db)
+	if err != nil {
+		return nil, fmt.Errorf("getting db version: %w", err)
+	}
+	return records, nil
 }
 
-// retrieve the current version for this DB.
-// Create and initialize the DB version table if it doesn't exist.
-func EnsureDBVersion(conf *DBConf, db *sql.DB) (int64, error) {
-	rows, err := conf.Driver.Dialect.dbVersionQuery(db)
+// the error for a missing version table, with DBConf.NoCreateVersionTable
+func errVersionTableRequired(conf *DBConf) error {
+	return fmt.Errorf("%w: %s must be created before migrating, as DBConf.NoCreateVersionTable is set, with:\n%s",
+		ErrTableDoesNotExist, TableName(), VersionTableSQL(conf))
+}
+
+// read the current state of each version in the version table, which is
+// its newest row: a version applied then rolled back is not applied.
+// Rows are newest first in the dialect's VersionOrderColumn, so the first
+// row seen for a version wins; tstamps are not compared, as a quick up
+// and down can share one. If the table is missing, ErrTableDoesNotExist
+// is returned as is. Transient errors are retried as per conf.Retry.
+func readMigrationRecords(ctx context.Context, conf *DBConf, db Executor) (records map[int64]VersionRecord, err error) {
+	err = withRetry(ctx, conf.Retry, func() error {
+		records, err = queryMigrationRecords(ctx, conf, db)
+		return err
+	})
+	return records, err
+}
+
+// readMigrationRecords on q, without retrying
+func queryMigrationRecords(ctx context.Context, conf *DBConf, q queryer) (map[int64]VersionRecord, error) {
+	rows, err := dbVersionQuery(ctx, conf.Driver.Dialect, q)
 	if err != nil {
-		if err == ErrTableDoesNotExist {
-			return 0, createVersionTable(conf, db)
-		}
-		return 0, fmt.Errorf("getting db version: %#v", err)
+		return nil, err
 	}
 	defer rows.Close()
 
-	// The most recent record for each migration specifies
-	// whether it has been applied or rolled back.
-	// The first version we find that has been applied is the current version.
-
-	toSkip := make([]int64, 0)
-
+	records := map[int64]VersionRecord{}
 	for rows.Next() {
-		var row Migration
-		if err = rows.Scan(&row.Version, &row.IsApplied, &row.TStamp); err != nil {
-			log.Fatal("error scanning rows:", err)
+		var r VersionRecord
+		if err = rows.Scan(&r.Version, &r.IsApplied, &r.TStamp); err != nil {
+			return nil, fmt.Errorf("error scanning rows: %s", err)
 		}
 
-		// have we already marked this version to be skipped?
-		skip := false
-		for _, v := range toSkip {
-			if v == row.Version {
-				skip = true
-				break
-			}
+		if _, ok := records[r.Version]; !ok {
+			records[r.Version] = r
 		}
+	}
 
-		if skip {
-			continue
-		}
+	return records, rows.Err()
+}
 
-		// if version has been applied we're done
-		if row.IsApplied {
-			return row.Version, nil
+// the highest version applied in records, or 0 if there is none.
+func currentVersion(records map[int64]VersionRecord) int64 {
+	var version int64
+	for v, r := range records {
+		if r.IsApplied && v > version {
+			version = v
 		}
-
-		// latest version of migration has not been applied.
-		toSkip = append(toSkip, row.Version)
 	}
+	return version
+}
+
+// CreateVersionTable creates the version table on db and inserts its
+// initial version 0 row, if the table doesn't exist yet, so that it can be
+// provisioned ahead of migrating, as by a more privileged account than the
+// one that migrates with DBConf.NoCreateVersionTable set. It creates the
+// table whatever conf.NoCreateVersionTable says. If the table exists, or
+// is created concurrently by someone else, it does nothing.
+func CreateVersionTable(conf *DBConf, db *sql.DB) error {
+	return CreateVersionTableContext(context.Background(), conf, db)
+}
 
-	panic("failure in EnsureDBVersion()")
+// CreateVersionTableContext is CreateVersionTable with a context.
+func CreateVersionTableContext(ctx context.Context, conf *DBConf, db *sql.DB) error {
+	c := *conf
+	c.NoCreateVersionTable = false
+	_, err := migrationRecords(ctx, &c, DBExecutor(db))
+	return err
 }
 
-// Create the goose_db_version table
-// and insert the initial 0 value into it
-func createVersionTable(conf *DBConf, db *sql.DB) error {
-	txn, err := db.Begin()
+// VersionTableSQL returns the statement that creates the version table
+// for conf's dialect, named as per SetSchema and SetTableName, for
+// provisioning it ahead of time, or the one set with SetVersionTableSQL.
+// An empty version table is at version 0.
+func VersionTableSQL(conf *DBConf) string {
+	return createVersionTableSQL(conf.Driver.Dialect)
+}
+
+// VersionInsertSQL returns the statement goose records a version with,
+// taking the version and whether it is applied as its two parameters.
+// goose runs it with 0 and true after creating the version table.
+func VersionInsertSQL(conf *DBConf) string {
+	return conf.Driver.Dialect.insertVersionSql()
+}
+
+// Create the version table
+// and insert the initial 0 value into it,
+// in a transaction where the dialect allows.
+// Losing a race to create it is no error.
+func createVersionTable(ctx context.Context, conf *DBConf, db Executor) error {
+	d := conf.Driver.Dialect
+
+	if !ddlInTransaction(d) {
+		return ignoreTableExists(initVersionTable(ctx, d, db))
+	}
+
+	txn, err := db.Begin(ctx)
 	if err != nil {
 		return err
 	}
 
-	d := conf.Driver.Dialect
-
-	if _, err := txn.Exec(d.createVersionTableSql()); err != nil {
+	if err := initVersionTable(ctx, d, txn); err != nil {
 		txn.Rollback()
+		return ignoreTableExists(err)
+	}
+
+	return txn.Commit()
+}
+
+// errVersionTableExists is returned by initVersionTable when another
+// migrator created the version table first. Its initial row is then left
+// to that migrator to insert.
+var errVersionTableExists = errors.New("version table already exists")
+
+// err, or nil if it is errVersionTableExists
+func ignoreTableExists(err error) error {
+	if err == errVersionTableExists {
+		return nil
+	}
+	return err
+}
+
+// create the version table on e and insert the initial 0 value into it
+func initVersionTable(ctx context.Context, d SqlDialect, e execer) error {
+	if err := checkVersionTableSQL(versionTableSQL); err != nil {
 		return fmt.Errorf("creating migration table: %s", err)
 	}
+	if _, err := e.ExecContext(ctx, createVersionTableSQL(d)); err != nil {
+		if d.isTableAlreadyExistsError(err) {
+			return errVersionTableExists
+		}
+		return fmt.Errorf("creating migration table: %w", readOnlyError(d, err))
+	}
 
 	version := 0
 	applied := true
-	if _, err := txn.Exec(d.insertVersionSql(), version, applied); err != nil {
-		txn.Rollback()
-		return fmt.Errorf("inserting first migration: %s", err)
+	if _, err := e.ExecContext(ctx, d.insertVersionSql(), version, applied); err != nil {
+		return fmt.Errorf("inserting first migration: %w", readOnlyError(d, err))
 	}
 
-	return txn.Commit()
+	return nil
 }
 
 // wrapper for EnsureDBVersion for callers that don't already have
@@ -320,25 +1786,86 @@ func GetDBVersion(conf *DBConf) (version int64, err error) {
 	return version, nil
 }
 
+// GetDBVersionOnDb returns the highest version currently applied to db,
+// or 0 if nothing has been applied. A version counts as applied if its
+// most recent record in the version table says so, so rolled back
+// versions are ignored.
+//
+// Unlike GetDBVersion, it never creates the version table: if the table
+// is missing it returns ErrTableDoesNotExist.
+func GetDBVersionOnDb(conf *DBConf, db *sql.DB) (int64, error) {
+	return getDBVersionOnDb(context.Background(), conf, DBExecutor(db))
+}
+
+func getDBVersionOnDb(ctx context.Context, conf *DBConf, db Executor) (int64, error) {
+	records, err := readMigrationRecords(ctx, conf, db)
+	if err != nil {
+		return 0, err
+	}
+
+	return currentVersion(records), nil
+}
+
+// VersionRecord is one row of the version table: a migration going up,
+// if IsApplied, or down, at TStamp.
+type VersionRecord struct {
+	Version   int64
+	IsApplied bool
+	TStamp    time.Time // as recorded by the database, in its time zone
+}
+
+// VersionHistory returns every row of the version table, oldest first,
+// including the initial version 0, so that the ups and downs of each
+// migration can be followed over time.
+func VersionHistory(conf *DBConf, db *sql.DB) ([]VersionRecord, error) {
+	return VersionHistoryContext(context.Background(), conf, db)
+}
+
+// VersionHistoryContext is VersionHistory with a context.
+func VersionHistoryContext(ctx context.Context, conf *DBConf, db *sql.DB) ([]VersionRecord, error) {
+	rows, err := dbVersionQuery(ctx, conf.Driver.Dialect, DBExecutor(db))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []VersionRecord
+	for rows.Next() {
+		var r VersionRecord
+		if err = rows.Scan(&r.Version, &r.IsApplied, &r.TStamp); err != nil {
+			return nil, fmt.Errorf("error scanning rows: %s", err)
+		}
+		history = append(history, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// rows are newest first
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+
+	return history, nil
+}
+
 func GetPreviousDBVersion(dirpath string, version int64) (previous int64, err error) {
 	previous = -1
 	sawGivenVersion := false
 
-	filepath.Walk(dirpath, func(name string, info os.FileInfo, walkerr error) error {
+	migrations, err := collectMigrations(dirpath)
+	if err != nil {
+		return previous, err
+	}
 
-		if !info.IsDir() {
-			if v, e := NumericComponent(name); e == nil {
-				if v > previous && v < version {
-					previous = v
-				}
-				if v == version {
-					sawGivenVersion = true
-				}
-			}
+	for _, m := range migrations {
+		if m.Version > previous && m.Version < version {
+			previous = m.Version
 		}
-
-		return nil
-	})
+		if m.Version == version {
+			sawGivenVersion = true
+		}
+	}
 
 	if previous == -1 {
 		if sawGivenVersion {
@@ -359,21 +1886,16 @@ func GetPreviousDBVersion(dirpath string, version int64) (previous int64, err er
 func GetMostRecentDBVersion(dirpath string) (version int64, err error) {
 	version = -1
 
-	filepath.Walk(dirpath, func(name string, info os.FileInfo, walkerr error) error {
-		if walkerr != nil {
-			return walkerr
-		}
+	migrations, err := collectMigrations(dirpath)
+	if err != nil {
+		return version, err
+	}
 
-		if !info.IsDir() {
-			if v, e := NumericComponent(name); e == nil {
-				if v > version {
-					version = v
-				}
-			}
+	for _, m := range migrations {
+		if m.Version > version {
+			version = m.Version
 		}
-
-		return nil
-	})
+	}
 
 	if version == -1 {
 		err = errors.New("no valid version found")
@@ -382,37 +1904,281 @@ func GetMostRecentDBVersion(dirpath string) (version int64, err error) {
 	return
 }
 
+// Numbering selects how CreateMigrationNumbered picks the version of
+// a new migration.
+type Numbering int
+
+const (
+	// TimestampNumbering versions a migration with the UTC time it was
+	// created, as YYYYMMDDhhmmss, so that migrations written in parallel
+	// do not collide.
+	TimestampNumbering Numbering = iota
+	// SequentialNumbering versions a migration with the next integer
+	// after the highest version in the migrations folder.
+	SequentialNumbering
+)
+
+// timestampFormat is the layout of a TimestampNumbering version
+const timestampFormat = "20060102150405"
+
+// CreateMigration creates a new migration named name in dir, with a
+// TimestampNumbering version taken from t.
 func CreateMigration(name, migrationType, dir string, t time.Time) (path string, err error) {
+	return CreateMigrationNumbered(name, migrationType, dir, t, TimestampNumbering)
+}
+
+// CreateMigrationNumbered creates a new migration named name in dir,
+// versioned as per numbering. t is only used by TimestampNumbering. If
+// dir lists several directories, the migration is created in the first,
+// and SequentialNumbering counts on from the migrations of them all.
+func CreateMigrationNumbered(name, migrationType, dir string, t time.Time, numbering Numbering) (path string, err error) {
+	return CreateMigrationWithTemplate(name, migrationType, dir, t, numbering, nil)
+}
+
+// MigrationTemplateData is what the template of a new migration is
+// executed with.
+type MigrationTemplateData struct {
+	Version int64  // unpadded, as Go migration funcs are named, e.g. Up_5
+	Name    string // as passed to CreateMigrationWithTemplate
+}
+
+// DefaultMigrationTemplate returns the template goose scaffolds new
+// migrations of migrationType, "sql" or "go", with; nil for any other type.
+// It can be a starting point for templates of your own.
+func DefaultMigrationTemplate(migrationType string) *template.Template {
+	switch migrationType {
+	case "sql":
+		return sqlMigrationTemplate
+	case "go":
+		return goMigrationTemplate
+	}
+	return nil
+}
+
+// CreateMigrationWithTemplate is CreateMigrationNumbered, with the body of
+// the new migration produced by executing tmpl with its
+// MigrationTemplateData. A nil tmpl uses DefaultMigrationTemplate.
+func CreateMigrationWithTemplate(name, migrationType, dir string, t time.Time, numbering Numbering, tmpl *template.Template) (path string, err error) {
 	if migrationType != "go" && migrationType != "sql" {
 		return "", errors.New("migration type must be 'go' or 'sql'")
 	}
 
-	timestamp := t.Format("20060102150405")
-	filename := fmt.Sprintf("%v_%v.%v", timestamp, name, migrationType)
+	var version int64
+	var prefix string
+	switch numbering {
+	case TimestampNumbering:
+		prefix = t.UTC().Format(timestampFormat)
+		if version, err = strconv.ParseInt(prefix, 10, 64); err != nil {
+			return "", err
+		}
+	case SequentialNumbering:
+		migrations, err := collectMigrations(dir)
+		if err != nil {
+			return "", err
+		}
+		for _, m := range migrations {
+			if m.Version > version {
+				version = m.Version
+			}
+		}
+		version++
+		prefix = fmt.Sprintf("%05d", version)
+	default:
+		return "", fmt.Errorf("unknown numbering %d", numbering)
+	}
+
+	return writeMigration(name, migrationType, dir, prefix, version, tmpl)
+}
 
-	fpath := filepath.Join(dir, filename)
+// CreateMigrationVersion is CreateMigrationWithTemplate for a migration
+// with the given version, for tools that pick versions themselves. It
+// fails if a migration in dir already has that version. A version of 0
+// picks one as per numbering instead, from the current time for
+// TimestampNumbering. Like the other Create functions it prints
+// nothing, and returns the path of the new file, so that it can be
+// opened in an editor.
+func CreateMigrationVersion(name, migrationType, dir string, version int64, numbering Numbering, tmpl *template.Template) (path string, err error) {
+	if version == 0 {
+		return CreateMigrationWithTemplate(name, migrationType, dir, time.Now(), numbering, tmpl)
+	}
+	if migrationType != "go" && migrationType != "sql" {
+		return "", errors.New("migration type must be 'go' or 'sql'")
+	}
+	if version < 0 {
+		return "", fmt.Errorf("invalid version %d", version)
+	}
 
-	var tmpl *template.Template
-	if migrationType == "sql" {
-		tmpl = sqlMigrationTemplate
-	} else {
-		tmpl = goMigrationTemplate
+	migrations, err := collectMigrations(dir)
+	if err != nil {
+		return "", err
 	}
+	for _, m := range migrations {
+		if m.Version == version {
+			return "", fmt.Errorf("version %d is already taken by %s", version, m.Source)
+		}
+	}
+
+	return writeMigration(name, migrationType, dir, fmt.Sprintf("%05d", version), version, tmpl)
+}
 
-	path, err = writeTemplateToFile(fpath, tmpl, timestamp)
+// write the migration versioned version to the first directory of dir,
+// its filename beginning with prefix
+func writeMigration(name, migrationType, dir, prefix string, version int64, tmpl *template.Template) (string, error) {
+	filename := fmt.Sprintf("%v_%v.%v", prefix, name, migrationType)
 
-	return
+	fpath := filepath.Join(migrationDirs(dir)[0], filename)
+
+	if tmpl == nil {
+		tmpl = DefaultMigrationTemplate(migrationType)
+	}
+
+	return writeTemplateToFile(fpath, tmpl, MigrationTemplateData{Version: version, Name: name})
 }
 
 // Update the version table for the given migration,
 // and finalize the transaction.
 func FinalizeMigration(conf *DBConf, txn *sql.Tx, direction Direction, v int64) error {
+	return FinalizeMigrationRecord(conf, txn, direction, v, MigrationRecord{})
+}
+
+// FinalizeMigrationChecksum is FinalizeMigration for a migration whose
+// script has the given checksum. The checksum is recorded if
+// conf.RecordChecksums is set and the migration was applied.
+func FinalizeMigrationChecksum(conf *DBConf, txn *sql.Tx, direction Direction, v int64, checksum string) error {
+	return FinalizeMigrationRecord(conf, txn, direction, v, MigrationRecord{Checksum: checksum})
+}
+
+// MigrationRecord is what may be recorded in the version table about a
+// migration besides its version and direction.
+type MigrationRecord struct {
+	Checksum string // of the script, recorded if DBConf.RecordChecksums is set
+	Source   string // filename of the script, recorded if DBConf.RecordAppliedBy is set
+}
+
+// FinalizeMigrationRecord is FinalizeMigration, recording whichever parts
+// of rec conf asks for.
+func FinalizeMigrationRecord(conf *DBConf, txn *sql.Tx, direction Direction, v int64, rec MigrationRecord) error {
 	// XXX: drop goose_db_version table on some minimum version number?
-	stmt := conf.Driver.Dialect.insertVersionSql()
-	if _, err := txn.Exec(stmt, v, bool(direction)); err != nil {
+	if err := insertVersion(context.Background(), conf, txn, direction, v, rec); err != nil {
 		txn.Rollback()
 		return err
 	}
 
 	return txn.Commit()
 }
+
+// execer is the part of *sql.Tx and Querier
+// needed to update the version table.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// queryer is the part of Querier
+// needed to read the version table.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (Rows, error)
+}
+
+// insert the version table row recording that v went in direction
+func insertVersion(ctx context.Context, conf *DBConf, e execer, direction Direction, v int64, rec MigrationRecord) error {
+	query, args := versionInsertQuery(conf, direction, v, rec)
+	_, err := e.ExecContext(ctx, query, args...)
+	return err
+}
+
+// the statement recording that v went in direction, and its arguments.
+// The statement depends only on conf, so is the same for every migration
+// of a run.
+func versionInsertQuery(conf *DBConf, direction Direction, v int64, rec MigrationRecord) (string, []interface{}) {
+	cols, args := versionRecordColumns(conf, direction, rec)
+	args = append([]interface{}{v, bool(direction)}, args...)
+	if len(cols) == 0 {
+		return conf.Driver.Dialect.insertVersionSql(), args
+	}
+	return conf.Driver.Dialect.insertVersionColumnsSql(cols), args
+}
+
+// versionInsert is the version table insert of a run, prepared once so
+// that the database needn't parse it for every migration. database/sql
+// prepares it again only on connections it hasn't been prepared on yet,
+// and each transaction of the run uses it from there.
+type versionInsert struct {
+	stmt *sql.Stmt // nil if the driver couldn't prepare it
+	txn  *sql.Tx   // the transaction stmt was prepared in, if any
+}
+
+// prepare the version table insert for conf on q, the Executor or the
+// Tx a run is confined to. Only database/sql can prepare it; other
+// executors, a driver that cannot, or a version table that doesn't
+// exist yet leave the inserts unprepared.
+func prepareVersionInsert(ctx context.Context, conf *DBConf, q Querier) *versionInsert {
+	var p interface {
+		PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	}
+	var txn *sql.Tx
+	switch q := q.(type) {
+	case sqlDB:
+		p = q.DB
+	case sqlTx:
+		p, txn = q.Tx, q.Tx
+	default:
+		return &versionInsert{}
+	}
+
+	query, _ := versionInsertQuery(conf, DirectionUp, 0, MigrationRecord{})
+	stmt, err := p.PrepareContext(ctx, query)
+	if err != nil {
+		return &versionInsert{}
+	}
+	return &versionInsert{stmt: stmt, txn: txn}
+}
+
+// insert the version table row recording that v went in direction, in txn
+func (vi *versionInsert) exec(ctx context.Context, conf *DBConf, txn Tx, direction Direction, v int64, rec MigrationRecord) error {
+	st, ok := txn.(sqlTx)
+	if vi == nil || vi.stmt == nil || !ok {
+		return insertVersion(ctx, conf, txn, direction, v, rec)
+	}
+
+	stmt := vi.stmt
+	if st.Tx != vi.txn {
+		stmt = st.StmtContext(ctx, stmt)
+	}
+	_, args := versionInsertQuery(conf, direction, v, rec)
+	_, err := stmt.ExecContext(ctx, args...)
+	return err
+}
+
+func (vi *versionInsert) close() {
+	if vi.stmt != nil {
+		vi.stmt.Close()
+	}
+}
+
+// the optional version table columns conf asks to record, beyond
+// version_id and is_applied, and their values for rec
+func versionRecordColumns(conf *DBConf, direction Direction, rec MigrationRecord) (cols []string, args []interface{}) {
+	if conf.RecordChecksums {
+		sum := sql.NullString{String: rec.Checksum, Valid: rec.Checksum != "" && direction == DirectionUp}
+		cols = append(cols, "checksum")
+		args = append(args, sum)
+	}
+	if conf.RecordAppliedBy {
+		cols = append(cols, "applied_by", "source_file")
+		args = append(args,
+			sql.NullString{String: appliedBy, Valid: appliedBy != ""},
+			sql.NullString{String: rec.Source, Valid: rec.Source != ""})
+	}
+	if conf.Clock != nil {
+		cols = append(cols, "tstamp")
+		args = append(args, clockValue(conf.Clock))
+	}
+	return cols, args
+}
+
+// clockValue is the tstamp argument of a version table insert with
+// DBConf.Clock set, which reads the clock only as the insert runs, so
+// that preparing the insert doesn't.
+type clockValue func() time.Time
+
+func (c clockValue) Value() (driver.Value, error) { return c(), nil }
@@ -1,13 +1,33 @@
 package goose
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
+	"math"
+	"net"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
 	"testing"
+	"testing/fstest"
+	"text/template"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 
 	"github.com/stretchr/testify/assert"
@@ -158,6 +178,34 @@ func TestCollectMigrations(t *testing.T) {
 	})
 }
 
+func TestCollectMigrations_multipleDirs(t *testing.T) {
+	SetBaseFS(fstest.MapFS{
+		"shared/00001_one.sql":       &fstest.MapFile{},
+		"shared/00003_three.sql":     &fstest.MapFile{},
+		"service/00002_two.up.sql":   &fstest.MapFile{},
+		"service/00002_two.down.sql": &fstest.MapFile{},
+	})
+	defer SetBaseFS(nil)
+	dirs := "shared" + string(filepath.ListSeparator) + "service"
+
+	migs, err := GetMigrations(dirs, 0, 3)
+	require.NoError(t, err)
+	var sources []string
+	for _, m := range migs {
+		sources = append(sources, m.Source)
+	}
+	assert.Equal(t, []string{"shared/00001_one.sql", "service/00002_two.up.sql", "shared/00003_three.sql"}, sources)
+	assert.Equal(t, "service/00002_two.down.sql", migs[1].DownSource)
+
+	SetBaseFS(fstest.MapFS{
+		"shared/00001_one.sql":    &fstest.MapFile{Data: []byte("-- +goose Up\n")},
+		"service/00001_other.sql": &fstest.MapFile{Data: []byte("-- +goose Up\n")},
+	})
+	_, err = CollectMigrations(dirs)
+	assert.EqualError(t, err, "more than one file specifies the migration for version 1 (shared/00001_one.sql and service/00001_other.sql)")
+	assert.EqualError(t, errors.Join(Validate(dirs)...), "more than one file specifies the migration for version 1 (shared/00001_one.sql, service/00001_other.sql)")
+}
+
 func testRunMigrationsOnDb(t *testing.T, driver DBDriver) {
 	md, mdCleanup := setupMigrationsDir(map[string][2]string{
 		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
@@ -394,3 +442,3340 @@ func TestRunMigrationsOnDb_upDownUp_postgres(t *testing.T) {
 func TestRunMigrationsOnDb_upDownUp_redshift(t *testing.T) {
 	testRunMigrationsOnDb_upDownUp(t, getRedshiftDriver(t))
 }
+
+func testRunMigrationsOnDb_concurrent(t *testing.T, driver DBDriver) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+		"20010203040508_two.sql":   [2]string{"INSERT INTO test(value) VALUES('two');", "DELETE FROM test WHERE value = 'two';"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        driver,
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	db.Exec("DROP TABLE goose_db_version")
+	db.Exec("DROP TABLE test")
+
+	errs := make(chan error)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errs <- RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040508, db)
+		}()
+	}
+	require.NoError(t, <-errs)
+	require.NoError(t, <-errs)
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM test").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+func TestRunMigrationsOnDb_concurrent_mysql(t *testing.T) {
+	testRunMigrationsOnDb_concurrent(t, getMysqlDriver(t))
+}
+func TestRunMigrationsOnDb_concurrent_postgres(t *testing.T) {
+	testRunMigrationsOnDb_concurrent(t, getPostgresDriver(t))
+}
+
+func testUpByOne(t *testing.T, driver DBDriver) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        driver,
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	db.Exec("DROP TABLE goose_db_version")
+	db.Exec("DROP TABLE test")
+
+	version, err := UpByOne(conf, conf.MigrationsDir, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040506), version)
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM test").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	version, err = UpByOne(conf, conf.MigrationsDir, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040507), version)
+
+	err = db.QueryRow("SELECT COUNT(*) FROM test").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	version, err = UpByOne(conf, conf.MigrationsDir, db)
+	assert.Equal(t, ErrNoNextVersion, err)
+	assert.Equal(t, int64(20010203040507), version)
+}
+func TestUpByOne_sqlite3(t *testing.T) {
+	testUpByOne(t, getSqlite3Driver(t))
+}
+func TestUpByOne_mysql(t *testing.T) {
+	testUpByOne(t, getMysqlDriver(t))
+}
+func TestUpByOne_postgres(t *testing.T) {
+	testUpByOne(t, getPostgresDriver(t))
+}
+func TestUpByOne_redshift(t *testing.T) {
+	testUpByOne(t, getRedshiftDriver(t))
+}
+
+func testDownByOne(t *testing.T, driver DBDriver) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+	})
+	defer mdCleanup()
+	err := ioutil.WriteFile(filepath.Join(md, "20010203040508_two.sql"), []byte("-- +goose Up\nINSERT INTO test(value) VALUES('two');\n"), 0600)
+	require.NoError(t, err)
+	conf := &DBConf{
+		Driver:        driver,
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	db.Exec("DROP TABLE goose_db_version")
+	db.Exec("DROP TABLE test")
+
+	version, err := DownByOne(conf, conf.MigrationsDir, db)
+	assert.Equal(t, ErrNoCurrentVersion, err)
+	assert.Equal(t, int64(0), version)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040507, db)
+	require.NoError(t, err)
+
+	version, err = DownByOne(conf, conf.MigrationsDir, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040507), version)
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM test").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	current, err := EnsureDBVersion(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040506), current)
+
+	version, err = DownByOne(conf, conf.MigrationsDir, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040506), version)
+
+	version, err = DownByOne(conf, conf.MigrationsDir, db)
+	assert.Equal(t, ErrNoCurrentVersion, err)
+	assert.Equal(t, int64(0), version)
+
+	// a migration with no Down section is left applied
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040508, db)
+	require.NoError(t, err)
+	_, err = DownByOne(conf, conf.MigrationsDir, db)
+	assert.Error(t, err)
+	current, err = EnsureDBVersion(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040508), current)
+}
+func TestDownByOne_sqlite3(t *testing.T) {
+	testDownByOne(t, getSqlite3Driver(t))
+}
+func TestDownByOne_mysql(t *testing.T) {
+	testDownByOne(t, getMysqlDriver(t))
+}
+func TestDownByOne_postgres(t *testing.T) {
+	testDownByOne(t, getPostgresDriver(t))
+}
+func TestDownByOne_redshift(t *testing.T) {
+	testDownByOne(t, getRedshiftDriver(t))
+}
+
+func testUpTo(t *testing.T, driver DBDriver) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+		"20010203040509_two.sql":   [2]string{"INSERT INTO test(value) VALUES('two');", "DELETE FROM test WHERE value = 'two';"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        driver,
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	db.Exec("DROP TABLE goose_db_version")
+	db.Exec("DROP TABLE test")
+
+	// target between migrations
+	version, err := UpTo(conf, conf.MigrationsDir, 20010203040508, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040507), version)
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM test").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// target below current
+	version, err = UpTo(conf, conf.MigrationsDir, 20010203040506, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040507), version)
+
+	err = db.QueryRow("SELECT COUNT(*) FROM test").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	version, err = UpTo(conf, conf.MigrationsDir, 20010203040509, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040509), version)
+
+	err = db.QueryRow("SELECT COUNT(*) FROM test").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+func TestUpTo_sqlite3(t *testing.T) {
+	testUpTo(t, getSqlite3Driver(t))
+}
+func TestUpTo_mysql(t *testing.T) {
+	testUpTo(t, getMysqlDriver(t))
+}
+func TestUpTo_postgres(t *testing.T) {
+	testUpTo(t, getPostgresDriver(t))
+}
+func TestUpTo_redshift(t *testing.T) {
+	testUpTo(t, getRedshiftDriver(t))
+}
+
+func testDownTo(t *testing.T, driver DBDriver) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+		"20010203040508_two.sql":   [2]string{"INSERT INTO test(value) VALUES('two');", "DELETE FROM test WHERE value = 'two';"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        driver,
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	db.Exec("DROP TABLE goose_db_version")
+	db.Exec("DROP TABLE test")
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040508, db)
+	require.NoError(t, err)
+
+	// target above current
+	version, err := DownTo(conf, conf.MigrationsDir, 20010203040509, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040508), version)
+
+	version, err = DownTo(conf, conf.MigrationsDir, 20010203040506, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040506), version)
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM test").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	version, err = DownTo(conf, conf.MigrationsDir, 0, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), version)
+
+	_, err = db.Query("SELECT value FROM test")
+	require.Error(t, err) // table won't exist
+}
+func TestDownTo_sqlite3(t *testing.T) {
+	testDownTo(t, getSqlite3Driver(t))
+}
+func TestDownTo_mysql(t *testing.T) {
+	testDownTo(t, getMysqlDriver(t))
+}
+func TestDownTo_postgres(t *testing.T) {
+	testDownTo(t, getPostgresDriver(t))
+}
+func TestDownTo_redshift(t *testing.T) {
+	testDownTo(t, getRedshiftDriver(t))
+}
+
+func testReset(t *testing.T, driver DBDriver) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+		"20010203040508_two.sql":   [2]string{"INSERT INTO test(value) VALUES('two');", "DELETE FROM test WHERE value = 'two';"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        driver,
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	db.Exec("DROP TABLE goose_db_version")
+	db.Exec("DROP TABLE test")
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040508, db)
+	require.NoError(t, err)
+
+	rolledBack, err := Reset(conf, conf.MigrationsDir, db)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{20010203040508, 20010203040507, 20010203040506}, rolledBack)
+
+	version, err := GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), version)
+
+	_, err = db.Query("SELECT value FROM test")
+	require.Error(t, err) // table won't exist
+
+	rolledBack, err = Reset(conf, conf.MigrationsDir, db)
+	require.NoError(t, err)
+	assert.Empty(t, rolledBack)
+}
+func TestReset_sqlite3(t *testing.T) {
+	testReset(t, getSqlite3Driver(t))
+}
+func TestReset_mysql(t *testing.T) {
+	testReset(t, getMysqlDriver(t))
+}
+func TestReset_postgres(t *testing.T) {
+	testReset(t, getPostgresDriver(t))
+}
+func TestReset_redshift(t *testing.T) {
+	testReset(t, getRedshiftDriver(t))
+}
+
+func TestDownTo_missingDown(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+	})
+	defer mdCleanup()
+	err := ioutil.WriteFile(filepath.Join(md, "20010203040507_one.sql"),
+		[]byte("-- +goose Up\nINSERT INTO test(value) VALUES('one');\n"), 0600)
+	require.NoError(t, err)
+
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040507, db)
+	require.NoError(t, err)
+
+	version, err := DownTo(conf, conf.MigrationsDir, 0, db)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "20010203040507_one.sql")
+	assert.Equal(t, int64(20010203040507), version)
+
+	// nothing was rolled back
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM test").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func testRedo(t *testing.T, driver DBDriver) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        driver,
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	db.Exec("DROP TABLE goose_db_version")
+	db.Exec("DROP TABLE test")
+
+	_, err = Redo(conf, conf.MigrationsDir, db)
+	require.Error(t, err) // nothing applied yet
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040507, db)
+	require.NoError(t, err)
+
+	// a row the Down section doesn't know about shows the table was kept
+	_, err = db.Exec("INSERT INTO test(value) VALUES('other')")
+	require.NoError(t, err)
+
+	version, err := Redo(conf, conf.MigrationsDir, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040507), version)
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM test").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	version, err = EnsureDBVersion(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040507), version)
+}
+func TestRedo_sqlite3(t *testing.T) {
+	testRedo(t, getSqlite3Driver(t))
+}
+func TestRedo_mysql(t *testing.T) {
+	testRedo(t, getMysqlDriver(t))
+}
+func TestRedo_postgres(t *testing.T) {
+	testRedo(t, getPostgresDriver(t))
+}
+func TestRedo_redshift(t *testing.T) {
+	testRedo(t, getRedshiftDriver(t))
+}
+
+func TestRedo_emptyDown(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", ""},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040507, db)
+	require.NoError(t, err)
+
+	_, err = Redo(conf, conf.MigrationsDir, db)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "20010203040507_one.sql has an empty Down section, cannot redo")
+
+	// the row was neither rolled back nor inserted again
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM test").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestRedo_reapplyFails(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"CREATE TABLE other(value VARCHAR(20));", "DELETE FROM test;"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040507, db)
+	require.NoError(t, err)
+
+	// the Down section leaves the table it should drop, so reapplying fails
+	_, err = Redo(conf, conf.MigrationsDir, db)
+	require.Error(t, err)
+	var me *MigrationError
+	require.True(t, errors.As(err, &me), "%v", err)
+	assert.Equal(t, int64(20010203040507), me.Version)
+}
+
+func testApplyFile(t *testing.T, driver DBDriver) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+		"20010203040508_two.sql":   [2]string{"INSERT INTO test(value) VALUES('two');", "DELETE FROM test WHERE value = 'two';"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        driver,
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	db.Exec("DROP TABLE goose_db_version")
+	db.Exec("DROP TABLE test")
+
+	err = ApplyFile(conf, md, "20010203040509_missing.sql", DirectionUp, db)
+	assert.Error(t, err)
+
+	err = ApplyFile(conf, md, "20010203040506_setup.sql", DirectionUp, db)
+	require.NoError(t, err)
+	err = ApplyFile(conf, md, "20010203040506_setup.sql", DirectionUp, db)
+	assert.Error(t, err, "applied already")
+
+	// skipping one leaves it behind the current version
+	err = ApplyFile(conf, md, "20010203040508_two.sql", DirectionUp, db)
+	require.NoError(t, err)
+	err = ApplyFile(conf, md, "20010203040507_one.sql", DirectionUp, db)
+	assert.Error(t, err, "out of order")
+
+	err = ApplyFile(conf, md, "20010203040507_one.sql", DirectionDown, db)
+	assert.True(t, errors.Is(err, ErrNotApplied))
+	err = ApplyFile(conf, md, "20010203040506_setup.sql", DirectionDown, db)
+	assert.Error(t, err, "not the current version")
+
+	conf.AllowOutOfOrder = true
+	err = ApplyFile(conf, md, "20010203040507_one.sql", DirectionUp, db)
+	require.NoError(t, err)
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM test").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	err = ApplyFile(conf, md, "20010203040507_one.sql", DirectionDown, db)
+	require.NoError(t, err)
+	err = db.QueryRow("SELECT COUNT(*) FROM test").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	version, err := EnsureDBVersion(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040508), version)
+}
+func TestApplyFile_sqlite3(t *testing.T) {
+	testApplyFile(t, getSqlite3Driver(t))
+}
+func TestApplyFile_mysql(t *testing.T) {
+	testApplyFile(t, getMysqlDriver(t))
+}
+func TestApplyFile_postgres(t *testing.T) {
+	testApplyFile(t, getPostgresDriver(t))
+}
+func TestApplyFile_redshift(t *testing.T) {
+	testApplyFile(t, getRedshiftDriver(t))
+}
+
+func TestStatusJSON(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040506, db)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = StatusJSON(conf, conf.MigrationsDir, db, &buf)
+	require.NoError(t, err)
+
+	var statuses []map[string]interface{}
+	err = json.Unmarshal(buf.Bytes(), &statuses)
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+
+	assert.Equal(t, float64(20010203040506), statuses[0]["version"])
+	assert.Equal(t, "20010203040506_setup.sql", statuses[0]["source"])
+	assert.Equal(t, true, statuses[0]["applied"])
+	assert.IsType(t, "", statuses[0]["applied_at"])
+
+	assert.Equal(t, float64(20010203040507), statuses[1]["version"])
+	assert.Equal(t, "20010203040507_one.sql", statuses[1]["source"])
+	assert.Equal(t, false, statuses[1]["applied"])
+	assert.Nil(t, statuses[1]["applied_at"])
+}
+
+func TestStatusFilter(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+		"20010203040508_two.sql":   [2]string{"INSERT INTO test(value) VALUES('two');", "DELETE FROM test WHERE value = 'two';"},
+		"20010203040509_three.sql": [2]string{"INSERT INTO test(value) VALUES('three');", "DELETE FROM test WHERE value = 'three';"},
+	})
+	defer mdCleanup()
+
+	// a day apart, starting 2001-02-03
+	appliedAt := time.Date(2001, 2, 2, 0, 0, 0, 0, time.UTC)
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+		Clock: func() time.Time {
+			appliedAt = appliedAt.Add(24 * time.Hour)
+			return appliedAt
+		},
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040508, db)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		filter   StatusFilter
+		versions []int64
+	}{
+		{StatusFilter{}, []int64{20010203040506, 20010203040507, 20010203040508, 20010203040509}},
+		{StatusFilter{Since: time.Date(2001, 2, 3, 12, 0, 0, 0, time.UTC)}, []int64{20010203040507, 20010203040508}},
+		{StatusFilter{Last: 1}, []int64{20010203040508}},
+		{StatusFilter{Last: 10}, []int64{20010203040506, 20010203040507, 20010203040508}},
+		{StatusFilter{Since: time.Date(2001, 2, 4, 12, 0, 0, 0, time.UTC), Last: 2}, []int64{20010203040508}},
+		{StatusFilter{Since: time.Date(2001, 2, 10, 0, 0, 0, 0, time.UTC)}, nil},
+	} {
+		migrations, err := Status(conf, conf.MigrationsDir, db, tc.filter)
+		require.NoError(t, err)
+		var versions []int64
+		for _, m := range migrations {
+			versions = append(versions, m.Version)
+		}
+		assert.Equal(t, tc.versions, versions, "%+v", tc.filter)
+
+		var buf bytes.Buffer
+		err = StatusJSONFiltered(conf, conf.MigrationsDir, db, &buf, tc.filter)
+		require.NoError(t, err)
+		var statuses []migrationStatus
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &statuses))
+		versions = nil
+		for _, s := range statuses {
+			versions = append(versions, s.Version)
+		}
+		assert.Equal(t, tc.versions, versions, "%+v", tc.filter)
+	}
+}
+
+func TestGetDBVersionOnDb(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+		"20010203040508_two.sql":   [2]string{"INSERT INTO test(value) VALUES('two');", "DELETE FROM test WHERE value = 'two';"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	_, err = GetDBVersionOnDb(conf, db)
+	assert.Equal(t, ErrTableDoesNotExist, err)
+
+	// apply the last migration before the middle one
+	err = os.Rename(filepath.Join(md, "20010203040507_one.sql"), filepath.Join(md, "20010203040507_one.sql_"))
+	require.NoError(t, err)
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040508, db)
+	require.NoError(t, err)
+	err = os.Rename(filepath.Join(md, "20010203040507_one.sql_"), filepath.Join(md, "20010203040507_one.sql"))
+	require.NoError(t, err)
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040508, db)
+	require.NoError(t, err)
+
+	version, err := GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040508), version)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040506, db)
+	require.NoError(t, err)
+
+	version, err = GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040506), version)
+}
+
+func TestRunMigrationsOnDbContext_cancelled(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = RunMigrationsOnDbContext(ctx, conf, conf.MigrationsDir, 20010203040506, db)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), context.Canceled.Error())
+
+	_, err = db.Query("SELECT value FROM test")
+	require.Error(t, err) // table won't exist
+}
+
+func TestSetBaseFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/20010203040506_setup.sql": &fstest.MapFile{
+			Data: []byte("-- +goose Up\nCREATE TABLE test(value VARCHAR(20));\n-- +goose Down\nDROP TABLE test;\n"),
+		},
+		"migrations/20010203040507_one.sql": &fstest.MapFile{
+			Data: []byte("-- +goose Up\nINSERT INTO test(value) VALUES('one');\n-- +goose Down\nDELETE FROM test WHERE value = 'one';\n"),
+		},
+		"migrations/README": &fstest.MapFile{},
+	}
+	SetBaseFS(fsys)
+	defer SetBaseFS(nil)
+
+	migs, err := CollectMigrations("migrations")
+	require.NoError(t, err)
+	assert.Equal(t, []*Migration{
+		{Version: 20010203040506, Source: "migrations/20010203040506_setup.sql"},
+		{Version: 20010203040507, Source: "migrations/20010203040507_one.sql"},
+	}, migs)
+
+	version, err := GetMostRecentDBVersion("migrations")
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040507), version)
+
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: "migrations",
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040507, db)
+	require.NoError(t, err)
+
+	var value string
+	err = db.QueryRow("SELECT value FROM test").Scan(&value)
+	require.NoError(t, err)
+	assert.Equal(t, "one", value)
+}
+
+// hides every method of an fs.FS but Open
+type openOnlyFS struct {
+	fs.FS
+}
+
+// fails to open anything but directories, to prove files go unread
+type namesOnlyFS struct {
+	fstest.MapFS
+}
+
+func (fsys namesOnlyFS) Open(name string) (fs.File, error) {
+	if filepath.Ext(name) != "" {
+		return nil, fmt.Errorf("%s was opened", name)
+	}
+	return fsys.MapFS.Open(name)
+}
+
+func TestWalkMigrations(t *testing.T) {
+	SetBaseFS(namesOnlyFS{fstest.MapFS{
+		"migrations/00003_three.sql":    &fstest.MapFile{},
+		"migrations/00001_one.up.sql":   &fstest.MapFile{},
+		"migrations/00001_one.down.sql": &fstest.MapFile{},
+		"migrations/00002_two.go":       &fstest.MapFile{},
+	}})
+	defer SetBaseFS(nil)
+
+	var walked []string
+	err := WalkMigrations("migrations", func(version int64, source string) error {
+		walked = append(walked, fmt.Sprintf("%d %s", version, source))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"1 migrations/00001_one.up.sql",
+		"2 migrations/00002_two.go",
+		"3 migrations/00003_three.sql",
+	}, walked)
+
+	stop := errors.New("stop")
+	walked = nil
+	err = WalkMigrations("migrations", func(version int64, source string) error {
+		walked = append(walked, source)
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Len(t, walked, 1)
+}
+
+func TestReadMigrationsStatus_namesOnly(t *testing.T) {
+	SetBaseFS(namesOnlyFS{fstest.MapFS{
+		"migrations/00001_one.sql": &fstest.MapFile{},
+		"migrations/00002_two.sql": &fstest.MapFile{},
+	}})
+	defer SetBaseFS(nil)
+
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: "migrations",
+	}
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// as used by DryRun and FixOnDb, which need no metadata
+	_, migrations, err := readMigrationsStatus(context.Background(), conf, conf.MigrationsDir, DBExecutor(db))
+	assert.Equal(t, ErrTableDoesNotExist, err)
+	assert.Len(t, migrations, 2)
+}
+
+func TestSetMigrationExtensions(t *testing.T) {
+	SetBaseFS(fstest.MapFS{
+		"migrations/00001_one.sql.tmpl": &fstest.MapFile{Data: []byte("-- +goose Up\nSELECT 1;\n")},
+		"migrations/00002_two.sql":      &fstest.MapFile{Data: []byte("-- +goose Up\nSELECT 2;\n")},
+		"migrations/00003_three.go":     &fstest.MapFile{},
+		"migrations/notes_on_four.txt":  &fstest.MapFile{},
+	})
+	defer SetBaseFS(nil)
+
+	assert.Error(t, SetMigrationExtensions())
+	assert.Error(t, SetMigrationExtensions("sql"))
+
+	require.NoError(t, SetMigrationExtensions(".sql", ".sql.tmpl"))
+	defer SetMigrationExtensions(".sql", ".go")
+
+	ms, err := GetMigrations("migrations", 0, math.MaxInt64)
+	require.NoError(t, err)
+	require.Len(t, ms, 2)
+	assert.Equal(t, "migrations/00001_one.sql.tmpl", ms[0].Source)
+	assert.Equal(t, "sql", ms[0].Type())
+	assert.Equal(t, "migrations/00002_two.sql", ms[1].Source)
+	assert.Empty(t, Validate("migrations"))
+
+	_, err = NumericComponent("00003_three.go")
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	SetBaseFS(fstest.MapFS{
+		"migrations/00001_one.sql":      &fstest.MapFile{Data: []byte("-- +goose Up\n-- +goose StatementBegin\nSELECT 1;\n-- +goose StatementEnd\n-- +goose Down\nSELECT 1;\n")},
+		"migrations/00002_two.up.sql":   &fstest.MapFile{Data: []byte("SELECT 2;\n")},
+		"migrations/00002_two.down.sql": &fstest.MapFile{Data: []byte("SELECT 2;\n")},
+		"migrations/00003_three.go":     &fstest.MapFile{},
+		"migrations/README.md":          &fstest.MapFile{},
+	})
+	defer SetBaseFS(nil)
+	assert.Empty(t, Validate("migrations"))
+
+	SetBaseFS(fstest.MapFS{
+		"migrations/00001_one.sql":        &fstest.MapFile{Data: []byte("SELECT 1;\n")},
+		"migrations/00002_two.sql":        &fstest.MapFile{Data: []byte("-- +goose Up\n-- +goose StatementBegin\nSELECT 2;\n-- +goose Down\n-- +goose StatementEnd\n")},
+		"migrations/00002_again.go":       &fstest.MapFile{},
+		"migrations/00003_three.down.sql": &fstest.MapFile{},
+		"migrations/abc_four.sql":         &fstest.MapFile{},
+		"migrations/00005.sql":            &fstest.MapFile{},
+		"migrations/00006_.go":            &fstest.MapFile{},
+	})
+	var got []string
+	for _, err := range Validate("migrations") {
+		got = append(got, err.Error())
+	}
+	assert.Equal(t, []string{
+		"migrations/00001_one.sql: no '-- +goose Up' annotation",
+		"migrations/00002_two.sql:2: StatementBegin has no matching StatementEnd",
+		"migrations/00002_two.sql:5: StatementEnd with no matching StatementBegin",
+		"migrations/00005.sql: invalid migration filename: no separator found",
+		"migrations/00006_.go: invalid migration filename: no name after the version",
+		`migrations/abc_four.sql: invalid migration filename: strconv.ParseInt: parsing "abc": invalid syntax`,
+		"more than one file specifies the migration for version 2 (migrations/00002_again.go, migrations/00002_two.sql)",
+		"migrations/00003_three.down.sql has no matching .up.sql file",
+	}, got)
+}
+
+func TestSetBaseFS_zip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range map[string]string{
+		"migrations/20010203040506_setup.sql": "-- +goose Up\nCREATE TABLE test(value VARCHAR(20));\n-- +goose Down\nDROP TABLE test;\n",
+		"migrations/20010203040507_one.sql":   "-- +goose Up\nINSERT INTO test(value) VALUES('one');\n-- +goose Down\nDELETE FROM test WHERE value = 'one';\n",
+	} {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = io.WriteString(w, body)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	for _, fsys := range []fs.FS{zr, openOnlyFS{zr}} {
+		SetBaseFS(fsys)
+		defer SetBaseFS(nil)
+
+		conf := &DBConf{
+			Driver:        getSqlite3Driver(t),
+			MigrationsDir: "migrations",
+		}
+
+		db, err := OpenDBFromDBConf(conf)
+		require.NoError(t, err)
+
+		err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040507, db)
+		require.NoError(t, err)
+
+		var value string
+		err = db.QueryRow("SELECT value FROM test").Scan(&value)
+		require.NoError(t, err)
+		assert.Equal(t, "one", value)
+	}
+}
+
+func TestOpenArchive(t *testing.T) {
+	files := []struct{ name, body string }{
+		// out of version order, and with entries that aren't migrations
+		{"./", ""},
+		{"./20010203040507_one.sql", "-- +goose Up\nINSERT INTO test(value) VALUES('one');\n-- +goose Down\nDELETE FROM test WHERE value = 'one';\n"},
+		{"./README.md", "not a migration"},
+		{"./20010203040506_setup.sql", "-- +goose Up\nCREATE TABLE test(value VARCHAR(20));\n-- +goose Down\nDROP TABLE test;\n"},
+	}
+
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.body)), Typeflag: tar.TypeReg}
+		if strings.HasSuffix(f.name, "/") {
+			hdr.Mode, hdr.Typeflag = 0755, tar.TypeDir
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err := io.WriteString(tw, f.body)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	var tgzBuf bytes.Buffer
+	gw := gzip.NewWriter(&tgzBuf)
+	_, err := gw.Write(tarBuf.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	for _, f := range files[1:] {
+		w, err := zw.Create(f.name[2:])
+		require.NoError(t, err)
+		_, err = io.WriteString(w, f.body)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+
+	dir := t.TempDir()
+	for name, b := range map[string][]byte{
+		"migrations.tar":    tarBuf.Bytes(),
+		"migrations.tar.gz": tgzBuf.Bytes(),
+		"migrations.zip":    zipBuf.Bytes(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			require.NoError(t, os.WriteFile(path, b, 0644))
+
+			fsys, err := OpenArchive(path)
+			require.NoError(t, err)
+			SetBaseFS(fsys)
+			defer SetBaseFS(nil)
+
+			conf := &DBConf{
+				Driver:        getSqlite3Driver(t),
+				MigrationsDir: ".",
+			}
+			db, err := OpenDBFromDBConf(conf)
+			require.NoError(t, err)
+			defer db.Close()
+
+			err = RunMigrationsOnDb(conf, conf.MigrationsDir, Latest, db)
+			require.NoError(t, err)
+
+			var value string
+			err = db.QueryRow("SELECT value FROM test").Scan(&value)
+			require.NoError(t, err)
+			assert.Equal(t, "one", value)
+		})
+	}
+
+	path := filepath.Join(dir, "migrations.txt")
+	require.NoError(t, os.WriteFile(path, []byte("not an archive"), 0644))
+	_, err = OpenArchive(path)
+	assert.Error(t, err)
+}
+
+func TestVerify(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:          getSqlite3Driver(t),
+		MigrationsDir:   md,
+		RecordChecksums: true,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040507, db)
+	require.NoError(t, err)
+
+	mismatches, err := Verify(conf, conf.MigrationsDir, db)
+	require.NoError(t, err)
+	assert.Empty(t, mismatches)
+
+	src := filepath.Join(md, "20010203040507_one.sql")
+	err = ioutil.WriteFile(src, []byte("-- +goose Up\nINSERT INTO test(value) VALUES('uno');\n"), 0600)
+	require.NoError(t, err)
+
+	mismatches, err = Verify(conf, conf.MigrationsDir, db)
+	require.NoError(t, err)
+	require.Len(t, mismatches, 1)
+	assert.Equal(t, int64(20010203040507), mismatches[0].Version)
+	assert.Equal(t, src, mismatches[0].Source)
+	assert.NotEqual(t, mismatches[0].Recorded, mismatches[0].Actual)
+}
+
+func TestVerify_noChecksumColumn(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// the version table as older versions of goose created it
+	_, err = db.Exec(`CREATE TABLE goose_db_version (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                version_id INTEGER NOT NULL,
+                is_applied INTEGER NOT NULL,
+                tstamp TIMESTAMP DEFAULT (datetime('now'))
+            )`)
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO goose_db_version (version_id, is_applied) VALUES (20010203040506, 1)")
+	require.NoError(t, err)
+
+	_, err = Verify(conf, conf.MigrationsDir, db)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "the version table has no checksum column")
+}
+
+func TestDryRun(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = DryRun(conf, conf.MigrationsDir, 20010203040507, db, &buf)
+	require.NoError(t, err)
+
+	out := buf.String()
+	assert.Contains(t, out, "-- create goose_db_version\n")
+	setup := strings.Index(out, "-- up 20010203040506_setup.sql\n")
+	one := strings.Index(out, "-- up 20010203040507_one.sql\n")
+	require.True(t, setup >= 0 && one >= 0, out)
+	assert.True(t, setup < one, out)
+	assert.Contains(t, out[setup:one], "CREATE TABLE test(value VARCHAR(20));")
+	assert.Contains(t, out[setup:one], "-- 20010203040506, true\n")
+	assert.Contains(t, out[one:], "INSERT INTO test(value) VALUES('one');")
+
+	// nothing was written
+	_, err = GetDBVersionOnDb(conf, db)
+	assert.Equal(t, ErrTableDoesNotExist, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040507, db)
+	require.NoError(t, err)
+
+	buf.Reset()
+	err = DryRun(conf, conf.MigrationsDir, 20010203040506, db, &buf)
+	require.NoError(t, err)
+
+	out = buf.String()
+	assert.NotContains(t, out, "-- create goose_db_version")
+	assert.NotContains(t, out, "20010203040506_setup.sql")
+	assert.Contains(t, out, "-- down 20010203040507_one.sql\n-- +goose Down\nDELETE FROM test WHERE value = 'one';\n")
+	assert.Contains(t, out, "-- 20010203040507, false\n")
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM test").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestRunMigrationsOnDb_noTransaction(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+
+	script := `-- +goose NO TRANSACTION
+-- +goose Up
+INSERT INTO test(value) VALUES('one');
+INSERT INTO missing(value) VALUES('two');
+
+-- +goose Down
+DELETE FROM test WHERE value = 'one';
+`
+	err := ioutil.WriteFile(filepath.Join(md, "20010203040507_one.sql"), []byte(script), 0600)
+	require.NoError(t, err)
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040507, db)
+	require.Error(t, err)
+
+	// the statement before the failure is not rolled back
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM test WHERE value = 'one'").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	version, err := GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040506), version)
+
+	script = strings.Replace(script, "INSERT INTO missing(value) VALUES('two');\n", "", 1)
+	err = ioutil.WriteFile(filepath.Join(md, "20010203040507_one.sql"), []byte(script), 0600)
+	require.NoError(t, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040507, db)
+	require.NoError(t, err)
+
+	version, err = GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040507), version)
+}
+
+func TestCreateMigrationNumbered(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"00001_first.sql":  [2]string{"SELECT 1;", "SELECT 1;"},
+		"00002_second.sql": [2]string{"SELECT 2;", "SELECT 2;"},
+	})
+	defer mdCleanup()
+
+	// 10:04:05 in UTC
+	ts := time.Date(2001, 2, 3, 4, 4, 5, 0, time.FixedZone("", -6*60*60))
+
+	path, err := CreateMigrationNumbered("third", "sql", md, ts, TimestampNumbering)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(md, "20010203100405_third.sql"), path)
+
+	path, err = CreateMigrationNumbered("fourth", "go", md, ts, SequentialNumbering)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(md, "20010203100406_fourth.go"), path)
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "func Down_20010203100406(")
+
+	err = os.Remove(filepath.Join(md, "20010203100405_third.sql"))
+	require.NoError(t, err)
+	err = os.Remove(path)
+	require.NoError(t, err)
+
+	path, err = CreateMigrationNumbered("third", "sql", md, ts, SequentialNumbering)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(md, "00003_third.sql"), path)
+
+	migrations, err := CollectMigrations(md)
+	require.NoError(t, err)
+	sort.Sort(migrationSorter(migrations))
+	require.Len(t, migrations, 3)
+	assert.Equal(t, int64(3), migrations[2].Version)
+}
+
+func TestCreateMigrationWithTemplate(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{})
+	defer mdCleanup()
+
+	tmpl := template.Must(template.New("").Parse("-- {{ .Name }}, version {{ .Version }}\n-- +goose Up\nBEGIN;\n"))
+	ts := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+
+	path, err := CreateMigrationWithTemplate("add_users", "sql", md, ts, TimestampNumbering, tmpl)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(md, "20010203040506_add_users.sql"), path)
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "-- add_users, version 20010203040506\n-- +goose Up\nBEGIN;\n", string(b))
+
+	// nil is the built in template
+	path, err = CreateMigrationWithTemplate("add_posts", "go", md, ts, SequentialNumbering, nil)
+	require.NoError(t, err)
+	b, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "func Up_20010203040507(")
+
+	assert.Nil(t, DefaultMigrationTemplate("rb"))
+}
+
+func TestCreateMigrationVersion(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"00001_first.sql": [2]string{"SELECT 1;", "SELECT 1;"},
+	})
+	defer mdCleanup()
+
+	path, err := CreateMigrationVersion("third", "sql", md, 3, SequentialNumbering, nil)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(md, "00003_third.sql"), path)
+
+	path, err = CreateMigrationVersion("fourth", "go", md, 0, SequentialNumbering, nil)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(md, "00004_fourth.go"), path)
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "func Up_4(")
+
+	_, err = CreateMigrationVersion("again", "sql", md, 3, SequentialNumbering, nil)
+	assert.EqualError(t, err, "version 3 is already taken by "+filepath.Join(md, "00003_third.sql"))
+}
+
+func TestFix(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"00001_setup.sql":          [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_two.sql":   [2]string{"INSERT INTO test(value) VALUES('two');", "DELETE FROM test WHERE value = 'two';"},
+		"20010203040506_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+		"20010203040508_three.sql": [2]string{"INSERT INTO test(value) VALUES('three');", "DELETE FROM test WHERE value = 'three';"},
+	})
+	defer mdCleanup()
+	goSrc := "package main\n\nfunc Up_20010203040509(txn *sql.Tx) {\n}\n\nfunc Down_20010203040509(txn *sql.Tx) {\n}\n"
+	err := ioutil.WriteFile(filepath.Join(md, "20010203040509_four.go"), []byte(goSrc), 0600)
+	require.NoError(t, err)
+
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040506, db)
+	require.NoError(t, err)
+
+	_, err = FixOnDb(conf, md, db)
+	assert.EqualError(t, err, "not renumbering migrations, some are already applied: 20010203040506_one.sql")
+
+	renamed, err := Fix(md)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		filepath.Join(md, "20010203040506_one.sql"):   filepath.Join(md, "00002_one.sql"),
+		filepath.Join(md, "20010203040507_two.sql"):   filepath.Join(md, "00003_two.sql"),
+		filepath.Join(md, "20010203040508_three.sql"): filepath.Join(md, "00004_three.sql"),
+		filepath.Join(md, "20010203040509_four.go"):   filepath.Join(md, "00005_four.go"),
+	}, renamed)
+
+	b, err := ioutil.ReadFile(filepath.Join(md, "00005_four.go"))
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "func Up_5(")
+	assert.Contains(t, string(b), "func Down_5(")
+
+	renamed, err = Fix(md)
+	require.NoError(t, err)
+	assert.Empty(t, renamed)
+}
+
+func TestFix_baseFS(t *testing.T) {
+	SetBaseFS(fstest.MapFS{
+		"20010203040506_one.sql": &fstest.MapFile{},
+	})
+	defer SetBaseFS(nil)
+
+	_, err := Fix(".")
+	assert.EqualError(t, err, "cannot renumber migrations read through SetBaseFS, only those on the OS filesystem")
+}
+
+func TestFix_goVars(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"00001_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+	})
+	defer mdCleanup()
+	goSrc := "package main\n\nvar Up_20010203040509 func(txn *sql.Tx) = up\n\nvar Down_20010203040509 func(txn *sql.Tx)\n\nfunc up(txn *sql.Tx) {\n}\n"
+	err := ioutil.WriteFile(filepath.Join(md, "20010203040509_four.go"), []byte(goSrc), 0600)
+	require.NoError(t, err)
+
+	_, err = Fix(md)
+	require.NoError(t, err)
+
+	b, err := ioutil.ReadFile(filepath.Join(md, "00002_four.go"))
+	require.NoError(t, err)
+	assert.Equal(t, "package main\n\nvar Up_2 func(txn *sql.Tx) = up\n\nvar Down_2 func(txn *sql.Tx)\n\nfunc up(txn *sql.Tx) {\n}\n", string(b))
+}
+
+func TestUpByOne_outOfOrder(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+		"20010203040508_two.sql":   [2]string{"INSERT INTO test(value) VALUES('two');", "DELETE FROM test WHERE value = 'two';"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	// apply the last migration before the middle one
+	err = os.Rename(filepath.Join(md, "20010203040507_one.sql"), filepath.Join(md, "20010203040507_one.sql_"))
+	require.NoError(t, err)
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040508, db)
+	require.NoError(t, err)
+	err = os.Rename(filepath.Join(md, "20010203040507_one.sql_"), filepath.Join(md, "20010203040507_one.sql"))
+	require.NoError(t, err)
+
+	version, err := UpByOne(conf, conf.MigrationsDir, db)
+	assert.Equal(t, ErrNoNextVersion, err)
+	assert.Equal(t, int64(20010203040508), version)
+
+	conf.AllowOutOfOrder = true
+	version, err = UpByOne(conf, conf.MigrationsDir, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040507), version)
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM test WHERE value = 'one'").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	version, err = UpByOne(conf, conf.MigrationsDir, db)
+	assert.Equal(t, ErrNoNextVersion, err)
+	assert.Equal(t, int64(20010203040508), version)
+}
+
+type bufLogger struct {
+	bytes.Buffer
+}
+
+func (l *bufLogger) Fatalf(format string, v ...interface{}) { panic(fmt.Sprintf(format, v...)) }
+func (l *bufLogger) Printf(format string, v ...interface{}) { fmt.Fprintf(l, format, v...) }
+func (l *bufLogger) Println(v ...interface{})               { fmt.Fprintln(l, v...) }
+
+func TestSetLogger(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	l := &bufLogger{}
+	SetLogger(l)
+	defer SetLogger(nil)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040506, db)
+	require.NoError(t, err)
+
+	assert.Contains(t, l.String(), "goose: migrating db, current version: 0, target: 20010203040506\n")
+	assert.Contains(t, l.String(), "CREATE TABLE test(value VARCHAR(20));")
+	assert.Contains(t, l.String(), "OK    20010203040506_setup.sql\n")
+}
+
+func TestCollectMigrations_duplicate(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_first.sql":  [2]string{"SELECT 1;", "SELECT 1;"},
+		"20010203040506_second.sql": [2]string{"SELECT 2;", "SELECT 2;"},
+	})
+	defer mdCleanup()
+
+	_, err := CollectMigrations(md)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "more than one file specifies the migration for version 20010203040506")
+}
+
+func TestCollectMigrations_duplicateAcrossTypes(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"0014_first.sql":  [2]string{"SELECT 1;", "SELECT 1;"},
+		"00014_second.go": [2]string{"", ""},
+	})
+	defer mdCleanup()
+
+	_, err := CollectMigrations(md)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "more than one file specifies the migration for version 14")
+	assert.Contains(t, err.Error(), "0014_first.sql")
+	assert.Contains(t, err.Error(), "00014_second.go")
+
+	// nor can the commands find a version to migrate to
+	_, err = GetMostRecentDBVersion(md)
+	assert.Error(t, err)
+	_, err = GetPreviousDBVersion(md, 14)
+	assert.Error(t, err)
+
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 14, db)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "more than one file specifies")
+	_, _, err = readMigrationsStatus(context.Background(), conf, conf.MigrationsDir, DBExecutor(db))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "more than one file specifies")
+}
+
+func TestRunMigrationsOnDb_substituteVars(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE ${TABLE}(value VARCHAR(20));", "DROP TABLE ${TABLE};"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO $TABLE(value) VALUES('$VALUE');", "DELETE FROM $TABLE;"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:         getSqlite3Driver(t),
+		MigrationsDir:  md,
+		SubstituteVars: true,
+		Vars:           map[string]string{"TABLE": "test", "VALUE": "one"},
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040507, db)
+	require.NoError(t, err)
+
+	var value string
+	err = db.QueryRow("SELECT value FROM test").Scan(&value)
+	require.NoError(t, err)
+	assert.Equal(t, "one", value)
+}
+
+func testPending(t *testing.T, driver DBDriver) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040508_two.sql":   [2]string{"INSERT INTO test(value) VALUES('two');", "DELETE FROM test WHERE value = 'two';"},
+	})
+	defer mdCleanup()
+	goSrc := "package main\n\nfunc Up_20010203040507(txn *sql.Tx) {\n}\n"
+	err := ioutil.WriteFile(filepath.Join(md, "20010203040507_one.go"), []byte(goSrc), 0600)
+	require.NoError(t, err)
+
+	conf := &DBConf{
+		Driver:        driver,
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	db.Exec("DROP TABLE goose_db_version")
+	db.Exec("DROP TABLE test")
+
+	pending, err := Pending(conf, conf.MigrationsDir, db)
+	require.NoError(t, err)
+	require.Len(t, pending, 3)
+	assert.Equal(t, int64(20010203040506), pending[0].Version)
+	assert.Equal(t, filepath.Join(md, "20010203040506_setup.sql"), pending[0].Source)
+	assert.Equal(t, "sql", pending[0].Type())
+	assert.Equal(t, int64(20010203040507), pending[1].Version)
+	assert.Equal(t, "go", pending[1].Type())
+	assert.Equal(t, int64(20010203040508), pending[2].Version)
+
+	// Pending must not have created the version table
+	_, err = GetDBVersionOnDb(conf, db)
+	assert.Equal(t, ErrTableDoesNotExist, err)
+
+	// skip the go migration, as it can't be run here
+	err = os.Remove(filepath.Join(md, "20010203040507_one.go"))
+	require.NoError(t, err)
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040506, db)
+	require.NoError(t, err)
+
+	pending, err = Pending(conf, conf.MigrationsDir, db)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, int64(20010203040508), pending[0].Version)
+}
+func TestPending_sqlite3(t *testing.T) {
+	testPending(t, getSqlite3Driver(t))
+}
+func TestPending_mysql(t *testing.T) {
+	testPending(t, getMysqlDriver(t))
+}
+func TestPending_postgres(t *testing.T) {
+	testPending(t, getPostgresDriver(t))
+}
+func TestPending_redshift(t *testing.T) {
+	testPending(t, getRedshiftDriver(t))
+}
+
+func testDiff(t *testing.T, driver DBDriver) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+		"20010203040508_two.sql":   [2]string{"INSERT INTO test(value) VALUES('two');", "DELETE FROM test WHERE value = 'two';"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        driver,
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	db.Exec("DROP TABLE goose_db_version")
+	db.Exec("DROP TABLE test")
+
+	orphans, pending, err := Diff(conf, conf.MigrationsDir, db)
+	require.NoError(t, err)
+	assert.Empty(t, orphans)
+	assert.Equal(t, []int64{20010203040506, 20010203040507, 20010203040508}, pending)
+
+	// Diff must not have created the version table
+	_, err = GetDBVersionOnDb(conf, db)
+	assert.Equal(t, ErrTableDoesNotExist, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040507, db)
+	require.NoError(t, err)
+
+	err = os.Remove(filepath.Join(md, "20010203040507_one.sql"))
+	require.NoError(t, err)
+	orphans, pending, err = Diff(conf, conf.MigrationsDir, db)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{20010203040507}, orphans)
+	assert.Equal(t, []int64{20010203040508}, pending)
+
+	l := &bufLogger{}
+	SetLogger(l)
+	defer SetLogger(nil)
+	conf.AllowMissing = []int64{20010203040507}
+	orphans, pending, err = Diff(conf, conf.MigrationsDir, db)
+	require.NoError(t, err)
+	assert.Empty(t, orphans)
+	assert.Equal(t, []int64{20010203040508}, pending)
+	assert.Contains(t, l.String(), "as DBConf.AllowMissing accepts them: [20010203040507]\n")
+}
+func TestDiff_sqlite3(t *testing.T) {
+	testDiff(t, getSqlite3Driver(t))
+}
+func TestDiff_mysql(t *testing.T) {
+	testDiff(t, getMysqlDriver(t))
+}
+func TestDiff_postgres(t *testing.T) {
+	testDiff(t, getPostgresDriver(t))
+}
+func TestDiff_redshift(t *testing.T) {
+	testDiff(t, getRedshiftDriver(t))
+}
+
+func TestMigrationStatus(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+	})
+	defer mdCleanup()
+	appliedAt := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+		Clock:         func() time.Time { return appliedAt },
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	// nothing is applied before the version table exists
+	version, applied, at, err := MigrationStatus(conf, conf.MigrationsDir, "20010203040506_setup.sql", db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040506), version)
+	assert.False(t, applied)
+	assert.Nil(t, at)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040506, db)
+	require.NoError(t, err)
+
+	version, applied, at, err = MigrationStatus(conf, conf.MigrationsDir, filepath.Join(md, "20010203040506_setup.sql"), db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040506), version)
+	assert.True(t, applied)
+	require.NotNil(t, at)
+	assert.True(t, appliedAt.Equal(*at), "applied at %v", at)
+
+	_, applied, _, err = MigrationStatus(conf, conf.MigrationsDir, "20010203040507_one.sql", db)
+	require.NoError(t, err)
+	assert.False(t, applied)
+
+	_, _, _, err = MigrationStatus(conf, conf.MigrationsDir, "20010203040508_two.sql", db)
+	assert.EqualError(t, err, "no migration 20010203040508_two.sql in "+md)
+}
+
+func TestSetSchema(t *testing.T) {
+	defer SetSchema("")
+
+	for _, name := range []string{"tenant 42", "tenant;DROP TABLE x", "42tenant", "a.b"} {
+		assert.Error(t, SetSchema(name), name)
+	}
+	assert.Equal(t, "goose_db_version", TableName())
+
+	require.NoError(t, SetSchema("tenant_42"))
+	assert.Equal(t, "tenant_42.goose_db_version", TableName())
+
+	require.NoError(t, SetSchema(""))
+	assert.Equal(t, "goose_db_version", TableName())
+}
+
+func testRunMigrationsOnDb_schema(t *testing.T, driver DBDriver) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        driver,
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+	defer SetSchema("")
+
+	// a mixed case name is kept as it is, by the migrations too
+	for _, schema := range []string{"goose_test", "Goose_Test"} {
+		quoted := `"` + schema + `"`
+		db.Exec("DROP SCHEMA " + quoted + " CASCADE")
+		_, err = db.Exec("CREATE SCHEMA " + quoted)
+		require.NoError(t, err)
+
+		require.NoError(t, SetSchema(schema))
+		err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040506, db)
+		require.NoError(t, err)
+
+		// both the version table and the migration's table are in the schema
+		var count int
+		err = db.QueryRow("SELECT COUNT(*) FROM " + quoted + ".goose_db_version WHERE version_id = 20010203040506").Scan(&count)
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+		err = db.QueryRow("SELECT COUNT(*) FROM " + quoted + ".test").Scan(&count)
+		require.NoError(t, err)
+		assert.Equal(t, 0, count)
+
+		_, err = db.Exec("DROP SCHEMA " + quoted + " CASCADE")
+		require.NoError(t, err)
+	}
+}
+func TestRunMigrationsOnDb_schema_postgres(t *testing.T) {
+	testRunMigrationsOnDb_schema(t, getPostgresDriver(t))
+}
+func TestRunMigrationsOnDb_schema_redshift(t *testing.T) {
+	testRunMigrationsOnDb_schema(t, getRedshiftDriver(t))
+}
+
+// a mysql schema is a database, which only the version table is kept in
+func TestRunMigrationsOnDb_qualifiedTable_mysql(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        getMysqlDriver(t),
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	db.Exec("DROP TABLE test")
+	db.Exec("DROP DATABASE goose_test")
+	_, err = db.Exec("CREATE DATABASE goose_test")
+	require.NoError(t, err)
+	defer db.Exec("DROP DATABASE goose_test")
+
+	require.NoError(t, SetTableName("goose_test.goose_db_version"))
+	defer SetSchema("")
+	defer SetTableName(defaultTableName)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040506, db)
+	require.NoError(t, err)
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM `goose_test`.`goose_db_version` WHERE version_id = 20010203040506").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	version, err := GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040506), version)
+	err = db.QueryRow("SELECT COUNT(*) FROM test").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestRunMigrationsOnSchemas_postgres(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        getPostgresDriver(t),
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	for _, schema := range []string{"tenant_a", "tenant_b", "tenant_missing"} {
+		db.Exec("DROP SCHEMA " + schema + " CASCADE")
+	}
+	for _, schema := range []string{"tenant_a", "tenant_b"} {
+		_, err = db.Exec("CREATE SCHEMA " + schema)
+		require.NoError(t, err)
+		defer db.Exec("DROP SCHEMA " + schema + " CASCADE")
+	}
+
+	results, err := RunMigrationsOnSchemas(conf, conf.MigrationsDir, 20010203040507, db,
+		[]string{"tenant_a", "tenant_missing", "tenant_b"}, ContinueOnSchemaFailure)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "schema tenant_missing: ")
+	require.Len(t, results, 3)
+	assert.NoError(t, results["tenant_a"])
+	assert.Error(t, results["tenant_missing"])
+	assert.NoError(t, results["tenant_b"])
+	assert.Equal(t, "goose_db_version", TableName())
+
+	for _, schema := range []string{"tenant_a", "tenant_b"} {
+		var value string
+		err = db.QueryRow("SELECT value FROM " + schema + ".test").Scan(&value)
+		require.NoError(t, err)
+		assert.Equal(t, "one", value)
+	}
+}
+
+// searchSqlite3Dialect passes for a dialect that runs migrations in the
+// schema; main, sqlite's own name for the database, is the only one
+type searchSqlite3Dialect struct {
+	Sqlite3Dialect
+}
+
+func (searchSqlite3Dialect) searchPathSql(schema string, local bool) (string, string) {
+	return "SELECT 1;", ""
+}
+
+func TestRunMigrationsOnSchemas_policy(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	// migrations would all run in the one database
+	schemas := []string{"bad-name", "main"}
+	results, err := RunMigrationsOnSchemas(conf, conf.MigrationsDir, 20010203040506, db, schemas, ContinueOnSchemaFailure)
+	assert.ErrorContains(t, err, "cannot migrate schemas in turn")
+	assert.Nil(t, results)
+
+	conf.Driver.Dialect = searchSqlite3Dialect{}
+	results, err = RunMigrationsOnSchemas(conf, conf.MigrationsDir, 20010203040506, db, schemas, StopOnSchemaFailure)
+	assert.EqualError(t, err, `schema bad-name: invalid schema name "bad-name": must be letters, digits and underscores, not starting with a digit`)
+	assert.Len(t, results, 1)
+	assert.Error(t, results["bad-name"])
+
+	results, err = RunMigrationsOnSchemas(conf, conf.MigrationsDir, 20010203040506, db, schemas, ContinueOnSchemaFailure)
+	assert.Error(t, err)
+	assert.Len(t, results, 2)
+	assert.NoError(t, results["main"])
+	assert.Equal(t, "goose_db_version", TableName())
+
+	version, err := GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040506), version)
+}
+
+func TestSetTableName(t *testing.T) {
+	defer SetTableName(defaultTableName)
+
+	for _, name := range []string{"", "versions; DROP TABLE post", "app versions", "1versions", "a.b.versions", ".versions", "app.", `"versions"`} {
+		assert.Error(t, SetTableName(name), name)
+	}
+	assert.Equal(t, "goose_db_version", TableName())
+
+	// a qualified name sets the schema too
+	defer SetSchema("")
+	require.NoError(t, SetTableName("migrations_db.app_versions"))
+	assert.Equal(t, "migrations_db.app_versions", TableName())
+	assert.Equal(t, "`migrations_db`.`app_versions`", (&MySqlDialect{}).quotedTableName())
+	require.NoError(t, SetSchema(""))
+
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	require.NoError(t, SetTableName("app_versions"))
+	assert.Equal(t, "app_versions", TableName())
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040506, db)
+	require.NoError(t, err)
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM app_versions WHERE version_id = 20010203040506").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// the default table is untouched
+	require.NoError(t, SetTableName(defaultTableName))
+	_, err = GetDBVersionOnDb(conf, db)
+	assert.Equal(t, ErrTableDoesNotExist, err)
+}
+
+func TestRecordAppliedBy(t *testing.T) {
+	defer SetAppliedBy("")
+
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:          getSqlite3Driver(t),
+		MigrationsDir:   md,
+		RecordAppliedBy: true,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	SetAppliedBy("ci-job-42")
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040506, db)
+	require.NoError(t, err)
+
+	var by, source string
+	err = db.QueryRow("SELECT applied_by, source_file FROM goose_db_version WHERE version_id = 20010203040506").Scan(&by, &source)
+	require.NoError(t, err)
+	assert.Equal(t, "ci-job-42", by)
+	assert.Equal(t, "20010203040506_setup.sql", source)
+
+	// the initial row has no migration to record
+	var initial sql.NullString
+	err = db.QueryRow("SELECT source_file FROM goose_db_version WHERE version_id = 0").Scan(&initial)
+	require.NoError(t, err)
+	assert.False(t, initial.Valid)
+
+	host, _ := os.Hostname()
+	SetAppliedBy("")
+	assert.Equal(t, host, appliedBy)
+}
+
+func TestTimeoutSql(t *testing.T) {
+	set, reset := PostgresDialect{}.timeoutSql(5*time.Second, 1500*time.Millisecond, true)
+	assert.Equal(t, []string{"SET LOCAL statement_timeout = 5000;", "SET LOCAL lock_timeout = 1500;"}, set)
+	assert.Empty(t, reset)
+
+	set, reset = PostgresDialect{}.timeoutSql(0, time.Second, false)
+	assert.Equal(t, []string{"SET lock_timeout = 1000;"}, set)
+	assert.Equal(t, []string{"RESET lock_timeout;"}, reset)
+
+	set, reset = MySqlDialect{}.timeoutSql(5*time.Second, 1500*time.Millisecond, true)
+	assert.Equal(t, []string{"SET SESSION innodb_lock_wait_timeout = 2;"}, set)
+	assert.Equal(t, []string{"SET SESSION innodb_lock_wait_timeout = DEFAULT;"}, reset)
+
+	set, _ = timeouts(&DBConf{Driver: getSqlite3Driver(t), StatementTimeout: time.Second}, true)
+	assert.Empty(t, set)
+}
+
+func testRunMigrationsOnDb_statementTimeout(t *testing.T, driver DBDriver) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));\nSELECT pg_sleep(2);", "DROP TABLE test;"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:           driver,
+		MigrationsDir:    md,
+		StatementTimeout: 100 * time.Millisecond,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	db.Exec("DROP TABLE goose_db_version")
+	db.Exec("DROP TABLE test")
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040506, db)
+	require.Error(t, err)
+
+	// the whole migration was rolled back
+	_, err = db.Exec("SELECT * FROM test")
+	assert.Error(t, err)
+	version, err := GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), version)
+}
+func TestRunMigrationsOnDb_statementTimeout_postgres(t *testing.T) {
+	testRunMigrationsOnDb_statementTimeout(t, getPostgresDriver(t))
+}
+
+func TestRunMigrationsOnDb_migrationError(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_bad.sql":   [2]string{"INSERT INTO test(value) VALUES('one');\nINSERT INTO nosuchtable(value) VALUES('two');", ""},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040507, db)
+	require.Error(t, err)
+
+	var me *MigrationError
+	require.True(t, errors.As(err, &me), err.Error())
+	assert.Equal(t, int64(20010203040507), me.Version)
+	assert.Equal(t, filepath.Join(md, "20010203040507_bad.sql"), me.Source)
+	assert.Equal(t, "INSERT INTO nosuchtable(value) VALUES('two');\n", me.Statement)
+	assert.NotNil(t, errors.Unwrap(me))
+	assert.Contains(t, err.Error(), "20010203040507_bad.sql: ")
+	assert.Contains(t, err.Error(), "nosuchtable")
+}
+
+func TestCollectMigrations_split(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"00001_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+	})
+	defer mdCleanup()
+
+	files := map[string]string{
+		"00002_one.up.sql":   "INSERT INTO test(value) VALUES('one');\n",
+		"00002_one.down.sql": "DELETE FROM test WHERE value = 'one';\n",
+		"00003_two.up.sql":   "INSERT INTO test(value) VALUES('two');\n",
+	}
+	for name, body := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(md, name), []byte(body), 0600))
+	}
+
+	migrations, err := CollectMigrations(md)
+	require.NoError(t, err)
+	sort.Sort(migrationSorter(migrations))
+	require.Len(t, migrations, 3)
+	assert.Equal(t, "", migrations[0].DownSource)
+	assert.Equal(t, filepath.Join(md, "00002_one.up.sql"), migrations[1].Source)
+	assert.Equal(t, filepath.Join(md, "00002_one.down.sql"), migrations[1].DownSource)
+	assert.Equal(t, "sql", migrations[1].Type())
+	assert.Equal(t, "", migrations[2].DownSource)
+
+	// a .down.sql file must have an .up.sql file of the same name
+	require.NoError(t, ioutil.WriteFile(filepath.Join(md, "00003_three.down.sql"), nil, 0600))
+	_, err = CollectMigrations(md)
+	assert.Error(t, err)
+
+	require.NoError(t, os.Rename(filepath.Join(md, "00003_three.down.sql"), filepath.Join(md, "00004_four.down.sql")))
+	_, err = CollectMigrations(md)
+	assert.EqualError(t, err, filepath.Join(md, "00004_four.down.sql")+" has no matching .up.sql file")
+}
+
+func TestRunMigrationsOnDb_split(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"00001_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+
+	files := map[string]string{
+		"00002_one.up.sql":   "INSERT INTO test(value) VALUES('one');\n",
+		"00002_one.down.sql": "DELETE FROM test WHERE value = 'one';\n",
+		"00003_two.up.sql":   "INSERT INTO test(value) VALUES('two');\n",
+	}
+	for name, body := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(md, name), []byte(body), 0600))
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 3, db)
+	require.NoError(t, err)
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM test").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// without a .down.sql file, there is no Down section
+	_, err = DownTo(conf, conf.MigrationsDir, 1, db)
+	assert.Error(t, err)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(md, "00003_two.down.sql"), []byte("DELETE FROM test WHERE value = 'two';\n"), 0600))
+
+	_, err = DownTo(conf, conf.MigrationsDir, 1, db)
+	require.NoError(t, err)
+
+	err = db.QueryRow("SELECT COUNT(*) FROM test").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestGetMigrations(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_first.sql":  [2]string{"SELECT 1;", "SELECT 1;"},
+		"20010203040507_second.go":  [2]string{"", ""},
+		"20010203040508_third.sql":  [2]string{"SELECT 3;", "SELECT 3;"},
+		"20010203040509_fourth.sql": [2]string{"SELECT 4;", "SELECT 4;"},
+	})
+	defer mdCleanup()
+
+	ms, err := GetMigrations(md, 20010203040506, 20010203040508)
+	require.NoError(t, err)
+	require.Len(t, ms, 2)
+	assert.Equal(t, int64(20010203040507), ms[0].Version)
+	assert.Equal(t, "go", ms[0].Type())
+	assert.Equal(t, int64(-1), ms[0].Previous)
+	assert.Equal(t, int64(20010203040508), ms[0].Next)
+	assert.Equal(t, int64(20010203040507), ms[1].Previous)
+	assert.Equal(t, int64(-1), ms[1].Next)
+
+	ms, err = GetMigrations(md, 0, math.MaxInt64)
+	require.NoError(t, err)
+	assert.Len(t, ms, 4)
+
+	for _, name := range []string{"first.sql", "x_second.sql", "00000_zero.go"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(md, name), nil, 0600))
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(md, "README.md"), nil, 0600))
+	_, err = GetMigrations(md, 0, math.MaxInt64)
+	require.Error(t, err)
+	for _, name := range []string{"first.sql", "x_second.sql", "00000_zero.go"} {
+		assert.Contains(t, err.Error(), name)
+	}
+	assert.NotContains(t, err.Error(), "README.md")
+}
+
+func TestRunMigrationsOnDb_noCreateVersionTable(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:               getSqlite3Driver(t),
+		MigrationsDir:        md,
+		NoCreateVersionTable: true,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040506, db)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrTableDoesNotExist), err.Error())
+	assert.Contains(t, err.Error(), `CREATE TABLE "goose_db_version"`)
+
+	// provisioned by hand, the table starts at version 0
+	_, err = db.Exec(VersionTableSQL(conf))
+	require.NoError(t, err)
+	_, err = db.Exec(VersionInsertSQL(conf), 0, true)
+	require.NoError(t, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040506, db)
+	require.NoError(t, err)
+	version, err := GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040506), version)
+}
+
+func testVersionHistory(t *testing.T, driver DBDriver) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        driver,
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	db.Exec("DROP TABLE goose_db_version")
+	db.Exec("DROP TABLE test")
+
+	_, err = VersionHistory(conf, db)
+	assert.Equal(t, ErrTableDoesNotExist, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040507, db)
+	require.NoError(t, err)
+	_, err = DownTo(conf, conf.MigrationsDir, 20010203040506, db)
+	require.NoError(t, err)
+
+	history, err := VersionHistory(conf, db)
+	require.NoError(t, err)
+	require.Len(t, history, 4)
+	for i, want := range []VersionRecord{
+		{Version: 0, IsApplied: true},
+		{Version: 20010203040506, IsApplied: true},
+		{Version: 20010203040507, IsApplied: true},
+		{Version: 20010203040507, IsApplied: false},
+	} {
+		assert.Equal(t, want.Version, history[i].Version)
+		assert.Equal(t, want.IsApplied, history[i].IsApplied)
+		assert.False(t, history[i].TStamp.IsZero())
+	}
+}
+func TestVersionHistory_sqlite3(t *testing.T) {
+	testVersionHistory(t, getSqlite3Driver(t))
+}
+func TestVersionHistory_mysql(t *testing.T) {
+	testVersionHistory(t, getMysqlDriver(t))
+}
+func TestVersionHistory_postgres(t *testing.T) {
+	testVersionHistory(t, getPostgresDriver(t))
+}
+func TestVersionHistory_redshift(t *testing.T) {
+	testVersionHistory(t, getRedshiftDriver(t))
+}
+
+func TestVersionHistory_clock(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+	})
+	defer mdCleanup()
+	start := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	calls := 0
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+		Clock: func() time.Time {
+			calls++
+			return start.Add(time.Duration(calls) * time.Hour)
+		},
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	db.Exec("DROP TABLE goose_db_version")
+	db.Exec("DROP TABLE test")
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040507, db)
+	require.NoError(t, err)
+
+	history, err := VersionHistory(conf, db)
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	assert.True(t, start.Add(time.Hour).Equal(history[1].TStamp), "%v", history[1].TStamp)
+	assert.True(t, start.Add(2*time.Hour).Equal(history[2].TStamp), "%v", history[2].TStamp)
+}
+
+// orders the version table by tstamp, as if its ids were not allocated in order
+type tstampSqlite3Dialect struct {
+	Sqlite3Dialect
+}
+
+func (tstampSqlite3Dialect) VersionOrderColumn() string { return "tstamp" }
+
+func TestVersionOrderColumn(t *testing.T) {
+	conf := &DBConf{
+		Driver: getSqlite3Driver(t),
+	}
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	_, err = EnsureDBVersion(conf, db)
+	require.NoError(t, err)
+
+	// applied, then rolled back, with the rows out of id order
+	_, err = db.Exec("INSERT INTO goose_db_version (version_id, is_applied, tstamp) VALUES (1, 0, '2001-02-03 04:05:07')")
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO goose_db_version (version_id, is_applied, tstamp) VALUES (1, 1, '2001-02-03 04:05:06')")
+	require.NoError(t, err)
+
+	version, err := GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), version)
+
+	conf.Driver.Dialect = tstampSqlite3Dialect{}
+	version, err = GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), version)
+}
+
+func TestMigrationRecords(t *testing.T) {
+	conf := &DBConf{
+		Driver:               getSqlite3Driver(t),
+		NoCreateVersionTable: true,
+	}
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = migrationRecords(ctx, conf, DBExecutor(db))
+	assert.True(t, errors.Is(err, ErrTableDoesNotExist))
+
+	// created, then read again
+	conf.NoCreateVersionTable = false
+	records, err := migrationRecords(ctx, conf, DBExecutor(db))
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.True(t, records[0].IsApplied)
+
+	// applied then rolled back within the same second, and applied again
+	for _, q := range []string{
+		"INSERT INTO goose_db_version (version_id, is_applied, tstamp) VALUES (1, 1, '2001-02-03 04:05:06')",
+		"INSERT INTO goose_db_version (version_id, is_applied, tstamp) VALUES (1, 0, '2001-02-03 04:05:06')",
+		"INSERT INTO goose_db_version (version_id, is_applied, tstamp) VALUES (2, 1, '2001-02-03 04:05:06')",
+		"INSERT INTO goose_db_version (version_id, is_applied, tstamp) VALUES (2, 0, '2001-02-03 04:05:06')",
+		"INSERT INTO goose_db_version (version_id, is_applied, tstamp) VALUES (2, 1, '2001-02-03 04:05:06')",
+	} {
+		_, err = db.Exec(q)
+		require.NoError(t, err)
+	}
+
+	records, err = readMigrationRecords(ctx, conf, DBExecutor(db))
+	require.NoError(t, err)
+	assert.False(t, records[1].IsApplied)
+	assert.True(t, records[2].IsApplied)
+	assert.Equal(t, int64(2), currentVersion(records))
+
+	migrations := []*Migration{{Version: 1}, {Version: 2}, {Version: 3}}
+	setMigrationsStatus(migrations, records)
+	assert.False(t, migrations[0].IsApplied)
+	assert.True(t, migrations[1].IsApplied)
+	assert.False(t, migrations[2].IsApplied)
+}
+
+func TestRunMigrationsOnDb_singleTransaction(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+		"20010203040508_bad.sql":   [2]string{"INSERT INTO nosuchtable(value) VALUES('two');", ""},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:            getSqlite3Driver(t),
+		MigrationsDir:     md,
+		SingleTransaction: true,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040508, db)
+	require.Error(t, err)
+
+	// all or nothing
+	version, err := GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), version)
+	_, err = db.Exec("SELECT * FROM test")
+	assert.Error(t, err)
+
+	// a NO TRANSACTION migration commits the migrations before it
+	err = ioutil.WriteFile(filepath.Join(md, "20010203040508_bad.sql"),
+		[]byte("-- +goose NO TRANSACTION\n-- +goose Up\nINSERT INTO test(value) VALUES('two');\nINSERT INTO nosuchtable(value) VALUES('two');\n"), 0600)
+	require.NoError(t, err)
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040508, db)
+	require.Error(t, err)
+
+	version, err = GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040507), version)
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM test").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	err = ioutil.WriteFile(filepath.Join(md, "20010203040508_bad.sql"), []byte("-- +goose Up\nDELETE FROM test;\n"), 0600)
+	require.NoError(t, err)
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040508, db)
+	require.NoError(t, err)
+	version, err = GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040508), version)
+}
+
+// deadlockSqlite3Dialect takes a missing later table to be a deadlock
+type deadlockSqlite3Dialect struct {
+	Sqlite3Dialect
+}
+
+func (deadlockSqlite3Dialect) isDeadlockError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "no such table: later")
+}
+
+func TestRunMigrationsOnDb_deadlockRetry(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_insert.sql": [2]string{"INSERT INTO later(value) VALUES('one');", "DELETE FROM later;"},
+		"20010203040507_again.sql":  [2]string{"INSERT INTO never(value) VALUES('two');", "DELETE FROM never;"},
+	})
+	defer mdCleanup()
+
+	// on a file, so that the table can be created on a connection of its
+	// own while the migration's transaction is open
+	driver := getSqlite3Driver(t)
+	driver.Dialect = deadlockSqlite3Dialect{}
+	driver.OpenStr = filepath.Join(t.TempDir(), "goose.db")
+	conf := &DBConf{
+		Driver:        driver,
+		MigrationsDir: md,
+		DeadlockRetry: RetryPolicy{Attempts: 3},
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// the first attempt fails, and the retry finds the table there
+	var attempts int
+	conf.OnStatement = func(version int64, index, total int, sql string) {
+		if version == 20010203040506 {
+			if attempts++; attempts == 2 {
+				_, err := db.Exec("CREATE TABLE later(value VARCHAR(20))")
+				require.NoError(t, err)
+			}
+		}
+	}
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040506, db)
+	require.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM later").Scan(&count))
+	assert.Equal(t, 1, count)
+
+	// other errors are not retried
+	attempts = 0
+	conf.OnStatement = func(version int64, index, total int, sql string) { attempts++ }
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040507, db)
+	assert.ErrorContains(t, err, "no such table: never")
+	assert.Equal(t, 1, attempts)
+}
+
+// readOnlySqlite3Dialect tells when sqlite refused to write a database
+// opened read-only, as the replica dialects do. sqlite cannot be asked
+// whether it is, so its probe says so only if replica is set.
+type readOnlySqlite3Dialect struct {
+	Sqlite3Dialect
+	replica bool
+}
+
+func (readOnlySqlite3Dialect) isReadOnlyError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "attempt to write a readonly database")
+}
+
+func (d readOnlySqlite3Dialect) readOnlySql() string {
+	if d.replica {
+		return "SELECT 1"
+	}
+	return "SELECT 0"
+}
+
+func TestRunMigrationsOnDb_readOnly(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+	})
+	defer mdCleanup()
+
+	path := filepath.Join(t.TempDir(), "goose.db")
+	driver := getSqlite3Driver(t)
+	driver.Dialect = readOnlySqlite3Dialect{}
+	driver.OpenStr = path
+	conf := &DBConf{
+		Driver:        driver,
+		MigrationsDir: md,
+	}
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+	_, err = db.Exec("CREATE TABLE other(value VARCHAR(20))")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	ro := *conf
+	ro.Driver.OpenStr = "file:" + path + "?mode=ro"
+	roDB, err := OpenDBFromDBConf(&ro)
+	require.NoError(t, err)
+	defer roDB.Close()
+
+	// creating the version table fails, before any migration runs
+	err = RunMigrationsOnDb(&ro, ro.MigrationsDir, 20010203040506, roDB)
+	assert.ErrorIs(t, err, ErrReadOnlyDatabase)
+	assert.ErrorContains(t, err, "attempt to write a readonly database")
+
+	// with the table there, a database the dialect can tell is read-only
+	// fails before any migration starts
+	db, err = OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+	require.NoError(t, CreateVersionTable(conf, db))
+	require.NoError(t, db.Close())
+
+	started := 0
+	ro.OnMigrationStart = func(version int64, source string, direction Direction) { started++ }
+	ro.OnStatement = func(version int64, i, n int, stmt string) { t.Errorf("ran %q", stmt) }
+	ro.Driver.Dialect = readOnlySqlite3Dialect{replica: true}
+	err = RunMigrationsOnDb(&ro, ro.MigrationsDir, 20010203040506, roDB)
+	assert.ErrorIs(t, err, ErrReadOnlyDatabase)
+	var me *MigrationError
+	assert.False(t, errors.As(err, &me))
+	assert.Equal(t, 0, started)
+
+	// and one it cannot tell about fails on the first migration
+	ro.OnStatement = nil
+	ro.Driver.Dialect = readOnlySqlite3Dialect{}
+	err = RunMigrationsOnDb(&ro, ro.MigrationsDir, 20010203040506, roDB)
+	assert.ErrorIs(t, err, ErrReadOnlyDatabase)
+	require.ErrorAs(t, err, &me)
+	assert.Equal(t, int64(20010203040506), me.Version)
+}
+
+// a dialect that, like spanner, cannot run DDL in a transaction
+type noTxDDLSqlite3Dialect struct {
+	Sqlite3Dialect
+}
+
+func (noTxDDLSqlite3Dialect) ddlOutsideTransaction() {}
+
+func TestNonTransactionalDDL(t *testing.T) {
+	for _, d := range []SqlDialect{&SpannerDialect{}, &MySqlDialect{}, &MariaDBDialect{}, &OracleDialect{}, &ClickHouseDialect{}, &VerticaDialect{}} {
+		assert.False(t, ddlInTransaction(d), "%T", d)
+	}
+	for _, d := range []SqlDialect{&PostgresDialect{}, &RedshiftDialect{}, &Sqlite3Dialect{}, &SqlServerDialect{}, &CockroachDialect{}, &YugabyteDialect{}, &DuckDBDialect{}} {
+		assert.True(t, ddlInTransaction(d), "%T", d)
+	}
+
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+	})
+	defer mdCleanup()
+	driver := getSqlite3Driver(t)
+	driver.Dialect = noTxDDLSqlite3Dialect{}
+	conf := &DBConf{
+		Driver:        driver,
+		MigrationsDir: md,
+	}
+
+	_, useTx, err := readSQLStatements(conf, filepath.Join(md, "20010203040506_setup.sql"), DirectionUp)
+	require.NoError(t, err)
+	assert.False(t, useTx)
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040506, db)
+	require.NoError(t, err)
+
+	version, err := GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040506), version)
+}
+
+func TestSqlite3DialectMissingTable(t *testing.T) {
+	d := Sqlite3Dialect{}
+	for _, msg := range []string{
+		"no such table: goose_db_version",
+		"SQLITE_UNKNOWN: SQLite error: no such table: goose_db_version",
+		"SQLite error: No such table: goose_db_version",
+	} {
+		assert.True(t, d.isMissingTableError(errors.New(msg)), msg)
+	}
+	assert.False(t, d.isMissingTableError(errors.New("no such column: checksum")))
+	assert.False(t, d.isMissingTableError(nil))
+}
+
+func testRunMigrationsOnTx(t *testing.T, driver DBDriver) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        driver,
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	db.Exec("DROP TABLE goose_db_version")
+	db.Exec("DROP TABLE test")
+
+	// nothing is committed by goose
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	err = RunMigrationsOnTx(conf, conf.MigrationsDir, 20010203040507, tx)
+	require.NoError(t, err)
+	var value string
+	require.NoError(t, tx.QueryRow("SELECT value FROM test").Scan(&value))
+	assert.Equal(t, "one", value)
+	require.NoError(t, tx.Rollback())
+
+	_, err = GetDBVersionOnDb(conf, db)
+	assert.Equal(t, ErrTableDoesNotExist, err)
+
+	tx, err = db.Begin()
+	require.NoError(t, err)
+	err = RunMigrationsOnTx(conf, conf.MigrationsDir, 20010203040507, tx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	version, err := GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040507), version)
+
+	// down again, with the version table in place
+	tx, err = db.Begin()
+	require.NoError(t, err)
+	err = RunMigrationsOnTx(conf, conf.MigrationsDir, 20010203040506, tx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	version, err = GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040506), version)
+
+	// Go migrations can't take part, and nothing runs
+	err = ioutil.WriteFile(filepath.Join(md, "20010203040508_go.go"), []byte("package main\n"), 0600)
+	require.NoError(t, err)
+	tx, err = db.Begin()
+	require.NoError(t, err)
+	defer tx.Rollback()
+	err = RunMigrationsOnTx(conf, conf.MigrationsDir, 20010203040508, tx)
+	assert.EqualError(t, err, "20010203040508_go.go is a Go migration, which cannot run in the caller's transaction")
+	err = tx.QueryRow("SELECT value FROM test").Scan(&value)
+	assert.Equal(t, sql.ErrNoRows, err)
+}
+func TestRunMigrationsOnTx_sqlite3(t *testing.T) {
+	testRunMigrationsOnTx(t, getSqlite3Driver(t))
+}
+func TestRunMigrationsOnTx_postgres(t *testing.T) {
+	testRunMigrationsOnTx(t, getPostgresDriver(t))
+}
+
+// a dialect that, like redshift, has no savepoints
+type noSavepointsSqlite3Dialect struct {
+	Sqlite3Dialect
+}
+
+func (noSavepointsSqlite3Dialect) noSavepoints() {}
+
+func TestRunMigrationsOnTx_noSavepoints(t *testing.T) {
+	for _, d := range []SqlDialect{&RedshiftDialect{}, &SqlServerDialect{}} {
+		_, ok := d.(savepointless)
+		assert.True(t, ok, "%T", d)
+	}
+
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+	})
+	defer mdCleanup()
+	driver := getSqlite3Driver(t)
+	driver.Dialect = noSavepointsSqlite3Dialect{}
+	conf := &DBConf{
+		Driver:        driver,
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	defer tx.Rollback()
+	err = RunMigrationsOnTx(conf, conf.MigrationsDir, 20010203040506, tx)
+	assert.EqualError(t, err, "goose.noSavepointsSqlite3Dialect has no savepoints to read the version table under, so cannot migrate in a transaction")
+
+	// not even the version table was created
+	_, err = tx.Exec("SELECT * FROM goose_db_version")
+	assert.Error(t, err)
+}
+
+func TestRunMigrationsOnDb_confirmDown(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+	})
+	defer mdCleanup()
+	var asked []string
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+		ConfirmDown: func(version int64, source string) (bool, error) {
+			asked = append(asked, fmt.Sprintf("%d %s", version, filepath.Base(source)))
+			return version != 20010203040506, nil
+		},
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040507, db)
+	require.NoError(t, err)
+	assert.Empty(t, asked)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 0, db)
+	assert.True(t, errors.Is(err, ErrDownNotConfirmed))
+	var me *MigrationError
+	require.True(t, errors.As(err, &me))
+	assert.Equal(t, int64(20010203040506), me.Version)
+	assert.Equal(t, []string{"20010203040507 20010203040507_one.sql", "20010203040506 20010203040506_setup.sql"}, asked)
+
+	version, err := GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040506), version)
+	_, err = db.Exec("SELECT * FROM test")
+	assert.NoError(t, err)
+}
+
+func TestRunMigrations_downNotApplied(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DROP TABLE test;"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040506, db)
+	require.NoError(t, err)
+
+	migrations, err := CollectMigrations(md)
+	require.NoError(t, err)
+	sort.Sort(migrationSorter(migrations))
+	_, err = runMigrations(context.Background(), conf, DBExecutor(db), migrations[1:], DirectionDown)
+	assert.True(t, errors.Is(err, ErrNotApplied))
+
+	// the Down section never ran
+	_, err = db.Exec("SELECT * FROM test")
+	assert.NoError(t, err)
+	version, err := GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040506), version)
+}
+
+func TestRunMigrations_emptyUp(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_empty.sql": [2]string{"-- nothing here", "DROP TABLE test;"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040507, db)
+	assert.Error(t, err)
+	version, err := GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040506), version)
+
+	// as is an empty half of a split migration
+	err = ioutil.WriteFile(filepath.Join(md, "20010203040507_empty.sql"), nil, 0600)
+	require.NoError(t, err)
+	require.NoError(t, os.Rename(filepath.Join(md, "20010203040507_empty.sql"), filepath.Join(md, "20010203040507_empty.up.sql")))
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040507, db)
+	assert.Error(t, err)
+
+	err = ioutil.WriteFile(filepath.Join(md, "20010203040507_empty.up.sql"), []byte("-- +goose NO-OP\n"), 0600)
+	require.NoError(t, err)
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040507, db)
+	require.NoError(t, err)
+	version, err = GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040507), version)
+
+	// an Up only for other dialects does nothing on this one
+	script := "-- +goose Up\n-- +goose Dialect postgres\nCREATE EXTENSION pgcrypto;\n-- +goose Dialect end\n\n-- +goose Down\nSELECT 1;\n"
+	err = ioutil.WriteFile(filepath.Join(md, "20010203040508_pg.sql"), []byte(script), 0600)
+	require.NoError(t, err)
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040508, db)
+	require.NoError(t, err)
+	version, err = GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040508), version)
+
+	err = ioutil.WriteFile(filepath.Join(md, "20010203040509_pg.up.sql"), []byte("-- +goose Dialect postgres\nCREATE EXTENSION pgcrypto;\n-- +goose Dialect end\n"), 0600)
+	require.NoError(t, err)
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040509, db)
+	require.NoError(t, err)
+
+	// a file emptied by a bad deploy fails the run, rather than the process
+	err = ioutil.WriteFile(filepath.Join(md, "20010203040510_truncated.sql"), nil, 0600)
+	require.NoError(t, err)
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040510, db)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "20010203040510_truncated.sql: no Up/Down annotations found")
+	version, err = GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040509), version)
+}
+
+func TestDownTo_irreversible(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+	})
+	defer mdCleanup()
+	script := "package main\n\nfunc Up_20010203040507(txn *sql.Tx) error { return nil }\n\nvar Down_20010203040507 func(*sql.Tx) error\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(md, "20010203040507_purge.go"), []byte(script), 0600))
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	_, err = Baseline(conf, conf.MigrationsDir, 20010203040507, db)
+	require.NoError(t, err)
+
+	_, err = DownTo(conf, conf.MigrationsDir, Zero, db)
+	var irreversible ErrIrreversible
+	require.True(t, errors.As(err, &irreversible), "%v", err)
+	assert.Equal(t, int64(20010203040507), irreversible.Version)
+
+	// nor does the runner roll it back when asked to directly
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040506, db)
+	assert.True(t, errors.As(err, &irreversible), "%v", err)
+	version, err := GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040507), version)
+}
+
+func TestRunMigrations_statementRewriter(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test;"},
+		"20010203040508_two.sql":   [2]string{"INSERT INTO test(value) VALUES('two');\nINSERT INTO test(value) VALUES('bad');", "DELETE FROM test;"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+		StatementRewriter: func(dialect SqlDialect, sql string) (string, error) {
+			if _, ok := dialect.(Sqlite3Dialect); !ok {
+				return "", fmt.Errorf("unexpected dialect %T", dialect)
+			}
+			if strings.Contains(sql, "bad") {
+				return "", errors.New("bad statement")
+			}
+			return strings.Replace(sql, "'one'", "'uno'", 1), nil
+		},
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040507, db)
+	require.NoError(t, err)
+
+	var value string
+	err = db.QueryRow("SELECT value FROM test").Scan(&value)
+	require.NoError(t, err)
+	assert.Equal(t, "uno", value)
+
+	// none of a migration runs if any of its statements cannot be rewritten
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040508, db)
+	assert.Error(t, err)
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM test").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	version, err := GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040507), version)
+}
+
+func TestMigrateTo(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+		"20010203040508_bad.sql":   [2]string{"INSERT INTO nosuchtable(value) VALUES('bad');", ""},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:            getSqlite3Driver(t),
+		MigrationsDir:     md,
+		SingleTransaction: true,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	// the batch rolls back as a whole, so nothing went through
+	res, err := MigrateTo(conf, conf.MigrationsDir, 20010203040508, db)
+	require.Error(t, err)
+	assert.Equal(t, Result{}, res)
+
+	conf.SingleTransaction = false
+	res, err = MigrateTo(conf, conf.MigrationsDir, 20010203040508, db)
+	require.Error(t, err)
+	assert.Equal(t, Result{Applied: []int64{20010203040506, 20010203040507}, FinalVersion: 20010203040507}, res)
+
+	res, err = MigrateTo(conf, conf.MigrationsDir, 20010203040507, db)
+	require.NoError(t, err)
+	assert.Equal(t, Result{FinalVersion: 20010203040507}, res)
+
+	res, err = MigrateTo(conf, conf.MigrationsDir, 0, db)
+	require.NoError(t, err)
+	assert.Equal(t, Result{RolledBack: []int64{20010203040507, 20010203040506}}, res)
+}
+
+// an Executor that counts the transactions begun and connections reserved
+// on it, and keeps the queries run on it outside of them
+type recordingExecutor struct {
+	Executor
+	begun, conns int
+	queries      []string
+}
+
+func (e *recordingExecutor) QueryContext(ctx context.Context, query string, args ...interface{}) (Rows, error) {
+	e.queries = append(e.queries, query)
+	return e.Executor.QueryContext(ctx, query, args...)
+}
+
+func (e *recordingExecutor) Begin(ctx context.Context) (Tx, error) {
+	e.begun++
+	return e.Executor.Begin(ctx)
+}
+
+func (e *recordingExecutor) Conn(ctx context.Context) (Conn, error) {
+	e.conns++
+	return e.Executor.Conn(ctx)
+}
+
+func TestMigrateToExecutor(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+	e := &recordingExecutor{Executor: DBExecutor(db)}
+
+	res, err := MigrateToExecutor(conf, conf.MigrationsDir, 20010203040507, e)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Applied: []int64{20010203040506, 20010203040507}, FinalVersion: 20010203040507}, res)
+
+	// the version table was created in a transaction of e's, and each
+	// migration run on a connection of e's
+	assert.Equal(t, 1, e.begun)
+	assert.Equal(t, 2, e.conns)
+	require.NotEmpty(t, e.queries)
+	assert.Contains(t, e.queries[0], "goose_db_version")
+
+	var value string
+	require.NoError(t, db.QueryRow("SELECT value FROM test").Scan(&value))
+	assert.Equal(t, "one", value)
+
+	require.NoError(t, RunMigrationsOnExecutor(conf, conf.MigrationsDir, 0, e))
+	version, err := GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), version)
+}
+
+func TestUpTo_Latest(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	version, err := UpTo(conf, conf.MigrationsDir, Latest, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040507), version)
+
+	// down to Latest is a no-op
+	version, err = DownTo(conf, conf.MigrationsDir, Latest, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040507), version)
+
+	version, err = DownTo(conf, conf.MigrationsDir, Zero, db)
+	require.NoError(t, err)
+	assert.Equal(t, Zero, version)
+
+	res, err := MigrateTo(conf, conf.MigrationsDir, Latest, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040507), res.FinalVersion)
+}
+
+func TestRunMigrationsOnDb_hooks(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_two.sql":   [2]string{"INSERT INTO test(value) VALUES('one');\nINSERT INTO test(value) VALUES('two');", ""},
+		"20010203040508_bad.sql":   [2]string{"INSERT INTO nosuchtable(value) VALUES('three');", ""},
+	})
+	defer mdCleanup()
+	var calls []string
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+		OnMigrationStart: func(version int64, source string, direction Direction) {
+			calls = append(calls, fmt.Sprintf("start %d %s %s", version, filepath.Base(source), direction))
+		},
+		OnMigrationDone: func(version int64, source string, direction Direction, err error) {
+			calls = append(calls, fmt.Sprintf("done %d %t", version, err == nil))
+		},
+		OnStatement: func(version int64, index, total int, sql string) {
+			// statements keep the comments before them, such as the Up annotation
+			lines := strings.Split(strings.TrimSpace(sql), "\n")
+			calls = append(calls, fmt.Sprintf("statement %d %d/%d %s", version, index, total, lines[len(lines)-1]))
+		},
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040508, db)
+	require.Error(t, err)
+	assert.Equal(t, []string{
+		"start 20010203040506 20010203040506_setup.sql up",
+		"statement 20010203040506 0/1 CREATE TABLE test(value VARCHAR(20));",
+		"done 20010203040506 true",
+		"start 20010203040507 20010203040507_two.sql up",
+		"statement 20010203040507 0/2 INSERT INTO test(value) VALUES('one');",
+		"statement 20010203040507 1/2 INSERT INTO test(value) VALUES('two');",
+		"done 20010203040507 true",
+		"start 20010203040508 20010203040508_bad.sql up",
+		"statement 20010203040508 0/1 INSERT INTO nosuchtable(value) VALUES('three');",
+		"done 20010203040508 false",
+	}, calls)
+}
+
+type recordingObserver []string
+
+func (o *recordingObserver) ObserveMigration(version int64, direction string, d time.Duration, err error) {
+	*o = append(*o, fmt.Sprintf("%d %s %t %t", version, direction, d > 0, err == nil))
+}
+
+func TestRunMigrationsOnDb_metrics(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_bad.sql":   [2]string{"INSERT INTO nosuchtable(value) VALUES('one');", ""},
+	})
+	defer mdCleanup()
+	var observed recordingObserver
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+		Metrics:       &observed,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040507, db)
+	require.Error(t, err)
+	_, err = DownTo(conf, conf.MigrationsDir, Zero, db)
+	require.NoError(t, err)
+	assert.Equal(t, recordingObserver{
+		"20010203040506 up true true",
+		"20010203040507 up true false",
+		"20010203040506 down true true",
+	}, observed)
+}
+
+func TestCollectMigrations_metadata(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+	})
+	defer mdCleanup()
+
+	header := "-- goose: description=Add the one row\n" +
+		"-- goose: author = someone@example.com\n" +
+		"-- a plain comment\n" +
+		"-- goose: no value here\n" +
+		"--goose: ticket=ABC-1\n" +
+		"\n" +
+		"-- goose: ticket=OPS-42\n" +
+		"-- +goose Up\n" +
+		"INSERT INTO test(value) VALUES('one');\n" +
+		"-- goose: after=the header\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(md, "20010203040507_one.sql"), []byte(header), 0600))
+
+	migrations, err := CollectMigrations(md)
+	require.NoError(t, err)
+	sort.Sort(migrationSorter(migrations))
+	require.Len(t, migrations, 2)
+
+	assert.Equal(t, "", migrations[0].Description)
+	assert.Nil(t, migrations[0].Metadata)
+
+	assert.Equal(t, "Add the one row", migrations[1].Description)
+	assert.Equal(t, map[string]string{
+		"description": "Add the one row",
+		"author":      "someone@example.com",
+		"ticket":      "OPS-42",
+	}, migrations[1].Metadata)
+
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, StatusJSON(conf, conf.MigrationsDir, db, &buf))
+	var statuses []map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &statuses))
+	require.Len(t, statuses, 2)
+	assert.NotContains(t, statuses[0], "description")
+	assert.NotContains(t, statuses[0], "metadata")
+	assert.Equal(t, "Add the one row", statuses[1]["description"])
+	assert.Equal(t, "OPS-42", statuses[1]["metadata"].(map[string]interface{})["ticket"])
+
+	pending, err := Pending(conf, conf.MigrationsDir, db)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	assert.Equal(t, "Add the one row", pending[1].Description)
+
+	// the header is only comments, so the migration runs as before
+	require.NoError(t, RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040507, db))
+}
+
+func TestCreateVersionTable(t *testing.T) {
+	conf := &DBConf{
+		Driver:               getSqlite3Driver(t),
+		NoCreateVersionTable: true,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	// a second call finds the table there and leaves it be
+	require.NoError(t, CreateVersionTable(conf, db))
+	require.NoError(t, CreateVersionTable(conf, db))
+
+	history, err := VersionHistory(conf, db)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, int64(0), history[0].Version)
+	assert.True(t, history[0].IsApplied)
+	assert.True(t, conf.NoCreateVersionTable)
+}
+
+func TestCreateVersionTable_lostRace(t *testing.T) {
+	conf := &DBConf{Driver: getSqlite3Driver(t)}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	// as if another migrator had just created the table, and not yet
+	// inserted its initial row, which is then left to it
+	_, err = db.Exec(VersionTableSQL(conf))
+	require.NoError(t, err)
+	require.NoError(t, createVersionTable(context.Background(), conf, DBExecutor(db)))
+
+	history, err := VersionHistory(conf, db)
+	require.NoError(t, err)
+	assert.Empty(t, history)
+}
+
+func TestSetVersionTableSQL(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+
+	assert.Error(t, SetVersionTableSQL("CREATE TABLE migrations (id INTEGER)"))
+	assert.Equal(t, Sqlite3Dialect{}.createVersionTableSql(), VersionTableSQL(conf))
+
+	query := strings.Replace(VersionTableSQL(conf), "source_file TEXT NULL", "source_file TEXT NULL,\n note TEXT NULL", 1)
+	require.NoError(t, SetVersionTableSQL(query))
+	defer SetVersionTableSQL("")
+	assert.Equal(t, query, VersionTableSQL(conf))
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// the versions are still read and recorded as usual
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040506, db)
+	require.NoError(t, err)
+	version, err := GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040506), version)
+
+	var columns int
+	err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('goose_db_version') WHERE name = 'note'").Scan(&columns)
+	require.NoError(t, err)
+	assert.Equal(t, 1, columns)
+
+	// renamed since, the table the query creates would not be the one read
+	require.NoError(t, SetTableName("other_db_version"))
+	defer SetTableName("goose_db_version")
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040506, db)
+	assert.ErrorContains(t, err, "does not create other_db_version")
+}
+
+func TestBaseline(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040507_one.sql":   [2]string{"INSERT INTO test(value) VALUES('one');", "DELETE FROM test WHERE value = 'one';"},
+		"20010203040508_two.sql":   [2]string{"INSERT INTO test(value) VALUES('two');", "DELETE FROM test WHERE value = 'two';"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	// the schema is already there
+	_, err = db.Exec("CREATE TABLE test(value VARCHAR(20))")
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO test(value) VALUES('one')")
+	require.NoError(t, err)
+
+	_, err = Baseline(conf, conf.MigrationsDir, 20010203040505, db)
+	assert.Error(t, err)
+
+	baselined, err := Baseline(conf, conf.MigrationsDir, 20010203040507, db)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{20010203040506, 20010203040507}, baselined)
+
+	version, err := GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040507), version)
+
+	_, err = Baseline(conf, conf.MigrationsDir, 20010203040508, db)
+	assert.EqualError(t, err, "cannot baseline, migrations are applied already up to version 20010203040507")
+
+	// only the rest runs
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040508, db)
+	require.NoError(t, err)
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM test").Scan(&count))
+	assert.Equal(t, 2, count)
+}
+
+func TestWithRetry(t *testing.T) {
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	policy := RetryPolicy{Attempts: 3, Backoff: time.Millisecond}
+	ctx := context.Background()
+
+	// transient errors are retried until the attempts run out
+	var calls int
+	err := withRetry(ctx, policy, func() error {
+		calls++
+		return reset
+	})
+	assert.Equal(t, reset, err)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	err = withRetry(ctx, policy, func() error {
+		calls++
+		if calls < 2 {
+			return driver.ErrBadConn
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+
+	// other errors are not
+	for _, e := range []error{
+		ErrTableDoesNotExist,
+		&pq.Error{Code: "42601"}, // syntax_error
+		errors.New("no such column: checksum"),
+	} {
+		calls = 0
+		err = withRetry(ctx, policy, func() error {
+			calls++
+			return e
+		})
+		assert.Equal(t, e, err)
+		assert.Equal(t, 1, calls)
+	}
+
+	assert.True(t, isTransient(&pq.Error{Code: "57P01"}))  // admin_shutdown
+	assert.True(t, isTransient(&pq.Error{Code: "08006"}))  // connection_failure
+	assert.False(t, isTransient(&pq.Error{Code: "42P01"})) // undefined_table
+
+	// no policy, no retries
+	calls = 0
+	withRetry(ctx, RetryPolicy{}, func() error {
+		calls++
+		return reset
+	})
+	assert.Equal(t, 1, calls)
+}
+
+func TestRunMigrationsOnDb_reservedTableName(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+	})
+	defer mdCleanup()
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+	}
+	require.NoError(t, SetTableName("order"))
+	defer SetTableName(defaultTableName)
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040506, db)
+	require.NoError(t, err)
+
+	version, err := GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040506), version)
+}
+
+func TestRunMigrationsOnDb_migrationType(t *testing.T) {
+	md, mdCleanup := setupMigrationsDir(map[string][2]string{
+		"20010203040506_setup.sql": [2]string{"CREATE TABLE test(value VARCHAR(20));", "DROP TABLE test;"},
+		"20010203040508_two.sql":   [2]string{"INSERT INTO test(value) VALUES('two');", "DELETE FROM test WHERE value = 'two';"},
+	})
+	defer mdCleanup()
+	err := ioutil.WriteFile(filepath.Join(md, "20010203040507_one.go"), []byte("package main\n"), 0600)
+	require.NoError(t, err)
+	conf := &DBConf{
+		Driver:        getSqlite3Driver(t),
+		MigrationsDir: md,
+		MigrationType: "sql",
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	err = RunMigrationsOnDb(conf, conf.MigrationsDir, 20010203040508, db)
+	require.NoError(t, err)
+
+	// the go migration is left pending, below the current version
+	_, migrations, err := migrationsWithStatus(context.Background(), conf, md, DBExecutor(db))
+	require.NoError(t, err)
+	require.Len(t, migrations, 3)
+	assert.True(t, migrations[0].IsApplied)
+	assert.False(t, migrations[1].IsApplied)
+	assert.True(t, migrations[2].IsApplied)
+
+	version, err := GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040508), version)
+
+	_, err = UpByOne(conf, conf.MigrationsDir, db)
+	assert.Equal(t, ErrNoNextVersion, err)
+
+	rolledBack, err := Reset(conf, conf.MigrationsDir, db)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{20010203040508, 20010203040506}, rolledBack)
+}
+
+func TestPrepareVersionInsert(t *testing.T) {
+	ctx := context.Background()
+	conf := &DBConf{
+		Driver:          getSqlite3Driver(t),
+		RecordChecksums: true,
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	require.NoError(t, err)
+
+	// with no version table yet, the inserts go unprepared
+	ins := prepareVersionInsert(ctx, conf, DBExecutor(db))
+	assert.Nil(t, ins.stmt)
+	ins.close()
+
+	require.NoError(t, createVersionTable(ctx, conf, DBExecutor(db)))
+	ins = prepareVersionInsert(ctx, conf, DBExecutor(db))
+	require.NotNil(t, ins.stmt)
+	defer ins.close()
+
+	// the statement is reused across transactions
+	for _, v := range []int64{1, 2} {
+		txn, err := db.Begin()
+		require.NoError(t, err)
+		require.NoError(t, ins.exec(ctx, conf, sqlTx{txn}, DirectionUp, v, MigrationRecord{Checksum: "abc"}))
+		require.NoError(t, txn.Commit())
+	}
+
+	var n int
+	err = db.QueryRow("SELECT COUNT(*) FROM goose_db_version WHERE checksum = 'abc'").Scan(&n)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	version, err := GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), version)
+}
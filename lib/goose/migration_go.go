@@ -2,14 +2,15 @@ package goose
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
-	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
+	"regexp"
 )
 
 type templateData struct {
@@ -19,12 +20,13 @@ type templateData struct {
 	Direction  Direction
 	Func       string
 	InsertStmt string
-}
-
-func init() {
-	gob.Register(PostgresDialect{})
-	gob.Register(MySqlDialect{})
-	gob.Register(Sqlite3Dialect{})
+	Checksum   string
+	Source     string   // filename of the migration
+	AppliedBy  string   // as set with SetAppliedBy
+	Schema     string   // as set with SetSchema
+	Table      string   // as set with SetTableName
+	SearchPath string   // statement setting the search path to Schema, if any
+	Timeouts   []string // statements applying the DBConf timeouts, if any
 }
 
 //
@@ -34,11 +36,34 @@ func init() {
 // original .go migration, and execute it via `go run` along
 // with a main() of our own creation.
 //
-func runGoMigration(conf *DBConf, path string, version int64, direction Direction) error {
+// The migration funcs are handed the transaction the version table is
+// updated in, so the two commit or roll back together. They may be
+// func(context.Context, *sql.Tx) error, func(*sql.Tx) error, or, as
+// goose used to require, func(*sql.Tx); returning an error rolls back.
+// Returning ErrSkip rolls back too, but records the version regardless.
+//
+// A func may instead be declared as a nil variable of one of these types.
+// A nil Up func records the version without running anything, and a nil
+// Down func makes the migration irreversible, so rolling it back fails
+// with ErrIrreversible. A func that isn't declared at all is an error.
+//
+func runGoMigration(ctx context.Context, conf *DBConf, path string, version int64, direction Direction) error {
+	b, e := fs.ReadFile(baseFS, path)
+	if e != nil {
+		return e
+	}
+	fn := goMigrationFunc(direction, version)
+	switch declared, isNil := goMigrationFuncDecl(b, fn); {
+	case !declared:
+		return fmt.Errorf("%s declares no %s func", filepath.Base(path), fn)
+	case isNil && direction == DirectionDown:
+		return ErrIrreversible{Version: version}
+	}
+
 	// everything gets written to a temp dir, and zapped afterwards
 	d, e := ioutil.TempDir("", "goose")
 	if e != nil {
-		log.Fatal(e)
+		return e
 	}
 	defer os.RemoveAll(d)
 
@@ -57,31 +82,64 @@ func runGoMigration(conf *DBConf, path string, version int64, direction Directio
 	}
 	sb.WriteString("}")
 
+	var checksum string
+	if conf.RecordChecksums {
+		if checksum, e = migrationChecksum(path); e != nil {
+			return e
+		}
+	}
+
 	td := &templateData{
 		Version:    version,
 		Import:     conf.Driver.Import,
 		Conf:       sb.String(),
 		Direction:  direction,
-		Func:       fmt.Sprintf("%v_%v", strings.ToTitle(direction.String()), version),
+		Func:       goMigrationFunc(direction, version),
 		InsertStmt: conf.Driver.Dialect.insertVersionSql(),
+		Checksum:   checksum,
+		Source:     filepath.Base(path),
+		AppliedBy:  appliedBy,
+		Schema:     schemaName,
+		Table:      tableName,
 	}
+	td.SearchPath, _ = searchPath(conf, true)
+	// the session ends with the process, so nothing needs resetting
+	td.Timeouts, _ = timeouts(conf, true)
 
 	main, e := writeTemplateToFile(filepath.Join(d, "goose_main.go"), goMigrationDriverTemplate, td)
 	if e != nil {
-		log.Fatal(e)
+		return e
 	}
 
 	outpath := filepath.Join(d, filepath.Base(path))
 	if _, e = copyFile(outpath, path); e != nil {
-		log.Fatal(e)
+		return e
 	}
 
-	cmd := exec.Command("go", "run", main, outpath)
+	cmd := exec.CommandContext(ctx, "go", "run", main, outpath)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	if e = cmd.Run(); e != nil {
-		log.Fatal("`go run` failed: ", e)
+		return fmt.Errorf("`go run` failed: %v", e)
 	}
 
 	return nil
 }
+
+// report whether the Go migration script declares the func fn, either as a
+// func or as a variable, and if so whether it is a variable left nil
+func goMigrationFuncDecl(script []byte, fn string) (declared, isNil bool) {
+	name := regexp.QuoteMeta(fn)
+	if regexp.MustCompile(`(?m)^var\s+` + name + `\s+func\([^=\n]*$`).Match(script) {
+		return true, true
+	}
+	return regexp.MustCompile(`(?m)^(func|var)\s+` + name + `\b`).Match(script), false
+}
+
+// name of the func that runs the Go migration for version in direction
+func goMigrationFunc(direction Direction, version int64) string {
+	if direction == DirectionUp {
+		return fmt.Sprintf("Up_%d", version)
+	}
+	return fmt.Sprintf("Down_%d", version)
+}
@@ -0,0 +1,108 @@
+package goose
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoMigrationDriverTemplate(t *testing.T) {
+	for _, direction := range []Direction{DirectionUp, DirectionDown} {
+		td := &templateData{
+			Version:   20010203040506,
+			Import:    "github.com/mattn/go-sqlite3",
+			Conf:      "[]byte{ 0x01, }",
+			Func:      goMigrationFunc(direction, 20010203040506),
+			Checksum:  "abc",
+			Source:    "20010203040506_test.go",
+			AppliedBy: "ci",
+			Table:     "goose_db_version",
+		}
+		td.Direction = direction
+
+		var buf bytes.Buffer
+		err := goMigrationDriverTemplate.Execute(&buf, td)
+		require.NoError(t, err)
+
+		_, err = parser.ParseFile(token.NewFileSet(), "goose_main.go", buf.Bytes(), 0)
+		require.NoError(t, err, buf.String())
+
+		src := buf.String()
+		name := strings.Title(direction.String())
+		assert.Contains(t, src, "var migration interface{} = "+name+"_20010203040506\n")
+		assert.Contains(t, src, "goose.Direction"+name+", 20010203040506, goose.MigrationRecord{")
+		assert.Contains(t, src, "Checksum: \"abc\",")
+		assert.Contains(t, src, "Source:   \"20010203040506_test.go\",")
+		assert.Contains(t, src, "goose.SetAppliedBy(\"ci\")")
+		assert.Contains(t, src, "if errors.Is(err, goose.ErrSkip) {")
+		assert.Contains(t, src, "log.Printf(\"goose: skipped %s: %v\", \"20010203040506_test.go\", err)")
+	}
+}
+
+func TestGoMigrationTemplate(t *testing.T) {
+	var buf bytes.Buffer
+	err := goMigrationTemplate.Execute(&buf, MigrationTemplateData{Version: 20010203040506, Name: "test"})
+	require.NoError(t, err)
+
+	_, err = parser.ParseFile(token.NewFileSet(), "20010203040506_test.go", buf.Bytes(), 0)
+	require.NoError(t, err, buf.String())
+	assert.Contains(t, buf.String(), "func Up_20010203040506(ctx context.Context, txn *sql.Tx) error {")
+	assert.Contains(t, buf.String(), "func Down_20010203040506(ctx context.Context, txn *sql.Tx) error {")
+}
+
+func TestGoMigrationDriverTemplate_schema(t *testing.T) {
+	td := &templateData{
+		Version:    20010203040506,
+		Import:     "github.com/lib/pq",
+		Conf:       "[]byte{ 0x01, }",
+		Direction:  DirectionUp,
+		Func:       goMigrationFunc(DirectionUp, 20010203040506),
+		Schema:     "tenant_42",
+		Table:      "app_versions",
+		SearchPath: `SET LOCAL search_path TO "tenant_42";`,
+	}
+
+	var buf bytes.Buffer
+	err := goMigrationDriverTemplate.Execute(&buf, td)
+	require.NoError(t, err)
+
+	_, err = parser.ParseFile(token.NewFileSet(), "goose_main.go", buf.Bytes(), 0)
+	require.NoError(t, err, buf.String())
+	assert.Contains(t, buf.String(), `goose.SetSchema("tenant_42")`)
+	assert.Contains(t, buf.String(), `goose.SetTableName("app_versions")`)
+	assert.Contains(t, buf.String(), `txn.ExecContext(ctx, "SET LOCAL search_path TO \"tenant_42\";")`)
+}
+
+func TestGoMigrationFuncDecl(t *testing.T) {
+	script := []byte(`package main
+
+func Up_1(ctx context.Context, txn *sql.Tx) error {
+	return nil
+}
+
+// cannot be undone
+var Down_1 func(context.Context, *sql.Tx) error
+
+var Up_2 = Up_1
+`)
+
+	for _, test := range []struct {
+		fn              string
+		declared, isNil bool
+	}{
+		{"Up_1", true, false},
+		{"Down_1", true, true},
+		{"Up_2", true, false},
+		{"Down_2", false, false},
+		{"Up_10", false, false},
+	} {
+		declared, isNil := goMigrationFuncDecl(script, test.fn)
+		assert.Equal(t, test.declared, declared, test.fn)
+		assert.Equal(t, test.isNil, isNil, test.fn)
+	}
+}
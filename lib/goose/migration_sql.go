@@ -3,33 +3,215 @@ package goose
 import (
 	"bufio"
 	"bytes"
-	"database/sql"
+	"context"
+	"errors"
+	"fmt"
 	"io"
-	"log"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
 	"strings"
 )
 
 const sqlCmdPrefix = "-- +goose "
 
-// Checks the line to see if the line has a statement-ending semicolon
-// or if the line contains a double-dash comment.
-func endsWithSemicolon(line string) bool {
+// prefix of the key=value metadata lines of an SQL migration's header
+const sqlMetadataPrefix = "-- goose:"
 
-	prev := ""
-	scanner := bufio.NewScanner(strings.NewReader(line))
-	scanner.Split(bufio.ScanWords)
+// read the metadata from the header of the SQL migration at path, the
+// comment lines it starts with, from lines of the form
+//
+//	-- goose: key=value
+//
+// Other comment lines in the header, and metadata lines without an "=",
+// are ignored. If no key is set, the map is nil.
+func readSQLMetadata(path string) (map[string]string, error) {
+	f, err := baseFS.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
 
+	var metadata map[string]string
+	scanner := bufio.NewScanner(f)
 	for scanner.Scan() {
-		word := scanner.Text()
-		if strings.HasPrefix(word, "--") {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
 			break
 		}
-		prev = word
+
+		kv := strings.TrimPrefix(line, sqlMetadataPrefix)
+		if kv == line {
+			continue
+		}
+		i := strings.Index(kv, "=")
+		if i == -1 {
+			continue
+		}
+		key := strings.TrimSpace(kv[:i])
+		if key == "" {
+			continue
+		}
+		if metadata == nil {
+			metadata = map[string]string{}
+		}
+		metadata[key] = strings.TrimSpace(kv[i+1:])
 	}
 
-	return strings.HasSuffix(prev, ";")
+	return metadata, scanner.Err()
+}
+
+// Checks the line to see if the line has a statement-ending semicolon
+// or if the line contains a double-dash comment.
+func endsWithSemicolon(line string, d SqlDialect) bool {
+	q := newSQLQuoting(d)
+	return q.endsStatement(line)
+}
+
+// dollarTag matches the opening of a postgres dollar quoted string,
+// $$ or $tag$.
+var dollarTag = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+// sqlQuoting tracks the string literals, quoted identifiers, dollar quotes
+// and block comments of a SQL script fed to it line by line, as they can
+// span lines, so that semicolons within them are not taken as ending a
+// statement.
+type sqlQuoting struct {
+	end   string // what closes the quote or comment we are in, if any
+	delim string // what ends a statement, if not a semicolon
+	cut   int    // where the delimiter starts in the line that ended a statement
+
+	backslashes bool // whether single quoted strings take backslash escapes, as in mysql
+	escaping    bool // whether the quote we are in takes them
+}
+
+// sqlQuoting for scripts in d's SQL, which may be nil
+func newSQLQuoting(d SqlDialect) sqlQuoting {
+	_, ok := d.(backslashEscaper)
+	return sqlQuoting{backslashes: ok}
+}
+
+// the quoting to carry on with after an annotation that resets it, keeping
+// the dialect's escapes and, if keepDelim is set, the delimiter
+func (q *sqlQuoting) reset(keepDelim bool) sqlQuoting {
+	r := sqlQuoting{backslashes: q.backslashes}
+	if keepDelim {
+		r.delim = q.delim
+	}
+	return r
+}
+
+// open the quote or comment that starts at s[i:], if one does, returning
+// the length of its opening, or 0. Backslashes escape within single
+// quoted strings where the dialect takes them, and within postgres E'...'
+// strings.
+func (q *sqlQuoting) open(s string, i int) int {
+	switch c := s[i]; {
+	case strings.HasPrefix(s[i:], "/*"):
+		q.end = "*/"
+		return 2
+	case c == '\'':
+		q.end = "'"
+		q.escaping = q.backslashes || isEscapeString(s, i)
+		return 1
+	case c == '"':
+		q.end = `"`
+		return 1
+	case c == '$':
+		if tag := dollarTag.FindString(s[i:]); tag != "" {
+			q.end = tag
+			return len(tag)
+		}
+	}
+	return 0
+}
+
+// pass over what is at s[i:] within the quote or comment we are in,
+// returning its length, and reporting whether it closed the quote
+func (q *sqlQuoting) skip(s string, i int) (n int, closed bool) {
+	if q.escaping && s[i] == '\\' && i+1 < len(s) {
+		return 2, false
+	}
+	if strings.HasPrefix(s[i:], q.end) {
+		n = len(q.end)
+		q.end, q.escaping = "", false
+		return n, true
+	}
+	return 1, false
+}
+
+// report whether the ' at s[i] opens a postgres E'...' string
+func isEscapeString(s string, i int) bool {
+	if i == 0 || (s[i-1] != 'E' && s[i-1] != 'e') {
+		return false
+	}
+	if i == 1 {
+		return true
+	}
+	c := s[i-2]
+	return !(c == '_' || c == '$' || '0' <= c && c <= '9' || 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z')
+}
+
+func (q *sqlQuoting) delimiter() string {
+	if q.delim == "" {
+		return ";"
+	}
+	return q.delim
+}
+
+// report whether line ends with the delimiter outside of any quote or
+// comment, and so ends a statement.
+func (q *sqlQuoting) endsStatement(line string) bool {
+	delim := q.delimiter()
+	last := -1     // index of the last character outside of quotes and comments
+	delimEnd := -1 // index just past the last delimiter
+
+scan:
+	for i := 0; i < len(line); {
+		if q.end != "" {
+			comment := q.end == "*/"
+			n, closed := q.skip(line, i)
+			i += n
+			if closed && !comment {
+				last = i - 1
+			}
+			continue
+		}
+
+		// check for the delimiter first, as it may be $$ in mysql
+		if strings.HasPrefix(line[i:], delim) {
+			i += len(delim)
+			last, delimEnd = i-1, i
+			continue
+		}
+
+		if strings.HasPrefix(line[i:], "--") {
+			break scan
+		}
+		if n := q.open(line, i); n > 0 {
+			if q.end != "*/" {
+				last = i + n - 1
+			}
+			i += n
+			continue
+		}
+
+		if c := line[i]; c != ' ' && c != '\t' && c != '\r' {
+			last = i
+		}
+		i++
+	}
+
+	if q.end != "" || delimEnd < 0 || last != delimEnd-1 {
+		return false
+	}
+	q.cut = delimEnd - len(delim)
+	return true
 }
 
 // Split the given sql script into individual statements.
@@ -37,11 +219,19 @@ func endsWithSemicolon(line string) bool {
 // The base case is to simply split on semicolons, as these
 // naturally terminate a statement.
 //
-// However, more complex cases like pl/pgsql can have semicolons
-// within a statement. For these cases, we provide the explicit annotations
+// Semicolons within string literals, quoted identifiers, dollar quotes
+// and comments are ignored, so most pl/pgsql bodies need no annotations.
+// 'Delimiter X' makes X end statements instead of semicolons for the
+// rest of the section, like DELIMITER in the mysql client.
+// For the cases that remain, we provide the explicit annotations
 // 'StatementBegin' and 'StatementEnd' to allow the script to
 // tell us to ignore semicolons.
-func splitSQLStatements(r io.Reader, direction Direction) (stmts []string) {
+//
+// useTx reports whether the statements should run in a transaction, which
+// they do unless the script is annotated with 'NO TRANSACTION'. A script
+// with no Up or Down annotation at all, as an empty file has none, fails
+// with errNoAnnotations.
+func splitSQLStatements(r io.Reader, direction Direction, d SqlDialect) (stmts []string, useTx bool, err error) {
 	var buf bytes.Buffer
 	scanner := bufio.NewScanner(r)
 
@@ -53,6 +243,9 @@ func splitSQLStatements(r io.Reader, direction Direction) (stmts []string) {
 	statementEnded := false
 	ignoreSemicolons := false
 	directionIsActive := false
+	useTx = true
+
+	quoting := newSQLQuoting(d)
 
 	for scanner.Scan() {
 
@@ -61,20 +254,28 @@ func splitSQLStatements(r io.Reader, direction Direction) (stmts []string) {
 		// handle any goose-specific commands
 		if strings.HasPrefix(line, sqlCmdPrefix) {
 			cmd := strings.TrimSpace(line[len(sqlCmdPrefix):])
+			if f := strings.Fields(cmd); len(f) == 2 && f[0] == "Delimiter" {
+				if directionIsActive {
+					quoting.delim = f[1]
+				}
+			}
 			switch cmd {
 			case "Up":
 				directionIsActive = (direction == DirectionUp)
 				upSections++
+				quoting = quoting.reset(false)
 				break
 
 			case "Down":
 				directionIsActive = (direction == DirectionDown)
 				downSections++
+				quoting = quoting.reset(false)
 				break
 
 			case "StatementBegin":
 				if directionIsActive {
 					ignoreSemicolons = true
+					quoting = quoting.reset(true)
 				}
 				break
 
@@ -84,6 +285,10 @@ func splitSQLStatements(r io.Reader, direction Direction) (stmts []string) {
 					ignoreSemicolons = false
 				}
 				break
+
+			case "NO TRANSACTION":
+				useTx = false
+				break
 			}
 		}
 
@@ -91,14 +296,20 @@ func splitSQLStatements(r io.Reader, direction Direction) (stmts []string) {
 			continue
 		}
 
-		if _, err := buf.WriteString(line + "\n"); err != nil {
-			log.Fatalf("io err: %v", err)
-		}
-
 		// Wrap up the two supported cases: 1) basic with semicolon; 2) psql statement
 		// Lines that end with semicolon that are in a statement block
 		// do not conclude statement.
-		if (!ignoreSemicolons && endsWithSemicolon(line)) || statementEnded {
+		ended := !ignoreSemicolons && quoting.endsStatement(line)
+		if ended && quoting.delim != "" && quoting.delim != ";" {
+			// a custom delimiter is not SQL, so leave it out, as the mysql client does
+			line = strings.TrimRight(line[:quoting.cut], " \t")
+		}
+
+		if _, err := buf.WriteString(line + "\n"); err != nil {
+			return nil, false, fmt.Errorf("io err: %w", err)
+		}
+
+		if ended || statementEnded {
 			statementEnded = false
 			stmts = append(stmts, buf.String())
 			buf.Reset()
@@ -106,26 +317,33 @@ func splitSQLStatements(r io.Reader, direction Direction) (stmts []string) {
 	}
 
 	if err := scanner.Err(); err != nil {
-		log.Fatalf("scanning migration: %v", err)
+		return nil, false, fmt.Errorf("scanning migration: %w", err)
 	}
 
 	// diagnose likely migration script errors
 	if ignoreSemicolons {
-		log.Println("WARNING: saw '-- +goose StatementBegin' with no matching '-- +goose StatementEnd'")
+		logger.Println("WARNING: saw '-- +goose StatementBegin' with no matching '-- +goose StatementEnd'")
+	}
+
+	if quoting.end != "" {
+		logger.Printf("WARNING: unterminated %s at the end of the migration\n", quoting.end)
 	}
 
 	if bufferRemaining := strings.TrimSpace(buf.String()); len(bufferRemaining) > 0 {
-		log.Printf("WARNING: Unexpected unfinished SQL query: %s. Missing a semicolon?\n", bufferRemaining)
+		logger.Printf("WARNING: Unexpected unfinished SQL query: %s. Missing a semicolon?\n", bufferRemaining)
 	}
 
 	if upSections == 0 && downSections == 0 {
-		log.Fatalf(`ERROR: no Up/Down annotations found, so no statements were executed.
-			See https://github.com/cloudcom/goose for details.`)
+		return nil, false, errNoAnnotations
 	}
 
-	return
+	return stmts, useTx, nil
 }
 
+// errNoAnnotations is returned by splitSQLStatements for a script with
+// neither an Up nor a Down annotation
+var errNoAnnotations = errors.New("no Up/Down annotations found, so no statements were executed. See https://github.com/cloudcom/goose for details")
+
 // Run a migration specified in raw SQL.
 //
 // Sections of the script can be annotated with a special comment,
@@ -134,36 +352,550 @@ func splitSQLStatements(r io.Reader, direction Direction) (stmts []string) {
 //
 // All statements following an Up or Down directive are grouped together
 // until another direction directive is found.
-func runSQLMigration(conf *DBConf, db *sql.DB, scriptFile string, v int64, direction Direction) error {
+//
+// Scripts annotated with 'NO TRANSACTION' run statement by statement on a
+// single connection instead, see runSQLMigrationNoTx.
+func runSQLMigration(ctx context.Context, conf *DBConf, db Executor, ins *versionInsert, scriptFile string, v int64, direction Direction) error {
+
+	stmts, useTx, rec, err := loadSQLMigration(conf, scriptFile, direction)
+	if err != nil {
+		return err
+	}
+
+	if !useTx {
+		return runSQLMigrationNoTx(ctx, conf, db, scriptFile, stmts, v, direction, rec)
+	}
+
+	return withDeadlockRetry(ctx, conf, func() error {
+		b, err := beginSQLBatch(ctx, conf, db, ins, scriptFile, v)
+		if err != nil {
+			return err
+		}
+
+		// Commits the transaction if successfully applied each statement and
+		// records the version into the version table or returns an error and
+		// rolls back the transaction.
+		if err = b.run(ctx, conf, scriptFile, stmts, v, direction, rec); err != nil {
+			b.rollback()
+			return err
+		}
+
+		if err = b.commit(); err != nil {
+			return fmt.Errorf("error finalizing migration: %w", err)
+		}
+
+		return nil
+	})
+}
 
-	txn, err := db.Begin()
+// read the statements of the SQL migration at scriptFile for direction,
+// along with what to record of it in the version table
+func loadSQLMigration(conf *DBConf, scriptFile string, direction Direction) ([]string, bool, MigrationRecord, error) {
+	rec := MigrationRecord{Source: filepath.Base(scriptFile)}
+
+	stmts, useTx, err := readSQLStatements(conf, scriptFile, direction)
+	if err != nil {
+		return nil, false, rec, err
+	}
+
+	if conf.RecordChecksums {
+		if rec.Checksum, err = migrationChecksum(scriptFile); err != nil {
+			return nil, false, rec, err
+		}
+	}
+
+	return stmts, useTx, rec, nil
+}
+
+// sqlBatch is a transaction that SQL migrations run in, one at a time or,
+// with DBConf.SingleTransaction, as many as can be in a row.
+//
+// The transaction runs on a connection of its own, so that any timeouts
+// the dialect cannot scope to the transaction are undone on it after.
+type sqlBatch struct {
+	conn  Conn
+	txn   Tx
+	ins   *versionInsert
+	reset []string
+}
+
+// begin a transaction with the search path and timeouts conf asks for,
+// failing as the migration at scriptFile if they cannot be set. Versions
+// are recorded with ins.
+func beginSQLBatch(ctx context.Context, conf *DBConf, db Executor, ins *versionInsert, scriptFile string, v int64) (*sqlBatch, error) {
+	conn, err := db.Conn(ctx)
 	if err != nil {
-		log.Fatal("db.Begin:", err)
+		return nil, err
 	}
 
-	f, err := os.Open(scriptFile)
+	// the transaction is rolled back by database/sql if ctx is cancelled
+	txn, err := conn.Begin(ctx)
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	// find each statement, checking annotations for up/down direction
-	// and execute each of them in the current transaction.
-	// Commits the transaction if successfully applied each statement and
-	// records the version into the version table or returns an error and
-	// rolls back the transaction.
-	for _, query := range splitSQLStatements(f, direction) {
-		log.Println("Executing Statement:")
-		log.Println(query)
-		if _, err = txn.Exec(query); err != nil {
-			txn.Rollback()
-			log.Fatalf("FAIL %s (%v), quitting migration.", filepath.Base(scriptFile), err)
+		conn.Close()
+		return nil, fmt.Errorf("db.Begin: %w", err)
+	}
+
+	b := &sqlBatch{conn: conn, txn: txn, ins: ins}
+
+	var set []string
+	set, b.reset = timeouts(conf, true)
+	if path, _ := searchPath(conf, true); path != "" {
+		set = append([]string{path}, set...)
+	}
+	for _, query := range set {
+		if _, err = txn.ExecContext(ctx, query); err != nil {
+			b.rollback()
+			return nil, &MigrationError{Version: v, Source: scriptFile, Statement: query, Err: err}
+		}
+	}
+
+	return b, nil
+}
+
+// execute each statement of the migration at scriptFile in the
+// transaction, then record its version.
+func (b *sqlBatch) run(ctx context.Context, conf *DBConf, scriptFile string, stmts []string, v int64, direction Direction, rec MigrationRecord) error {
+	for i, query := range stmts {
+		statementStarting(conf, v, i, stmts)
+		logger.Println("Executing Statement:")
+		logger.Println(query)
+		if _, err := b.txn.ExecContext(ctx, query); err != nil {
+			return &MigrationError{Version: v, Source: scriptFile, Statement: query, Err: err}
+		}
+	}
+
+	if err := b.ins.exec(ctx, conf, b.txn, direction, v, rec); err != nil {
+		return fmt.Errorf("error finalizing migration: %w", err)
+	}
+
+	return nil
+}
+
+func (b *sqlBatch) commit() error {
+	defer b.close()
+	return b.txn.Commit()
+}
+
+func (b *sqlBatch) rollback() {
+	defer b.close()
+	b.txn.Rollback()
+}
+
+func (b *sqlBatch) close() {
+	execAll(b.conn, b.reset)
+	b.conn.Close()
+}
+
+// Run the statements of a migration outside of a transaction, for those
+// that cannot run inside one such as CREATE INDEX CONCURRENTLY,
+// and then record the version.
+//
+// Nothing is rolled back if a statement fails, so the statements before it
+// stay applied and the version is not recorded.
+func runSQLMigrationNoTx(ctx context.Context, conf *DBConf, db Executor, scriptFile string, stmts []string, v int64, direction Direction, rec MigrationRecord) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// the search path outlives the statements on a session, so put it back
+	// before the connection returns to the pool
+	if set, reset := searchPath(conf, false); set != "" {
+		if _, err = conn.ExecContext(ctx, set); err != nil {
+			return &MigrationError{Version: v, Source: scriptFile, Statement: set, Err: err}
+		}
+		defer conn.ExecContext(context.Background(), reset)
+	}
+
+	set, reset := timeouts(conf, false)
+	defer execAll(conn, reset)
+	for _, query := range set {
+		if _, err = conn.ExecContext(ctx, query); err != nil {
+			return &MigrationError{Version: v, Source: scriptFile, Statement: query, Err: err}
+		}
+	}
+
+	for i, query := range stmts {
+		statementStarting(conf, v, i, stmts)
+		logger.Println("Executing Statement:")
+		logger.Println(query)
+		err = withDeadlockRetry(ctx, conf, func() error {
+			_, err := conn.ExecContext(ctx, query)
+			return err
+		})
+		if err != nil {
+			return &MigrationError{Version: v, Source: scriptFile, Statement: query, Err: err}
+		}
+	}
+
+	if err = insertVersion(ctx, conf, conn, direction, v, rec); err != nil {
+		return fmt.Errorf("error recording migration: %w", err)
+	}
+
+	return nil
+}
+
+// read the SQL migration at path and split it into statements for
+// direction, expanding variables first, throughout if conf says so and
+// otherwise within ENVSUB regions, then dropping the Dialect blocks of
+// other dialects, and stripping and rewriting the statements after as
+// conf says. The statements never run in a transaction if the dialect
+// can't run DDL in one.
+func readSQLStatements(conf *DBConf, path string, direction Direction) ([]string, bool, error) {
+	b, err := fs.ReadFile(baseFS, path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	script := string(b)
+	lookup := os.LookupEnv
+	if conf.Vars != nil {
+		lookup = func(name string) (string, bool) {
+			v, ok := conf.Vars[name]
+			return v, ok
+		}
+	}
+	if conf.SubstituteVars {
+		script = expandVars(script, lookup)
+	} else {
+		script = expandVarRegions(script, lookup)
+	}
+
+	unfiltered := script
+	if script, err = selectDialectBlocks(script, conf.Driver.Dialect); err != nil {
+		return nil, false, fmt.Errorf("%s:%v", filepath.Base(path), err)
+	}
+	if err := checkPsqlCommands(script, conf.Driver.Dialect); err != nil {
+		return nil, false, fmt.Errorf("%s:%v", filepath.Base(path), err)
+	}
+
+	// the halves of a split migration are all one section, so annotate
+	// them as such
+	switch {
+	case strings.HasSuffix(path, upFileSuffix):
+		script = sqlCmdPrefix + "Up\n" + script
+		unfiltered = sqlCmdPrefix + "Up\n" + unfiltered
+	case strings.HasSuffix(path, downFileSuffix):
+		script = sqlCmdPrefix + "Down\n" + script
+		unfiltered = sqlCmdPrefix + "Down\n" + unfiltered
+	}
+
+	stmts, useTx, err := splitSQLStatements(strings.NewReader(script), direction, conf.Driver.Dialect)
+	if err == errNoAnnotations && isNoOp(script) {
+		err = nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	useTx = useTx && ddlInTransaction(conf.Driver.Dialect)
+	if direction == DirectionUp && len(stmts) == 0 && !isNoOp(script) && !upInOtherDialects(unfiltered, conf.Driver.Dialect) {
+		return nil, false, fmt.Errorf("%s: Up section has no statements; annotate it '%sNO-OP' if it is meant to do nothing", filepath.Base(path), sqlCmdPrefix)
+	}
+	if conf.StripComments {
+		for i, stmt := range stmts {
+			stmts[i] = stripComments(stmt, conf.Driver.Dialect)
+		}
+	}
+	if conf.StatementRewriter != nil {
+		for i, stmt := range stmts {
+			if stmts[i], err = conf.StatementRewriter(conf.Driver.Dialect, stmt); err != nil {
+				return nil, false, fmt.Errorf("%s: rewriting statement %d: %w", filepath.Base(path), i+1, err)
+			}
+		}
+	}
+	for _, stmt := range stmts {
+		if copyFromStdin.MatchString(stmt) {
+			return nil, false, fmt.Errorf("%s: COPY ... FROM STDIN is not supported; load the data with a Go migration instead", filepath.Base(path))
+		}
+	}
+	return stmts, useTx, nil
+}
+
+// report whether script is annotated NO-OP, as a migration deliberately
+// applied without running anything
+func isNoOp(script string) bool {
+	for _, line := range strings.Split(script, "\n") {
+		if strings.HasPrefix(line, sqlCmdPrefix) && strings.TrimSpace(line[len(sqlCmdPrefix):]) == "NO-OP" {
+			return true
+		}
+	}
+	return false
+}
+
+// Blank out the lines of script between a '-- +goose Dialect <names>' and
+// the next '-- +goose Dialect end' unless d is one of the dialects named,
+// as registered with RegisterDialect, leaving the annotations blank too so
+// that line numbers hold. Names are separated by spaces or commas. Blocks
+// cannot nest or span an Up or Down annotation, and naming an unknown
+// dialect is an error. A nil d checks the blocks but keeps them all.
+func selectDialectBlocks(script string, d SqlDialect) (string, error) {
+	if !strings.Contains(script, sqlCmdPrefix+"Dialect") {
+		return script, nil
+	}
+
+	lines := strings.SplitAfter(script, "\n")
+	begin := 0 // line of the Dialect annotation of the open block
+	keep := true
+	for i, line := range lines {
+		if strings.HasPrefix(line, sqlCmdPrefix) {
+			cmd := strings.TrimSpace(line[len(sqlCmdPrefix):])
+			names := strings.FieldsFunc(cmd, func(r rune) bool { return r == ' ' || r == '\t' || r == ',' })
+			switch {
+			case len(names) > 0 && names[0] == "Dialect":
+				names = names[1:]
+				if len(names) == 1 && names[0] == "end" {
+					if begin == 0 {
+						return "", fmt.Errorf("%d: Dialect end with no matching Dialect", i+1)
+					}
+					begin, keep = 0, true
+				} else {
+					if begin != 0 {
+						return "", fmt.Errorf("%d: Dialect block within the Dialect block of line %d", i+1, begin)
+					}
+					if len(names) == 0 {
+						return "", fmt.Errorf("%d: Dialect annotation names no dialect", i+1)
+					}
+					begin, keep = i+1, d == nil
+					for _, name := range names {
+						nd, err := dialectByName(name)
+						if err != nil {
+							return "", fmt.Errorf("%d: %v", i+1, err)
+						}
+						keep = keep || sameDialect(nd, d)
+					}
+				}
+				lines[i] = "\n"
+				continue
+			case (cmd == "Up" || cmd == "Down") && begin != 0:
+				return "", fmt.Errorf("%d: %s annotation within the Dialect block of line %d", i+1, cmd, begin)
+			}
+		}
+		if !keep {
+			lines[i] = "\n"
+		}
+	}
+	if begin != 0 {
+		return "", fmt.Errorf("%d: Dialect block has no matching Dialect end", begin)
+	}
+	return strings.Join(lines, ""), nil
+}
+
+// report whether a and b are the same dialect, whether by pointer or not
+func sameDialect(a, b SqlDialect) bool {
+	ta, tb := reflect.TypeOf(a), reflect.TypeOf(b)
+	if ta == nil || tb == nil {
+		return false
+	}
+	if ta.Kind() == reflect.Ptr {
+		ta = ta.Elem()
+	}
+	if tb.Kind() == reflect.Ptr {
+		tb = tb.Elem()
+	}
+	return ta == tb
+}
+
+// report whether the Up section of script, before selectDialectBlocks
+// picked out d's blocks, has statements, so that one with none for d is
+// meant to do nothing on it rather than left empty by mistake
+func upInOtherDialects(script string, d SqlDialect) bool {
+	all, err := selectDialectBlocks(script, nil)
+	if err != nil || all == script {
+		return false
+	}
+	stmts, _, err := splitSQLStatements(strings.NewReader(all), DirectionUp, d)
+	return err == nil && len(stmts) > 0
+}
+
+// copyFromStdin matches a postgres COPY statement that reads its data from
+// the lines following it, which Exec has no way of sending.
+var copyFromStdin = regexp.MustCompile(`(?is)^(\s*--[^\n]*\n)*\s*COPY\s.*\sFROM\s+STDIN\b`)
+
+// Fail on the first psql meta-command in script, such as \copy or \i,
+// outside of quotes and comments. They are run by psql itself rather
+// than the server, so would otherwise fail with a syntax error.
+func checkPsqlCommands(script string, d SqlDialect) error {
+	q := newSQLQuoting(d)
+	for i, line := range strings.Split(script, "\n") {
+		if trimmed := strings.TrimLeft(line, " \t"); q.end == "" && strings.HasPrefix(trimmed, "\\") {
+			cmd := strings.Fields(trimmed)[0]
+			if cmd == `\.` {
+				return fmt.Errorf("%d: %s ends the data of a COPY ... FROM STDIN, which is not supported; load the data with a Go migration instead", i+1, cmd)
+			}
+			return fmt.Errorf("%d: psql meta-command %s is not supported; use plain SQL or a Go migration", i+1, cmd)
+		}
+		q.endsStatement(line)
+	}
+	return nil
+}
+
+// varRef matches ${NAME} and $NAME, plus the character following $NAME if it
+// is a $, as in a postgres dollar quote tag such as $body$.
+var varRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}|\$([A-Za-z_][A-Za-z0-9_]*)(\$?)`)
+
+// Expand the variable references in s that lookup knows of, like envsubst.
+// Positional parameters like $1 and dollar quote tags are left alone.
+func expandVars(s string, lookup func(string) (string, bool)) string {
+	return varRef.ReplaceAllStringFunc(s, func(ref string) string {
+		m := varRef.FindStringSubmatch(ref)
+		name := m[1]
+		if name == "" {
+			if m[3] != "" {
+				// a dollar quote tag
+				return ref
+			}
+			name = m[2]
+		}
+
+		if v, ok := lookup(name); ok {
+			return v
+		}
+		return ref
+	})
+}
+
+// Expand the variable references in s as expandVars does, but only on the
+// lines between a '-- +goose ENVSUB ON' and the next '-- +goose ENVSUB OFF',
+// or the end of s, leaving the rest verbatim.
+func expandVarRegions(s string, lookup func(string) (string, bool)) string {
+	if !strings.Contains(s, sqlCmdPrefix+"ENVSUB") {
+		return s
+	}
+
+	lines := strings.SplitAfter(s, "\n")
+	on := false
+	for i, line := range lines {
+		if strings.HasPrefix(line, sqlCmdPrefix) {
+			switch strings.TrimSpace(line[len(sqlCmdPrefix):]) {
+			case "ENVSUB ON":
+				on = true
+				continue
+			case "ENVSUB OFF":
+				on = false
+				continue
+			}
+		}
+		if on {
+			lines[i] = expandVars(line, lookup)
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// Remove the comments from stmt, in d's SQL, outside of quotes as
+// sqlQuoting tracks them, leaving /*+ */ and /*! */ hints in place. A
+// block comment leaves a space, so that the text either side of it doesn't
+// run together.
+func stripComments(stmt string, d SqlDialect) string {
+	var b strings.Builder
+	q := newSQLQuoting(d)
+
+	for i := 0; i < len(stmt); {
+		if q.end != "" {
+			n, _ := q.skip(stmt, i)
+			b.WriteString(stmt[i : i+n])
+			i += n
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(stmt[i:], "--"):
+			n := strings.IndexByte(stmt[i:], '\n')
+			if n < 0 {
+				n = len(stmt) - i
+			}
+			trimmed := strings.TrimRight(b.String(), " \t")
+			b.Reset()
+			b.WriteString(trimmed)
+			i += n
+			continue
+		case strings.HasPrefix(stmt[i:], "/*") && !strings.HasPrefix(stmt[i:], "/*!") && !strings.HasPrefix(stmt[i:], "/*+"):
+			n := strings.Index(stmt[i+2:], "*/")
+			if n < 0 {
+				return b.String()
+			}
+			b.WriteByte(' ')
+			i += n + 4
+			continue
+		}
+
+		// hints are kept whole, like quotes
+		if n := q.open(stmt, i); n > 0 {
+			b.WriteString(stmt[i : i+n])
+			i += n
+			continue
+		}
+		b.WriteByte(stmt[i])
+		i++
+	}
+
+	return b.String()
+}
+
+// the statements that make the dialect resolve names in the schema set with
+// SetSchema, if there is one and the dialect can.
+func searchPath(conf *DBConf, local bool) (set, reset string) {
+	ss, ok := conf.Driver.Dialect.(schemaSearcher)
+	if !ok || schemaName == "" {
+		return "", ""
+	}
+	return ss.searchPathSql(schemaName, local)
+}
+
+// the statements that apply conf's StatementTimeout and LockWaitTimeout,
+// as far as the dialect can.
+func timeouts(conf *DBConf, local bool) (set, reset []string) {
+	t, ok := conf.Driver.Dialect.(timeouter)
+	if !ok || (conf.StatementTimeout <= 0 && conf.LockWaitTimeout <= 0) {
+		return nil, nil
+	}
+	return t.timeoutSql(conf.StatementTimeout, conf.LockWaitTimeout, local)
+}
+
+// run each of stmts on conn, ignoring errors, as when putting a session
+// back the way it was before it returns to the pool
+func execAll(conn Conn, stmts []string) {
+	for _, stmt := range stmts {
+		conn.ExecContext(context.Background(), stmt)
+	}
+}
+
+// run the SQL migration at scriptFile in *batch, beginning it if need be,
+// for DBConf.SingleTransaction. A NO TRANSACTION migration cannot be part
+// of it, so the batch so far is committed first and the migration run on
+// its own. Nothing is committed or rolled back otherwise.
+func runSQLMigrationBatched(ctx context.Context, conf *DBConf, db Executor, ins *versionInsert, batch **sqlBatch, scriptFile string, v int64, direction Direction) error {
+	stmts, useTx, rec, err := loadSQLMigration(conf, scriptFile, direction)
+	if err != nil {
+		return err
+	}
+
+	if !useTx {
+		if err = commitSQLBatch(batch); err != nil {
 			return err
 		}
+		return runSQLMigrationNoTx(ctx, conf, db, scriptFile, stmts, v, direction, rec)
 	}
 
-	if err = FinalizeMigration(conf, txn, direction, v); err != nil {
-		log.Fatalf("error finalizing migration %s, quitting. (%v)", filepath.Base(scriptFile), err)
+	if *batch == nil {
+		if *batch, err = beginSQLBatch(ctx, conf, db, ins, scriptFile, v); err != nil {
+			return err
+		}
 	}
 
+	return (*batch).run(ctx, conf, scriptFile, stmts, v, direction, rec)
+}
+
+// commit *batch, if there is one, leaving none
+func commitSQLBatch(batch **sqlBatch) error {
+	if *batch == nil {
+		return nil
+	}
+	b := *batch
+	*batch = nil
+	if err := b.commit(); err != nil {
+		return fmt.Errorf("committing migrations: %w", err)
+	}
 	return nil
 }
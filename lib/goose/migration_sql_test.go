@@ -8,8 +8,9 @@ import (
 func TestSemicolons(t *testing.T) {
 
 	type testData struct {
-		line   string
-		result bool
+		line    string
+		dialect SqlDialect
+		result  bool
 	}
 
 	tests := []testData{
@@ -37,12 +38,80 @@ func TestSemicolons(t *testing.T) {
 			line:   "END \" ; \" -- comment",
 			result: false,
 		},
+		{
+			line:   "SELECT ';';",
+			result: true,
+		},
+		{
+			line:   "SELECT 'a;",
+			result: false,
+		},
+		{
+			line:   "SELECT 'it''s;' /* ; */;",
+			result: true,
+		},
+		{
+			line:    "SELECT 'it\\'s;';",
+			dialect: &MySqlDialect{},
+			result:  true,
+		},
+		{
+			line:    "SELECT 'it\\'s; x';",
+			dialect: &SpannerDialect{},
+			result:  true,
+		},
+		{
+			line:    "SELECT 'it\\'s;';",
+			dialect: &PostgresDialect{},
+			result:  false,
+		},
+		{
+			line:    "INSERT INTO path VALUES ('C:\\');",
+			dialect: &PostgresDialect{},
+			result:  true,
+		},
+		{
+			line:    "INSERT INTO path VALUES ('C:\\');",
+			dialect: &MariaDBDialect{},
+			result:  false,
+		},
+		{
+			line:    "SELECT \"x\\\";",
+			dialect: &MySqlDialect{},
+			result:  true,
+		},
+		{
+			line:    "SELECT E'it\\'s;', 'C:\\';",
+			dialect: &PostgresDialect{},
+			result:  true,
+		},
+		{
+			line:    "RETURNS text AS $$ SELECT 'C:\\'; $$;",
+			dialect: &MySqlDialect{},
+			result:  true,
+		},
+		{
+			line:   "SELECT 1 /* comment; */",
+			result: false,
+		},
+		{
+			line:   "RETURNS int AS $body$ SELECT 1;",
+			result: false,
+		},
+		{
+			line:   "RETURNS int AS $$ SELECT 1; $$;",
+			result: true,
+		},
+		{
+			line:   "SELECT * FROM post WHERE id = $1;",
+			result: true,
+		},
 	}
 
 	for _, test := range tests {
-		r := endsWithSemicolon(test.line)
+		r := endsWithSemicolon(test.line, test.dialect)
 		if r != test.result {
-			t.Errorf("incorrect semicolon. got %v, want %v", r, test.result)
+			t.Errorf("incorrect semicolon in %q for %T. got %v, want %v", test.line, test.dialect, r, test.result)
 		}
 	}
 }
@@ -51,8 +120,10 @@ func TestSplitStatements(t *testing.T) {
 
 	type testData struct {
 		sql       string
+		dialect   SqlDialect
 		direction Direction
 		count     int
+		useTx     bool
 	}
 
 	tests := []testData{
@@ -60,29 +131,129 @@ func TestSplitStatements(t *testing.T) {
 			sql:       functxt,
 			direction: DirectionUp,
 			count:     2,
+			useTx:     true,
 		},
 		{
 			sql:       functxt,
 			direction: DirectionDown,
 			count:     2,
+			useTx:     true,
 		},
 		{
 			sql:       multitxt,
 			direction: DirectionUp,
 			count:     2,
+			useTx:     true,
 		},
 		{
 			sql:       multitxt,
 			direction: DirectionDown,
 			count:     2,
+			useTx:     true,
+		},
+		{
+			sql:       quotedtxt,
+			direction: DirectionUp,
+			count:     4,
+			useTx:     true,
+		},
+		{
+			sql:       quotedtxt,
+			direction: DirectionDown,
+			count:     2,
+			useTx:     true,
+		},
+		{
+			sql:       delimtxt,
+			direction: DirectionUp,
+			count:     3,
+			useTx:     true,
+		},
+		{
+			sql:       delimtxt,
+			direction: DirectionDown,
+			count:     2,
+			useTx:     true,
+		},
+		{
+			sql:       notxtxt,
+			direction: DirectionUp,
+			count:     1,
+			useTx:     false,
+		},
+		{
+			sql:       backslashtxt,
+			dialect:   &PostgresDialect{},
+			direction: DirectionUp,
+			count:     2,
+			useTx:     true,
 		},
 	}
 
 	for _, test := range tests {
-		stmts := splitSQLStatements(strings.NewReader(test.sql), test.direction)
+		stmts, useTx, err := splitSQLStatements(strings.NewReader(test.sql), test.direction, test.dialect)
+		if err != nil {
+			t.Fatalf("splitting: %v", err)
+		}
 		if len(stmts) != test.count {
 			t.Errorf("incorrect number of stmts. got %v, want %v", len(stmts), test.count)
 		}
+		if useTx != test.useTx {
+			t.Errorf("incorrect useTx. got %v, want %v", useTx, test.useTx)
+		}
+	}
+}
+
+func TestSplitStatements_noAnnotations(t *testing.T) {
+	for _, sql := range []string{"", "CREATE TABLE post (id int);\n"} {
+		if _, _, err := splitSQLStatements(strings.NewReader(sql), DirectionUp, &PostgresDialect{}); err != errNoAnnotations {
+			t.Errorf("splitting %q: got %v, want errNoAnnotations", sql, err)
+		}
+	}
+}
+
+func TestExpandVars(t *testing.T) {
+
+	vars := map[string]string{
+		"SCHEMA": "app",
+		"SPACE":  "fast_disks",
+		"body":   "oops",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{
+			sql:  "CREATE TABLE ${SCHEMA}.post (id int) TABLESPACE $SPACE;",
+			want: "CREATE TABLE app.post (id int) TABLESPACE fast_disks;",
+		},
+		{
+			sql:  "SELECT * FROM $SCHEMA.post WHERE id = $1 AND title = $2;",
+			want: "SELECT * FROM app.post WHERE id = $1 AND title = $2;",
+		},
+		{
+			sql:  "CREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql;",
+			want: "CREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql;",
+		},
+		{
+			sql:  "CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql;",
+			want: "CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql;",
+		},
+		{
+			sql:  "SELECT '$UNSET', '${UNSET}';",
+			want: "SELECT '$UNSET', '${UNSET}';",
+		},
+	}
+
+	for _, test := range tests {
+		if got := expandVars(test.sql, lookup); got != test.want {
+			t.Errorf("incorrect expansion of %q. got %q, want %q", test.sql, got, test.want)
+		}
 	}
 }
 
@@ -145,3 +316,287 @@ CREATE TABLE fancier_post (
 -- +goose Down
 DROP TABLE fancier_post;
 `
+
+// test a script that must run outside of a transaction
+var notxtxt = `-- +goose NO TRANSACTION
+-- +goose Up
+CREATE INDEX CONCURRENTLY post_title_idx ON post (title);
+
+-- +goose Down
+DROP INDEX CONCURRENTLY post_title_idx;
+`
+
+// a backslash is just a character in a postgres string, so doesn't escape
+// the quote ending it
+var backslashtxt = `-- +goose Up
+INSERT INTO path (value) VALUES ('C:\');
+INSERT INTO path (value) VALUES ('D:\');
+`
+
+// test semicolons within quotes and comments, with no StatementBegin/End
+var quotedtxt = `-- +goose Up
+CREATE TABLE post (
+    id int NOT NULL,
+    title text DEFAULT 'untitled; for now',
+    PRIMARY KEY(id)
+);
+
+/* a comment;
+   over several lines; */
+CREATE OR REPLACE FUNCTION post_count() RETURNS bigint AS $$
+DECLARE
+  n bigint;
+BEGIN
+  SELECT count(*) INTO n FROM post;
+  RETURN n;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE FUNCTION add_post(title text) RETURNS void AS $body$
+BEGIN
+  INSERT INTO post (id, title) VALUES (post_count() + 1, title);
+  RAISE NOTICE 'added post; %', title;
+END;
+$body$ LANGUAGE plpgsql;
+
+INSERT INTO post (id, title) VALUES (1, 'it''s;
+multiline');
+
+-- +goose Down
+DROP FUNCTION add_post(text); DROP FUNCTION post_count();
+DROP TABLE post;
+`
+
+// test a custom delimiter, as for mysql stored procedures
+var delimtxt = `-- +goose Up
+-- +goose Delimiter //
+CREATE PROCEDURE add_post(IN title text)
+BEGIN
+  INSERT INTO post (title) VALUES (title);
+  SELECT LAST_INSERT_ID();
+END //
+
+CREATE TRIGGER post_title BEFORE INSERT ON post FOR EACH ROW
+BEGIN
+  SET NEW.title = TRIM(NEW.title);
+END//
+-- +goose Delimiter ;
+CALL add_post('first; post');
+
+-- +goose Down
+-- +goose Delimiter $$
+DROP TRIGGER post_title$$
+DROP PROCEDURE add_post $$
+`
+
+func TestExpandVarRegions(t *testing.T) {
+
+	vars := map[string]string{"SCHEMA": "app"}
+	lookup := func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{
+			sql:  "SELECT '${SCHEMA}';\n",
+			want: "SELECT '${SCHEMA}';\n",
+		},
+		{
+			sql: `SELECT '${SCHEMA}';
+-- +goose ENVSUB ON
+CREATE TABLE ${SCHEMA}.post (id int);
+-- +goose ENVSUB OFF
+SELECT '$SCHEMA';
+`,
+			want: `SELECT '${SCHEMA}';
+-- +goose ENVSUB ON
+CREATE TABLE app.post (id int);
+-- +goose ENVSUB OFF
+SELECT '$SCHEMA';
+`,
+		},
+		{
+			// a region left open runs to the end
+			sql:  "-- +goose ENVSUB ON\nDROP TABLE ${SCHEMA}.post;",
+			want: "-- +goose ENVSUB ON\nDROP TABLE app.post;",
+		},
+	}
+
+	for _, test := range tests {
+		if got := expandVarRegions(test.sql, lookup); got != test.want {
+			t.Errorf("incorrect expansion of %q. got %q, want %q", test.sql, got, test.want)
+		}
+	}
+}
+
+func TestStripComments(t *testing.T) {
+
+	tests := []struct {
+		sql     string
+		dialect SqlDialect
+		want    string
+	}{
+		{
+			sql:  "-- +goose StatementBegin\n-- makes the table\nCREATE TABLE post (id int); -- trailing\n",
+			want: "\n\nCREATE TABLE post (id int);\n",
+		},
+		{
+			sql:  "SELECT/* a\nblock */1;\n",
+			want: "SELECT 1;\n",
+		},
+		{
+			sql:     "SELECT '-- not a comment', \"/* nor this */\", 'it\\'s -- kept';\n",
+			dialect: &MySqlDialect{},
+			want:    "SELECT '-- not a comment', \"/* nor this */\", 'it\\'s -- kept';\n",
+		},
+		{
+			sql:     "INSERT INTO path VALUES ('C:\\'); -- dropped\nSELECT 1; -- dropped too\n",
+			dialect: &PostgresDialect{},
+			want:    "INSERT INTO path VALUES ('C:\\');\nSELECT 1;\n",
+		},
+		{
+			sql:  "CREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; -- kept\n$body$ LANGUAGE sql; -- dropped\n",
+			want: "CREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; -- kept\n$body$ LANGUAGE sql;\n",
+		},
+		{
+			sql:  "SELECT /*+ INDEX(post) */ id FROM post /* dropped */ WHERE id = 1 /*!50001 LOCK IN SHARE MODE */;\n",
+			want: "SELECT /*+ INDEX(post) */ id FROM post   WHERE id = 1 /*!50001 LOCK IN SHARE MODE */;\n",
+		},
+	}
+
+	for _, test := range tests {
+		if got := stripComments(test.sql, test.dialect); got != test.want {
+			t.Errorf("incorrect stripping of %q. got %q, want %q", test.sql, got, test.want)
+		}
+	}
+}
+
+func TestSplitStatements_delimiter(t *testing.T) {
+	stmts, _, _ := splitSQLStatements(strings.NewReader(delimtxt), DirectionUp, &MySqlDialect{})
+	if len(stmts) != 3 {
+		t.Fatalf("incorrect number of stmts. got %v, want 3", len(stmts))
+	}
+	if !strings.HasSuffix(stmts[0], "  SELECT LAST_INSERT_ID();\nEND\n") {
+		t.Errorf("incorrect first stmt, delimiter not removed: %q", stmts[0])
+	}
+	if !strings.HasSuffix(stmts[2], "CALL add_post('first; post');\n") {
+		t.Errorf("incorrect last stmt, delimiter not reset: %q", stmts[2])
+	}
+
+	// the delimiter is reset at the end of each section
+	stmts, _, _ = splitSQLStatements(strings.NewReader(delimtxt), DirectionDown, &MySqlDialect{})
+	if len(stmts) != 2 || !strings.HasSuffix(stmts[1], "DROP PROCEDURE add_post\n") {
+		t.Errorf("incorrect down stmts: %q", stmts)
+	}
+}
+
+func TestCheckPsqlCommands(t *testing.T) {
+
+	tests := []struct {
+		sql  string
+		want string // "" if the script is fine
+	}{
+		{
+			sql:  "-- +goose Up\nCREATE TABLE post (id int);\n",
+			want: "",
+		},
+		{
+			sql:  "-- +goose Up\n\\copy post FROM 'post.csv' CSV\n",
+			want: "2: psql meta-command \\copy is not supported",
+		},
+		{
+			sql:  "-- +goose Up\n  \\i other.sql\n",
+			want: "2: psql meta-command \\i is not supported",
+		},
+		{
+			sql:  "-- +goose Up\nCOPY post FROM stdin;\n1\n\\.\n",
+			want: "4: \\. ends the data of a COPY ... FROM STDIN",
+		},
+		{
+			sql:  "-- +goose Up\nINSERT INTO post (title) VALUES ('a\n\\b');\n",
+			want: "",
+		},
+		{
+			sql:  "-- +goose Up\nCREATE FUNCTION f() RETURNS text AS $$\n\\x\n$$ LANGUAGE sql;\n",
+			want: "",
+		},
+	}
+
+	for _, test := range tests {
+		err := checkPsqlCommands(test.sql, &PostgresDialect{})
+		switch {
+		case test.want == "" && err != nil:
+			t.Errorf("unexpected error for %q: %v", test.sql, err)
+		case test.want != "" && (err == nil || !strings.HasPrefix(err.Error(), test.want)):
+			t.Errorf("incorrect error for %q. got %v, want %q...", test.sql, err, test.want)
+		}
+	}
+
+	for _, stmt := range []string{"COPY post FROM STDIN;\n", "-- +goose Up\ncopy post (id, title)\n  from stdin with csv;\n"} {
+		if !copyFromStdin.MatchString(stmt) {
+			t.Errorf("COPY FROM STDIN not detected in %q", stmt)
+		}
+	}
+	for _, stmt := range []string{"COPY post FROM '/tmp/post.csv';\n", "INSERT INTO copy_log VALUES ('COPY x FROM STDIN');\n"} {
+		if copyFromStdin.MatchString(stmt) {
+			t.Errorf("COPY FROM STDIN wrongly detected in %q", stmt)
+		}
+	}
+}
+
+func TestSelectDialectBlocks(t *testing.T) {
+
+	script := `-- +goose Up
+CREATE TABLE post (id int);
+-- +goose Dialect postgres, cockroach
+CREATE INDEX CONCURRENTLY post_id_idx ON post (id);
+-- +goose Dialect end
+-- +goose Dialect mysql
+CREATE INDEX post_id_idx ON post (id);
+-- +goose Dialect end
+`
+
+	tests := []struct {
+		d    SqlDialect
+		want string
+	}{
+		{&PostgresDialect{}, "-- +goose Up\nCREATE TABLE post (id int);\n\nCREATE INDEX CONCURRENTLY post_id_idx ON post (id);\n\n\n\n\n"},
+		{CockroachDialect{}, "-- +goose Up\nCREATE TABLE post (id int);\n\nCREATE INDEX CONCURRENTLY post_id_idx ON post (id);\n\n\n\n\n"},
+		{&MySqlDialect{}, "-- +goose Up\nCREATE TABLE post (id int);\n\n\n\n\nCREATE INDEX post_id_idx ON post (id);\n\n"},
+		// mariadb is a dialect of its own, so must be named
+		{&MariaDBDialect{}, "-- +goose Up\nCREATE TABLE post (id int);\n\n\n\n\n\n\n"},
+	}
+
+	for _, test := range tests {
+		got, err := selectDialectBlocks(script, test.d)
+		if err != nil {
+			t.Errorf("unexpected error for %T: %v", test.d, err)
+		} else if got != test.want {
+			t.Errorf("incorrect blocks for %T. got %q, want %q", test.d, got, test.want)
+		}
+	}
+
+	errTests := []struct {
+		sql  string
+		want string
+	}{
+		{"-- +goose Dialect postgress\nSELECT 1;\n-- +goose Dialect end\n", `1: unknown dialect "postgress"`},
+		{"-- +goose Dialect postgres\nSELECT 1;\n", "1: Dialect block has no matching Dialect end"},
+		{"SELECT 1;\n-- +goose Dialect end\n", "2: Dialect end with no matching Dialect"},
+		{"-- +goose Dialect\n", "1: Dialect annotation names no dialect"},
+		{"-- +goose Dialect mysql\n-- +goose Dialect sqlite3\n", "2: Dialect block within the Dialect block of line 1"},
+		{"-- +goose Up\n-- +goose Dialect mysql\nSELECT 1;\n-- +goose Down\n", "4: Down annotation within the Dialect block of line 2"},
+	}
+
+	for _, test := range errTests {
+		// nil checks the blocks of every dialect
+		_, err := selectDialectBlocks(test.sql, nil)
+		if err == nil || !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("incorrect error for %q. got %v, want %q...", test.sql, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package goose
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+// RetryPolicy says how often to retry an operation that fails with a
+// transient error, and how long to wait in between.
+type RetryPolicy struct {
+	Attempts int           // in all, including the first; 0 or 1 never retries
+	Backoff  time.Duration // before the first retry, doubling for each one after
+}
+
+// call f until it succeeds, fails with an error that isn't transient, or
+// runs out of the attempts p allows, returning its last error.
+func withRetry(ctx context.Context, p RetryPolicy, f func() error) error {
+	return retryOn(ctx, p, isTransient, "transient error", f)
+}
+
+// withRetry for the statements of a migration, which are retried as per
+// conf.DeadlockRetry where the dialect says they failed on a deadlock or
+// lock wait timeout, and so were rolled back by the database.
+func withDeadlockRetry(ctx context.Context, conf *DBConf, f func() error) error {
+	d, ok := conf.Driver.Dialect.(deadlockDetector)
+	if !ok {
+		return f()
+	}
+	return retryOn(ctx, conf.DeadlockRetry, d.isDeadlockError, "deadlock", f)
+}
+
+// call f until it succeeds, fails with an error retryable doesn't accept,
+// or runs out of the attempts p allows, returning its last error. what
+// names the errors retried, for logging.
+func retryOn(ctx context.Context, p RetryPolicy, retryable func(error) bool, what string, f func() error) error {
+	backoff := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= p.Attempts || !retryable(err) {
+			return err
+		}
+
+		logger.Printf("goose: retrying in %v after %s: %v\n", backoff, what, err)
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		backoff *= 2
+	}
+}
+
+// isTransient reports whether err looks like the connection to the
+// database was lost, rather than a problem with what was sent to it, so
+// that trying again may succeed.
+func isTransient(err error) bool {
+	if err == nil || errors.Is(err, ErrTableDoesNotExist) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return true
+	}
+
+	// postgres, and drivers reporting SQLSTATE like it: class 08 is a
+	// connection exception, and 57P01-57P03 a server shutting down or
+	// not yet accepting connections
+	var pe *pq.Error
+	if errors.As(err, &pe) {
+		return isTransientSQLState(string(pe.Code))
+	}
+	var se interface {
+		SQLState() string
+	}
+	if errors.As(err, &se) {
+		return isTransientSQLState(se.SQLState())
+	}
+	return false
+}
+
+func isTransientSQLState(state string) bool {
+	return strings.HasPrefix(state, "08") || state == "57P01" || state == "57P02" || state == "57P03"
+}
@@ -0,0 +1,78 @@
+package goose
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// SchemaFailurePolicy says what RunMigrationsOnSchemas does when
+// migrating one of its schemas fails.
+type SchemaFailurePolicy int
+
+const (
+	// StopOnSchemaFailure leaves the schemas after the one that failed
+	// as they are.
+	StopOnSchemaFailure SchemaFailurePolicy = iota
+	// ContinueOnSchemaFailure goes on to migrate the rest.
+	ContinueOnSchemaFailure
+)
+
+// RunMigrationsOnSchemas is RunMigrationsOnDb for each of schemas in
+// turn, as if SetSchema had been called with it first, for keeping the
+// schemas of many tenants of one database at the same version. Each
+// schema has a version table of its own, and a lock of its own, so
+// runs for different schemas don't wait on each other.
+//
+// Only dialects that run migrations in the schema, by setting the search
+// path to it, can be used: postgres, redshift, cockroach and yugabyte. On
+// the others SetSchema qualifies only the version table, and each pass
+// would run the migrations against the same database, so it fails before
+// migrating anything.
+//
+// It returns the error of each schema it migrated, nil for those that
+// succeeded, along with an error joining those of the schemas that
+// failed. Schemas left unmigrated by StopOnSchemaFailure are not in the
+// map. The schema set with SetSchema is restored once done.
+//
+// Each schema is set with SetSchema in turn, so, like SetSchema, it is not
+// safe to call while anything else in the package runs.
+func RunMigrationsOnSchemas(conf *DBConf, migrationsDir string, target int64, db *sql.DB, schemas []string, policy SchemaFailurePolicy) (map[string]error, error) {
+	return RunMigrationsOnSchemasContext(context.Background(), conf, migrationsDir, target, db, schemas, policy)
+}
+
+// RunMigrationsOnSchemasContext is RunMigrationsOnSchemas with a context;
+// see RunMigrationsOnDbContext. Cancelling ctx fails the schema in
+// flight, and, whatever the policy, stops before the next.
+func RunMigrationsOnSchemasContext(ctx context.Context, conf *DBConf, migrationsDir string, target int64, db *sql.DB, schemas []string, policy SchemaFailurePolicy) (map[string]error, error) {
+	if _, ok := conf.Driver.Dialect.(schemaSearcher); !ok {
+		return nil, fmt.Errorf("%T cannot run migrations in a schema, only qualify its version table, so cannot migrate schemas in turn", conf.Driver.Dialect)
+	}
+	defer func(schema string) { schemaName = schema }(schemaName)
+
+	results := make(map[string]error, len(schemas))
+	var errs []error
+	for _, schema := range schemas {
+		if ctx.Err() != nil {
+			errs = append(errs, ctx.Err())
+			break
+		}
+
+		logger.Printf("goose: migrating schema %s\n", schema)
+		err := SetSchema(schema)
+		if err == nil {
+			err = RunMigrationsOnDbContext(ctx, conf, migrationsDir, target, db)
+		}
+		results[schema] = err
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("schema %s: %w", schema, err))
+			if policy == StopOnSchemaFailure {
+				break
+			}
+		}
+	}
+
+	return results, errors.Join(errs...)
+}
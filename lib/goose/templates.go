@@ -50,9 +50,13 @@ func (fi bindataFileInfo) Sys() interface{} {
 var _templatesMigrationMainGoTmpl = []byte(`package main
 
 import (
-	"log"
 	"bytes"
+	"context"
+	"database/sql"
 	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
 
 	_ "{{.Import}}"
 	"github.com/CloudCom/goose/lib/goose"
@@ -60,6 +64,14 @@ import (
 
 func main() {
 
+	if err := goose.SetSchema({{ printf "%q" .Schema }}); err != nil {
+		log.Fatal(err)
+	}
+	if err := goose.SetTableName({{ printf "%q" .Table }}); err != nil {
+		log.Fatal(err)
+	}
+	goose.SetAppliedBy({{ printf "%q" .AppliedBy }})
+
 	var conf goose.DBConf
 	buf := bytes.NewBuffer({{ .Conf }})
 	if err := gob.NewDecoder(buf).Decode(&conf); err != nil {
@@ -72,14 +84,55 @@ func main() {
 	}
 	defer db.Close()
 
-	txn, err := db.Begin()
+	ctx := context.Background()
+	txn, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		log.Fatal("db.Begin:", err)
 	}
+{{ if .SearchPath }}
+	if _, err := txn.ExecContext(ctx, {{ printf "%q" .SearchPath }}); err != nil {
+		txn.Rollback()
+		log.Fatal("setting search_path: ", err)
+	}
+{{ end }}{{ range .Timeouts }}
+	if _, err := txn.ExecContext(ctx, {{ printf "%q" . }}); err != nil {
+		txn.Rollback()
+		log.Fatal("setting timeout: ", err)
+	}
+{{ end }}
+	var migration interface{} = {{ .Func }}
+	switch f := migration.(type) {
+	case func(context.Context, *sql.Tx) error:
+		if f != nil {
+			err = f(ctx, txn)
+		}
+	case func(*sql.Tx) error:
+		if f != nil {
+			err = f(txn)
+		}
+	case func(*sql.Tx):
+		if f != nil {
+			f(txn)
+		}
+	default:
+		err = fmt.Errorf("unsupported signature %T", migration)
+	}
+	if errors.Is(err, goose.ErrSkip) {
+		// the version is recorded all the same, without what f did
+		log.Printf("goose: skipped %s: %v", {{ printf "%q" .Source }}, err)
+		txn.Rollback()
+		if txn, err = db.BeginTx(ctx, nil); err != nil {
+			log.Fatal("db.Begin:", err)
+		}
+	} else if err != nil {
+		txn.Rollback()
+		log.Fatal("{{ .Func }}: ", err)
+	}
 
-	{{ .Func }}(txn)
-
-	err = goose.FinalizeMigration(&conf, txn, {{ .Direction }}, {{ .Version }})
+	err = goose.FinalizeMigrationRecord(&conf, txn, {{ if .Direction }}goose.DirectionUp{{ else }}goose.DirectionDown{{ end }}, {{ .Version }}, goose.MigrationRecord{
+		Checksum: {{ printf "%q" .Checksum }},
+		Source:   {{ printf "%q" .Source }},
+	})
 	if err != nil {
 		log.Fatal("Commit() failed:", err)
 	}
@@ -105,17 +158,18 @@ func templatesMigrationMainGoTmpl() (*asset, error) {
 var _templatesMigrationGoTmpl = []byte(`package main
 
 import (
+	"context"
 	"database/sql"
 )
 
 // Up is executed when this migration is applied
-func Up_{{ . }}(txn *sql.Tx) {
-
+func Up_{{ .Version }}(ctx context.Context, txn *sql.Tx) error {
+	return nil
 }
 
 // Down is executed when this migration is rolled back
-func Down_{{ . }}(txn *sql.Tx) {
-
+func Down_{{ .Version }}(ctx context.Context, txn *sql.Tx) error {
+	return nil
 }
 {{/* vim: set ft=go.gotexttmpl: */}}
 `)
@@ -0,0 +1,180 @@
+package goose
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// savepoint the version table is read under by RunMigrationsOnTx
+const versionTableSavepoint = "goose_version_table"
+
+// RunMigrationsOnTx is RunMigrationsOnDb on a transaction the caller
+// already has open: the version table is read, created if need be, and
+// updated in tx, and every statement runs in it. tx is never committed
+// or rolled back, which is left to the caller, so that migrating can be
+// one part of a larger piece of transactional work. If a migration
+// fails, tx is left as the failed statement left it, and only rolling
+// it back is safe.
+//
+// Only SQL migrations can run this way: Go migrations run in a program of
+// their own, on a connection of their own, and NO TRANSACTION migrations
+// cannot run in any transaction. The run fails before executing anything
+// if one of them is due. So does every run on a dialect that cannot run
+// DDL in a transaction, such as spanner, or that commits implicitly
+// around it, such as mysql, which would leave its migrations outside tx.
+//
+// The version table is read under a savepoint, so that a missing table
+// doesn't abort tx on databases such as postgres. Runs on redshift, which
+// has no savepoints, and mssql, whose T-SQL spells them differently, fail
+// before executing anything. No advisory lock is taken whatever
+// conf.LockMode says, as locking is up to the caller's transaction.
+func RunMigrationsOnTx(conf *DBConf, migrationsDir string, target int64, tx *sql.Tx) error {
+	return RunMigrationsOnTxContext(context.Background(), conf, migrationsDir, target, tx)
+}
+
+// RunMigrationsOnTxContext is RunMigrationsOnTx with a context.
+func RunMigrationsOnTxContext(ctx context.Context, conf *DBConf, migrationsDir string, target int64, tx *sql.Tx) error {
+	if !ddlInTransaction(conf.Driver.Dialect) {
+		return fmt.Errorf("%T cannot run DDL in a transaction, so cannot migrate in one", conf.Driver.Dialect)
+	}
+	if _, ok := conf.Driver.Dialect.(savepointless); ok {
+		return fmt.Errorf("%T has no savepoints to read the version table under, so cannot migrate in a transaction", conf.Driver.Dialect)
+	}
+
+	records, err := migrationRecordsTx(ctx, conf, tx)
+	if err != nil {
+		return err
+	}
+
+	migrations, err := collectMigrations(migrationsDir)
+	if err != nil {
+		return err
+	}
+	setMigrationsStatus(migrations, records)
+	sort.Sort(migrationSorter(migrations))
+
+	current := currentVersion(records)
+	ms, direction := migrationsToTarget(conf, migrations, current, target)
+	if len(ms) == 0 {
+		logger.Printf("goose: no migrations to run. current version: %d, target: %d\n", current, target)
+		return nil
+	}
+
+	// load every migration up front, so that nothing runs unless all can
+	type sqlMigration struct {
+		stmts []string
+		rec   MigrationRecord
+	}
+	loaded := make([]sqlMigration, len(ms))
+	for i, m := range ms {
+		script := m.script(direction)
+		if m.Type() != "sql" {
+			return fmt.Errorf("%s is a Go migration, which cannot run in the caller's transaction", filepath.Base(script))
+		}
+
+		stmts, useTx, rec, err := loadSQLMigration(conf, script, direction)
+		if err != nil {
+			return err
+		}
+		if !useTx {
+			return fmt.Errorf("%s is annotated NO TRANSACTION, so cannot run in a transaction", filepath.Base(script))
+		}
+		loaded[i] = sqlMigration{stmts, rec}
+	}
+
+	if err = checkWritable(ctx, conf.Driver.Dialect, sqlTx{tx}); err != nil {
+		return err
+	}
+
+	logger.Printf("goose: migrating db, current version: %d, target: %d\n", current, target)
+
+	set, reset := timeouts(conf, true)
+	if path, _ := searchPath(conf, true); path != "" {
+		set = append([]string{path}, set...)
+	}
+	for _, query := range set {
+		if _, err = tx.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("FAIL %w, quitting migration",
+				&MigrationError{Version: ms[0].Version, Source: ms[0].script(direction), Statement: query, Err: err})
+		}
+	}
+	defer func() {
+		for _, query := range reset {
+			tx.ExecContext(context.Background(), query)
+		}
+	}()
+
+	ins := prepareVersionInsert(ctx, conf, sqlTx{tx})
+	defer ins.close()
+
+	b := &sqlBatch{txn: sqlTx{tx}, ins: ins}
+	for i, m := range ms {
+		script := m.script(direction)
+		if direction == DirectionDown {
+			if err = checkApplied(records, m); err == nil {
+				err = confirmDown(conf, m)
+			}
+		}
+		if err == nil {
+			start := migrationStarting(conf, m, direction)
+			err = b.run(ctx, conf, script, loaded[i].stmts, m.Version, direction, loaded[i].rec)
+			migrationDone(conf, m, direction, start, err)
+		}
+		if err != nil {
+			var me *MigrationError
+			if !errors.As(err, &me) {
+				err = &MigrationError{Version: m.Version, Source: script, Err: err}
+			}
+			return fmt.Errorf("FAIL %w, quitting migration", readOnlyError(conf.Driver.Dialect, err))
+		}
+
+		logger.Println("OK   ", filepath.Base(script))
+	}
+
+	return nil
+}
+
+// migrationRecords in tx, creating the version table in it if need be
+func migrationRecordsTx(ctx context.Context, conf *DBConf, tx *sql.Tx) (map[int64]VersionRecord, error) {
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+versionTableSavepoint); err != nil {
+		return nil, fmt.Errorf("getting db version: %w", err)
+	}
+
+	records, err := queryMigrationRecords(ctx, conf, sqlTx{tx})
+	if err != ErrTableDoesNotExist {
+		if err != nil {
+			return nil, fmt.Errorf("getting db version: %w", err)
+		}
+		if _, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT "+versionTableSavepoint); err != nil {
+			return nil, fmt.Errorf("getting db version: %w", err)
+		}
+		return records, nil
+	}
+
+	if _, err = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+versionTableSavepoint); err != nil {
+		return nil, fmt.Errorf("getting db version: %w", err)
+	}
+
+	if conf.NoCreateVersionTable {
+		return nil, errVersionTableRequired(conf)
+	}
+	if err = initVersionTable(ctx, conf.Driver.Dialect, tx); err != nil {
+		if err != errVersionTableExists {
+			return nil, err
+		}
+		// created concurrently, for which tx must be rid of the failure
+		if _, err = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+versionTableSavepoint); err != nil {
+			return nil, fmt.Errorf("getting db version: %w", err)
+		}
+	}
+
+	records, err = queryMigrationRecords(ctx, conf, sqlTx{tx})
+	if err != nil {
+		return nil, fmt.Errorf("getting db version: %w", err)
+	}
+	return records, nil
+}
@@ -23,8 +23,9 @@ func writeTemplateToFile(path string, t *template.Template, data interface{}) (s
 	return f.Name(), nil
 }
 
+// copy src, which is read from baseFS, to dst on the OS filesystem
 func copyFile(dst, src string) (int64, error) {
-	sf, err := os.Open(src)
+	sf, err := baseFS.Open(src)
 	if err != nil {
 		return 0, err
 	}
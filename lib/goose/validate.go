@@ -0,0 +1,160 @@
+package goose
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Validate checks the migrations in dir without a database, for catching
+// malformed ones before they are run, such as in a pre-commit hook. It
+// reports every problem found rather than stopping at the first:
+//
+//   - migration files, as SetMigrationExtensions has them, not named
+//     <version>_<name>.ext, with version a positive integer
+//   - versions with more than one file, other than the .up.sql and
+//     .down.sql halves of a split migration, and .down.sql files with no
+//     .up.sql file
+//   - SQL migrations with no '-- +goose Up' annotation, which split ones
+//     do without, StatementBegin and StatementEnd annotations that don't
+//     pair up, and Dialect blocks that are unterminated or name an
+//     unknown dialect
+//
+// Other files are ignored. It returns nil if all is well. dir may list
+// several directories, as for CollectMigrations, whose versions must not
+// collide.
+func Validate(dir string) []error {
+	var errs []error
+	byVersion := map[int64][]string{}
+
+	for _, d := range migrationDirs(dir) {
+		err := fs.WalkDir(baseFS, d, func(name string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			ext := migrationExt(name)
+			if entry.IsDir() || ext == "" {
+				return nil
+			}
+
+			v, err := NumericComponent(name)
+			if err == nil && migrationName(name) == "" {
+				err = errors.New("no name after the version")
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid migration filename: %v", name, err))
+				return nil
+			}
+			byVersion[v] = append(byVersion[v], name)
+
+			if ext != ".go" {
+				errs = append(errs, validateSQLAnnotations(name)...)
+			}
+			return nil
+		})
+		if err != nil {
+			return append(errs, err)
+		}
+	}
+
+	var versions []int64
+	for v := range byVersion {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+
+	for _, v := range versions {
+		names := byVersion[v]
+		switch {
+		case len(names) == 1 && strings.HasSuffix(names[0], downFileSuffix):
+			errs = append(errs, fmt.Errorf("%s has no matching %s file", names[0], upFileSuffix))
+		case len(names) == 2 && isSplitPair(names[0], names[1]):
+		case len(names) > 1:
+			errs = append(errs, fmt.Errorf("more than one file specifies the migration for version %d (%s)",
+				v, strings.Join(names, ", ")))
+		}
+	}
+
+	return errs
+}
+
+// the name of the migration at path, between the version and the extension
+func migrationName(path string) string {
+	base := filepath.Base(path)
+	for _, suffix := range []string{upFileSuffix, downFileSuffix, migrationExt(base)} {
+		if strings.HasSuffix(base, suffix) {
+			base = strings.TrimSuffix(base, suffix)
+			break
+		}
+	}
+	return base[strings.Index(base, "_")+1:]
+}
+
+// report whether a and b are the .up.sql and .down.sql halves, in either
+// order, of the same split migration
+func isSplitPair(a, b string) bool {
+	if strings.HasSuffix(a, downFileSuffix) {
+		a, b = b, a
+	}
+	return strings.HasSuffix(a, upFileSuffix) && strings.HasSuffix(b, downFileSuffix) &&
+		strings.TrimSuffix(a, upFileSuffix) == strings.TrimSuffix(b, downFileSuffix)
+}
+
+// check the annotations of the SQL migration at path, as splitSQLStatements
+// and selectDialectBlocks read them, returning an error for each problem
+func validateSQLAnnotations(path string) (errs []error) {
+	b, err := fs.ReadFile(baseFS, path)
+	if err != nil {
+		return []error{err}
+	}
+	if _, err := selectDialectBlocks(string(b), nil); err != nil {
+		errs = append(errs, fmt.Errorf("%s:%v", path, err))
+	}
+
+	ups := 0
+	begin := 0 // line of the StatementBegin awaiting its StatementEnd
+	unterminated := func() {
+		if begin != 0 {
+			errs = append(errs, fmt.Errorf("%s:%d: StatementBegin has no matching StatementEnd", path, begin))
+			begin = 0
+		}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, sqlCmdPrefix) {
+			continue
+		}
+
+		switch strings.TrimSpace(line[len(sqlCmdPrefix):]) {
+		case "Up":
+			ups++
+			unterminated()
+		case "Down":
+			unterminated()
+		case "StatementBegin":
+			unterminated()
+			begin = n
+		case "StatementEnd":
+			if begin == 0 {
+				errs = append(errs, fmt.Errorf("%s:%d: StatementEnd with no matching StatementBegin", path, n))
+			}
+			begin = 0
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return append(errs, fmt.Errorf("%s: %v", path, err))
+	}
+	unterminated()
+
+	if ups == 0 && !isSplitSQL(path) {
+		errs = append(errs, fmt.Errorf("%s: no '%sUp' annotation", path, sqlCmdPrefix))
+	}
+	return errs
+}
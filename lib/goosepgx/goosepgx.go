@@ -0,0 +1,157 @@
+// Package goosepgx lets programs that use pgx natively, through a
+// *pgxpool.Pool, run goose migrations on the connections of the pool.
+//
+// Executor runs migrations on the pool itself, with pgx's own
+// transactions and connections, through goose's Executor interface:
+//
+//	conf := goosepgx.DBConf(pool, "db/migrations")
+//	err := goose.RunMigrationsOnExecutor(conf, conf.MigrationsDir, target, goosepgx.Executor(pool))
+//
+// The functions of goose that take a *sql.DB, such as Status, can be
+// handed OpenDB instead, which wraps the pool with pgx's database/sql
+// adapter, borrowing connections from the pool as goose needs them and
+// giving them back after.
+package goosepgx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/CloudCom/goose/lib/goose"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// OpenDB returns a *sql.DB drawing its connections from pool, for the
+// functions of goose that take one. Closing it leaves pool open.
+func OpenDB(pool *pgxpool.Pool) *sql.DB {
+	return stdlib.OpenDBFromPool(pool)
+}
+
+// Executor returns a goose.Executor running on pool, for
+// goose.RunMigrationsOnExecutor and goose.MigrateToExecutor. Transactions
+// and the connections goose reserves, as for its advisory lock, are
+// acquired from pool and released back to it when done.
+func Executor(pool *pgxpool.Pool) goose.Executor {
+	return poolExecutor{pool}
+}
+
+// DBConf returns a DBConf for migrating the database of pool with the
+// migrations in migrationsDir, with the postgres dialect. Go migrations,
+// which run in a program of their own, connect with the pgx database/sql
+// driver and the connection string of pool.
+func DBConf(pool *pgxpool.Pool, migrationsDir string) *goose.DBConf {
+	return &goose.DBConf{
+		MigrationsDir: migrationsDir,
+		Driver: goose.DBDriver{
+			Name:    "pgx",
+			Import:  "github.com/jackc/pgx/v5/stdlib",
+			Dialect: &goose.PostgresDialect{},
+			OpenStr: pool.Config().ConnString(),
+		},
+	}
+}
+
+// querier is what the pool, its connections and their transactions share
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+// the goose.Querier of q
+type pgxQuerier struct{ q querier }
+
+func (q pgxQuerier) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	tag, err := q.q.Exec(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return result(tag), nil
+}
+
+func (q pgxQuerier) QueryContext(ctx context.Context, query string, args ...interface{}) (goose.Rows, error) {
+	rows, err := q.q.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgxRows{rows}, nil
+}
+
+type poolExecutor struct{ pool *pgxpool.Pool }
+
+func (e poolExecutor) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return pgxQuerier{e.pool}.ExecContext(ctx, query, args...)
+}
+
+func (e poolExecutor) QueryContext(ctx context.Context, query string, args ...interface{}) (goose.Rows, error) {
+	return pgxQuerier{e.pool}.QueryContext(ctx, query, args...)
+}
+
+func (e poolExecutor) Begin(ctx context.Context) (goose.Tx, error) {
+	return begin(ctx, e.pool)
+}
+
+func (e poolExecutor) Conn(ctx context.Context) (goose.Conn, error) {
+	conn, err := e.pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return poolConn{pgxQuerier{conn}, conn}, nil
+}
+
+type poolConn struct {
+	pgxQuerier
+	conn *pgxpool.Conn
+}
+
+func (c poolConn) Begin(ctx context.Context) (goose.Tx, error) {
+	return begin(ctx, c.conn)
+}
+
+func (c poolConn) Close() error {
+	c.conn.Release()
+	return nil
+}
+
+// begin a transaction on b, the pool or one of its connections
+func begin(ctx context.Context, b interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}) (goose.Tx, error) {
+	tx, err := b.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pgxTx{pgxQuerier{tx}, tx}, nil
+}
+
+type pgxTx struct {
+	pgxQuerier
+	tx pgx.Tx
+}
+
+// database/sql transactions end with no context, so these end with one
+// of their own, which a cancelled run still rolls back with
+func (t pgxTx) Commit() error   { return t.tx.Commit(context.Background()) }
+func (t pgxTx) Rollback() error { return t.tx.Rollback(context.Background()) }
+
+type pgxRows struct{ pgx.Rows }
+
+func (r pgxRows) Close() error {
+	r.Rows.Close()
+	return r.Rows.Err()
+}
+
+// result is the sql.Result of a statement's command tag. Postgres has no
+// last insert id.
+type result pgconn.CommandTag
+
+func (r result) LastInsertId() (int64, error) {
+	return 0, errors.New("LastInsertId is not supported by postgres")
+}
+
+func (r result) RowsAffected() (int64, error) {
+	return pgconn.CommandTag(r).RowsAffected(), nil
+}
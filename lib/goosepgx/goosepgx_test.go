@@ -0,0 +1,56 @@
+package goosepgx
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CloudCom/goose/lib/goose"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunMigrationsOnPool(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_DATABASE_DSN")
+	if dsn == "" {
+		t.SkipNow()
+	}
+
+	md, err := ioutil.TempDir("", "goose")
+	require.NoError(t, err)
+	defer os.RemoveAll(md)
+	err = ioutil.WriteFile(filepath.Join(md, "20010203040506_setup.sql"),
+		[]byte("-- +goose Up\nCREATE TABLE test(value VARCHAR(20));\n-- +goose Down\nDROP TABLE test;\n"), 0600)
+	require.NoError(t, err)
+
+	pool, err := pgxpool.New(context.Background(), dsn)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	ctx := context.Background()
+	pool.Exec(ctx, "DROP TABLE goose_db_version")
+	pool.Exec(ctx, "DROP TABLE test")
+
+	conf := DBConf(pool, md)
+	res, err := goose.MigrateToExecutor(conf, conf.MigrationsDir, 20010203040506, Executor(pool))
+	require.NoError(t, err)
+	assert.Equal(t, goose.Result{Applied: []int64{20010203040506}, FinalVersion: 20010203040506}, res)
+
+	var version int64
+	err = pool.QueryRow(ctx, "SELECT MAX(version_id) FROM goose_db_version").Scan(&version)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040506), version)
+
+	// and the database/sql functions see the same, through OpenDB
+	db := OpenDB(pool)
+	current, err := goose.GetDBVersionOnDb(conf, db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20010203040506), current)
+	require.NoError(t, db.Close())
+
+	// the pool outlives db
+	require.NoError(t, goose.RunMigrationsOnExecutor(conf, conf.MigrationsDir, 0, Executor(pool)))
+}